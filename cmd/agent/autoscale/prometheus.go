@@ -0,0 +1,133 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package autoscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PrometheusSource reads a metric by running an instant query against a
+// Prometheus-compatible HTTP API. MetricType decides how the series of a
+// vector result are combined.
+type PrometheusSource struct {
+	URL        string
+	Query      string
+	MetricType string
+	Client     *http.Client
+}
+
+func NewPrometheusSource(baseURL string, query string, metricType string) *PrometheusSource {
+	return &PrometheusSource{
+		URL:        strings.TrimRight(baseURL, "/"),
+		Query:      query,
+		MetricType: metricType,
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+type prometheusResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+type prometheusSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+}
+
+// Value runs the query and returns its result. A query returning a vector
+// of several series yields the sum of their values for a total metric and
+// their average otherwise, so a query for a per-instance metric does not
+// have to be wrapped in sum() or avg().
+func (p *PrometheusSource) Value(ctx context.Context) (float64, error) {
+	u := p.URL + "/api/v1/query?" + url.Values{"query": {p.Query}}.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "error querying prometheus")
+	}
+
+	resp, err := p.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, errors.Wrap(err, "error querying prometheus")
+	}
+	defer resp.Body.Close()
+
+	var body prometheusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, errors.Wrapf(err, "error decoding prometheus response (HTTP %d)", resp.StatusCode)
+	}
+
+	if body.Status != "success" {
+		return 0, fmt.Errorf("prometheus query failed: %s: %s", body.ErrorType, body.Error)
+	}
+
+	switch body.Data.ResultType {
+	case "scalar":
+		var value []interface{}
+		if err := json.Unmarshal(body.Data.Result, &value); err != nil {
+			return 0, errors.Wrap(err, "error decoding prometheus scalar")
+		}
+		return sampleValue(value)
+	case "vector":
+		var samples []prometheusSample
+		if err := json.Unmarshal(body.Data.Result, &samples); err != nil {
+			return 0, errors.Wrap(err, "error decoding prometheus vector")
+		}
+		if len(samples) == 0 {
+			return 0, fmt.Errorf("prometheus query %q returned no data", p.Query)
+		}
+
+		var sum float64
+		for _, s := range samples {
+			v, err := sampleValue(s.Value)
+			if err != nil {
+				return 0, err
+			}
+			sum += v
+		}
+		if p.MetricType == MetricTotal {
+			return sum, nil
+		}
+		return sum / float64(len(samples)), nil
+	default:
+		return 0, fmt.Errorf("prometheus query %q returned a %s, expected a scalar or vector",
+			p.Query, body.Data.ResultType)
+	}
+}
+
+// sampleValue decodes a [timestamp, "value"] pair.
+func sampleValue(pair []interface{}) (float64, error) {
+	if len(pair) != 2 {
+		return 0, fmt.Errorf("malformed prometheus sample %v", pair)
+	}
+
+	s, ok := pair[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("malformed prometheus sample value %v", pair[1])
+	}
+
+	return strconv.ParseFloat(s, 64)
+}
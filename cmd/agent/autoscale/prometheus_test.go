@@ -0,0 +1,163 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// prometheusServer answers every query with a result holding values, as a
+// vector of one series per value, or as a scalar when scalar is set.
+func prometheusServer(t *testing.T, scalar bool, values ...string) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" || r.URL.Query().Get("query") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"missing query"}`)
+			return
+		}
+
+		if scalar {
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"scalar","result":[1500000000,%q]}}`, values[0])
+			return
+		}
+
+		series := make([]string, 0, len(values))
+		for i, v := range values {
+			series = append(series, fmt.Sprintf(`{"metric":{"instance":"%d"},"value":[1500000000,%q]}`, i, v))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%s]}}`, strings.Join(series, ","))
+	}))
+}
+
+func TestTargetTrackingPrometheus(t *testing.T) {
+	tests := []struct {
+		name       string
+		metricType string
+		target     float64
+		min, max   int
+		tolerance  float64
+		dampening  float64
+		scalar     bool
+		values     []string
+		current    int
+		want       int
+	}{
+		{name: "scale out", values: []string{"80", "80"}, current: 4, want: 7},
+		{name: "scale in", values: []string{"20", "20"}, current: 10, want: 4},
+		{name: "scale in dampened", dampening: 0.5, values: []string{"20"}, current: 10, want: 7},
+		{name: "within tolerance", tolerance: 0.1, values: []string{"53"}, current: 4, want: 4},
+		{name: "outside tolerance", tolerance: 0.1, values: []string{"60"}, current: 4, want: 5},
+		{name: "clamped to min", min: 2, values: []string{"0"}, current: 4, want: 2},
+		{name: "clamped to max", max: 5, values: []string{"500"}, current: 4, want: 5},
+		{name: "clamped without max", values: []string{"1e300"}, current: 4, want: MaxDesiredCount},
+		{name: "empty group", values: []string{"80"}, current: 0, want: 1},
+		{name: "scalar", scalar: true, values: []string{"100"}, current: 3, want: 6},
+		{name: "total sums series", metricType: MetricTotal, target: 100, values: []string{"150", "250"}, current: 2, want: 4},
+		{name: "total empty group", metricType: MetricTotal, target: 100, values: []string{"250"}, current: 0, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := prometheusServer(t, tt.scalar, tt.values...)
+			defer srv.Close()
+
+			policy := &TargetTracking{
+				Source:           NewPrometheusSource(srv.URL, "load", MetricAverage),
+				Target:           50,
+				MetricType:       MetricAverage,
+				Min:              tt.min,
+				Max:              tt.max,
+				Tolerance:        tt.tolerance,
+				ScaleInDampening: 1,
+			}
+			if tt.metricType != "" {
+				policy.MetricType = tt.metricType
+				policy.Source = NewPrometheusSource(srv.URL, "load", tt.metricType)
+			}
+			if tt.target != 0 {
+				policy.Target = tt.target
+			}
+			if tt.dampening != 0 {
+				policy.ScaleInDampening = tt.dampening
+			}
+			if err := policy.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := policy.Desired(context.Background(), tt.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Desired(%d) = %d, want %d", tt.current, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetTrackingPrometheusInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		scalar bool
+		values []string
+	}{
+		{name: "NaN", scalar: true, values: []string{"NaN"}},
+		{name: "NaN series", values: []string{"10", "NaN"}},
+		{name: "infinite", scalar: true, values: []string{"+Inf"}},
+		{name: "negative", values: []string{"-5"}},
+		{name: "empty result"},
+		{name: "malformed value", values: []string{"ten"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := prometheusServer(t, tt.scalar, tt.values...)
+			defer srv.Close()
+
+			policy := &TargetTracking{
+				Source:           NewPrometheusSource(srv.URL, "load", MetricAverage),
+				Target:           50,
+				MetricType:       MetricAverage,
+				ScaleInDampening: 1,
+			}
+
+			if got, err := policy.Desired(context.Background(), 4); err == nil {
+				t.Errorf("Desired = %d, want an error", got)
+			}
+		})
+	}
+}
+
+func TestPrometheusSourceCancelled(t *testing.T) {
+	srv := prometheusServer(t, true, "1")
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := NewPrometheusSource(srv.URL, "load", MetricAverage).Value(ctx); err == nil {
+		t.Error("expected a cancelled query to fail")
+	}
+}
+
+func TestPrometheusSourceError(t *testing.T) {
+	srv := prometheusServer(t, true, "1")
+	defer srv.Close()
+
+	if _, err := NewPrometheusSource(srv.URL, "", MetricAverage).Value(context.Background()); err == nil {
+		t.Error("expected a failed query to return an error")
+	}
+}
@@ -0,0 +1,218 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/joyent/tsg-cli/cmd/agent/cmon"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	MetricAverage = "average"
+	MetricTotal   = "total"
+
+	// MaxDesiredCount bounds the instance count when no maximum is
+	// configured, so that a runaway metric can not launch an unbounded
+	// number of instances.
+	MaxDesiredCount = 1000
+)
+
+// Source provides the current value of the metric a policy tracks.
+type Source interface {
+	Value(ctx context.Context) (float64, error)
+}
+
+// TargetTracking computes the instance count that holds a metric at a
+// target value. An average metric (e.g. CPU per instance) is assumed to
+// scale inversely with the number of instances; a total metric (e.g.
+// requests per second across the group) is divided by the target to get
+// the number of instances needed.
+type TargetTracking struct {
+	Source     Source
+	Target     float64
+	MetricType string
+	Min        int
+	Max        int
+
+	// Tolerance is the fraction of the target within which the metric is
+	// considered on target and the count is left alone.
+	Tolerance float64
+
+	// ScaleInDampening is the fraction of a computed scale-in that is
+	// applied at once, so that capacity is released gradually.
+	ScaleInDampening float64
+}
+
+// NewFromConfig returns the target tracking policy described by the
+// configuration, or nil if no autoscaling metric is configured. members
+// lists the instances whose metrics are read from CMON.
+func NewFromConfig(cfg *config.TritonClientConfig, members cmon.MembersFunc) (*TargetTracking, error) {
+	source, err := sourceFromConfig(cfg, members)
+	if err != nil || source == nil {
+		return nil, err
+	}
+
+	t := &TargetTracking{
+		Source:           source,
+		Target:           config.GetAutoscaleTarget(),
+		MetricType:       config.GetAutoscaleMetricType(),
+		Min:              config.GetAutoscaleMinCount(),
+		Max:              config.GetAutoscaleMaxCount(),
+		Tolerance:        config.GetAutoscaleTolerance(),
+		ScaleInDampening: config.GetAutoscaleScaleInDampening(),
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func sourceFromConfig(cfg *config.TritonClientConfig, members cmon.MembersFunc) (Source, error) {
+	if query := config.GetAutoscaleQuery(); query != "" {
+		promURL := config.GetAutoscalePrometheusURL()
+		if promURL == "" {
+			return nil, fmt.Errorf("a prometheus URL is required to autoscale on a query")
+		}
+
+		return NewPrometheusSource(promURL, query, config.GetAutoscaleMetricType()), nil
+	}
+
+	if name := config.GetAutoscaleCMONMetric(); name != "" {
+		metric, err := cmon.ParseMetric(name)
+		if err != nil {
+			return nil, err
+		}
+
+		aggregation := config.GetAutoscaleCMONAggregation()
+		if err := cmon.ValidateAggregation(aggregation); err != nil {
+			return nil, err
+		}
+
+		endpoint := config.GetAutoscaleCMONURL()
+		if endpoint == "" {
+			endpoint = cmon.DefaultEndpoint(cfg.Config.TritonURL)
+		}
+		if endpoint == "" {
+			return nil, fmt.Errorf("a CMON URL is required to autoscale on a CMON metric")
+		}
+
+		client, err := cmon.NewClient(cfg, endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		return &cmon.Source{
+			Client:      client,
+			Members:     members,
+			Metric:      metric,
+			Aggregation: aggregation,
+			RateWindow:  config.GetAutoscaleCMONRateWindow(),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+func (t *TargetTracking) Validate() error {
+	switch {
+	case t.Target <= 0:
+		return fmt.Errorf("autoscaling target value must be greater than 0")
+	case t.MetricType != MetricAverage && t.MetricType != MetricTotal:
+		return fmt.Errorf("unknown metric type %q (valid types: %s, %s)", t.MetricType, MetricAverage, MetricTotal)
+	case t.Min < 0:
+		return fmt.Errorf("minimum instance count must not be negative")
+	case t.Max > 0 && t.Max < t.Min:
+		return fmt.Errorf("maximum instance count %d is less than the minimum %d", t.Max, t.Min)
+	case t.Max == 0 && t.Min > MaxDesiredCount:
+		return fmt.Errorf("minimum instance count %d is more than %d, set a maximum instance count", t.Min, MaxDesiredCount)
+	case t.Tolerance < 0 || t.Tolerance >= 1:
+		return fmt.Errorf("target tolerance must be between 0 and 1")
+	case t.ScaleInDampening <= 0 || t.ScaleInDampening > 1:
+		return fmt.Errorf("scale-in dampening must be greater than 0 and at most 1")
+	}
+	return nil
+}
+
+// Desired returns the instance count for a group that currently has
+// current members counted toward its size. A metric value that is not a
+// finite, non-negative number is an error, so the group is left unchanged.
+func (t *TargetTracking) Desired(ctx context.Context, current int) (int, error) {
+	value, err := t.Source.Value(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		return 0, fmt.Errorf("metric value %v is not a finite, non-negative number", value)
+	}
+
+	desired := t.desired(current, value)
+
+	log.Info().
+		Str("tsg_name", config.GetTsgName()).
+		Str("notification_type", "TSG_AUTOSCALE").
+		Float64("metric_value", value).
+		Float64("target_value", t.Target).
+		Int("current", current).
+		Int("desired", desired).
+		Msgf("Computed desired instance count")
+
+	return desired, nil
+}
+
+func (t *TargetTracking) desired(current int, value float64) int {
+	max := t.Max
+	if max <= 0 {
+		max = MaxDesiredCount
+	}
+
+	// ratio is how far each instance is from its share of the target.
+	ratio := value / t.Target
+	if t.MetricType == MetricTotal && current > 0 {
+		ratio = value / (t.Target * float64(current))
+	}
+
+	var want float64
+	switch {
+	case current == 0 && t.MetricType == MetricTotal:
+		want = ratio
+	case current == 0:
+		// An empty group has no average to track, so start it with a
+		// single instance.
+		want = 1
+	case math.Abs(ratio-1) <= t.Tolerance:
+		want = float64(current)
+	default:
+		want = float64(current) * ratio
+	}
+
+	// The count is bounded before it is converted, so that a very large
+	// metric value can not overflow it.
+	desired := int(math.Ceil(math.Min(want, float64(max))))
+
+	if desired < current {
+		step := int(math.Ceil(float64(current-desired) * t.ScaleInDampening))
+		desired = current - step
+	}
+
+	if desired < t.Min {
+		desired = t.Min
+	}
+	if desired > max {
+		desired = max
+	}
+
+	return desired
+}
@@ -0,0 +1,88 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package cmon
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	certLifetime = 24 * time.Hour
+
+	// certClockSkew backdates certificates so that they are valid on a
+	// CMON server whose clock is slightly behind ours.
+	certClockSkew = 5 * time.Minute
+)
+
+// parseAccountKey decodes the account's PEM encoded SSH private key. CMON
+// only accepts RSA and ECDSA keys.
+func parseAccountKey(keyBytes []byte) (crypto.Signer, error) {
+	key, err := ssh.ParseRawPrivateKey(keyBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading SSH private key")
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("SSH key of type %T cannot authenticate to CMON, use an RSA or ECDSA key", key)
+}
+
+// newClientCertificate returns a certificate for the account's SSH key,
+// self-signed with that key, which is how CMON authenticates a client: it
+// checks the certificate is for one of the account's keys and the TLS
+// handshake proves the client holds it.
+func newClientCertificate(accountName string, key crypto.Signer) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating certificate serial number")
+	}
+
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: accountName},
+		NotBefore:    now.Add(-certClockSkew).Truncate(time.Second),
+		NotAfter:     now.Add(certLifetime).Truncate(time.Second),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating CMON client certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing generated certificate")
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
@@ -0,0 +1,173 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package cmon
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	triton "github.com/joyent/triton-go"
+	"github.com/joyent/tsg-cli/cmd/config"
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+// cmonServer requires the client certificate CMON does: self-signed, for
+// the account named in its subject and for the account's key.
+func cmonServer(t *testing.T, accountName string, accountKey crypto.PublicKey) *httptest.Server {
+	t.Helper()
+
+	want, err := x509.MarshalPKIXPublicKey(accountKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "# HELP cpu_user_usage User CPU utilization")
+		fmt.Fprintln(w, "cpu_user_usage 42")
+	}))
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			cert, err := x509.ParseCertificate(raw[0])
+			if err != nil {
+				return err
+			}
+			if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+				return fmt.Errorf("certificate is not self-signed: %v", err)
+			}
+			if cert.Subject.CommonName != accountName {
+				return fmt.Errorf("certificate is for %q", cert.Subject.CommonName)
+			}
+			got, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, want) {
+				return errors.New("certificate is not for the account key")
+			}
+			return nil
+		},
+	}
+	srv.StartTLS()
+
+	return srv
+}
+
+func TestClientScrapeWithAccountKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		block *pem.Block
+		key   crypto.Signer
+	}{
+		{name: "rsa", block: &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, key: rsaKey},
+		{name: "ecdsa", block: &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}, key: ecKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer viper.Reset()
+			viper.Set(iconfig.KeySshKeyMaterial, base64.StdEncoding.EncodeToString(pem.EncodeToMemory(tt.block)))
+
+			srv := cmonServer(t, "acct", tt.key.Public())
+			defer srv.Close()
+
+			c, err := NewClient(&config.TritonClientConfig{
+				Config: &triton.ClientConfig{AccountName: "acct"},
+			}, "https://cmon.example.com:9163")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Every instance host name is served by the test server.
+			transport := c.httpClient.Transport.(*http.Transport)
+			transport.TLSClientConfig.InsecureSkipVerify = true
+			transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+			}
+
+			samples, err := c.Scrape(context.Background(), "00000000-0000-0000-0000-000000000001")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if samples["cpu_user_usage"] != 42 {
+				t.Errorf("cpu_user_usage = %v, want 42", samples["cpu_user_usage"])
+			}
+		})
+	}
+}
+
+func TestNewClientNeedsKeyMaterial(t *testing.T) {
+	defer viper.Reset()
+	viper.Set(iconfig.KeySshKeyMaterial, "")
+
+	_, err := NewClient(&config.TritonClientConfig{
+		Config: &triton.ClientConfig{AccountName: "acct"},
+	}, "https://cmon.example.com:9163")
+	if err == nil {
+		t.Error("expected an error without key material")
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	material := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	tests := []struct {
+		name     string
+		material string
+		wantErr  bool
+	}{
+		{name: "key material", material: base64.StdEncoding.EncodeToString(material)},
+		{name: "ssh agent", wantErr: true},
+		{name: "not a key", material: base64.StdEncoding.EncodeToString([]byte("not a key")), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer viper.Reset()
+			viper.Set(iconfig.KeySshKeyMaterial, tt.material)
+
+			if err := ValidateCredentials(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCredentials = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
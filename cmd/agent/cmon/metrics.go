@@ -0,0 +1,153 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package cmon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Samples holds the metrics scraped from one instance, keyed by metric
+// name. The values of series that differ only in their labels are summed.
+type Samples map[string]float64
+
+// parseSamples reads metrics in the Prometheus text exposition format.
+func parseSamples(r io.Reader) (Samples, error) {
+	samples := Samples{}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, rest := line, ""
+		if i := strings.IndexAny(line, "{ \t"); i >= 0 {
+			name, rest = line[:i], line[i:]
+		}
+
+		if strings.HasPrefix(rest, "{") {
+			end := strings.LastIndex(rest, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated label set", lineNo)
+			}
+			rest = rest[end+1:]
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: metric %q has no value", lineNo, name)
+		}
+
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value for metric %q: %v", lineNo, name, err)
+		}
+
+		samples[name] += value
+	}
+
+	return samples, scanner.Err()
+}
+
+// Metric names the value computed for each instance: a metric, optionally
+// divided by another to give a utilization.
+type Metric struct {
+	Name    string
+	Divisor string
+}
+
+// ParseMetric parses a metric name, or two names separated by a slash such
+// as "mem_agg_usage/mem_limit".
+func ParseMetric(s string) (Metric, error) {
+	parts := strings.Split(s, "/")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+		if parts[i] == "" {
+			return Metric{}, fmt.Errorf("invalid CMON metric %q", s)
+		}
+	}
+
+	switch len(parts) {
+	case 1:
+		return Metric{Name: parts[0]}, nil
+	case 2:
+		return Metric{Name: parts[0], Divisor: parts[1]}, nil
+	default:
+		return Metric{}, fmt.Errorf("invalid CMON metric %q: at most one divisor is allowed", s)
+	}
+}
+
+func (m Metric) String() string {
+	if m.Divisor == "" {
+		return m.Name
+	}
+	return m.Name + "/" + m.Divisor
+}
+
+func (m Metric) value(numerator float64, samples Samples) (float64, error) {
+	if m.Divisor == "" {
+		return numerator, nil
+	}
+
+	divisor, ok := samples[m.Divisor]
+	if !ok {
+		return 0, fmt.Errorf("metric %q not found", m.Divisor)
+	}
+	if divisor == 0 {
+		return 0, fmt.Errorf("metric %q is 0", m.Divisor)
+	}
+
+	return numerator / divisor, nil
+}
+
+// Aggregations combining the values of the members of a group.
+const (
+	AggregateAverage = "average"
+	AggregateSum     = "sum"
+	AggregateMin     = "min"
+	AggregateMax     = "max"
+)
+
+func ValidateAggregation(aggregation string) error {
+	switch aggregation {
+	case AggregateAverage, AggregateSum, AggregateMin, AggregateMax:
+		return nil
+	}
+	return fmt.Errorf("unknown CMON aggregation %q (valid aggregations: %s, %s, %s, %s)",
+		aggregation, AggregateAverage, AggregateSum, AggregateMin, AggregateMax)
+}
+
+func aggregate(aggregation string, values []float64) float64 {
+	result := values[0]
+	for _, v := range values[1:] {
+		switch aggregation {
+		case AggregateMin:
+			if v < result {
+				result = v
+			}
+		case AggregateMax:
+			if v > result {
+				result = v
+			}
+		default:
+			result += v
+		}
+	}
+
+	if aggregation == AggregateAverage {
+		result /= float64(len(values))
+	}
+
+	return result
+}
@@ -0,0 +1,135 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package cmon
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSamples(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Samples
+		wantErr bool
+	}{
+		{name: "empty", input: "", want: Samples{}},
+		{
+			name: "comments and blank lines",
+			input: "# HELP load_average Load average\n" +
+				"# TYPE load_average gauge\n" +
+				"\n" +
+				"load_average 0.5\n",
+			want: Samples{"load_average": 0.5},
+		},
+		{name: "timestamp", input: "cpu_user_usage 42 1523232000000\n", want: Samples{"cpu_user_usage": 42}},
+		{name: "tab separated", input: "mem_limit\t1024\n", want: Samples{"mem_limit": 1024}},
+		{
+			name: "labels summed",
+			input: `net_agg_bytes_in{interface="net0"} 10` + "\n" +
+				`net_agg_bytes_in{interface="net1"} 5` + "\n",
+			want: Samples{"net_agg_bytes_in": 15},
+		},
+		{name: "braces in a label value", input: `zfs_used{path="/a}b"} 3` + "\n", want: Samples{"zfs_used": 3}},
+		{name: "exponent", input: "mem_agg_usage 1.5e+09\n", want: Samples{"mem_agg_usage": 1.5e9}},
+		{name: "unterminated labels", input: `mem_limit{zone="a" 1` + "\n", wantErr: true},
+		{name: "no value", input: "mem_limit\n", wantErr: true},
+		{name: "invalid value", input: "mem_limit lots\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSamples(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSamples = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSamples = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMetric(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Metric
+		wantErr bool
+	}{
+		{input: "load_average", want: Metric{Name: "load_average"}},
+		{input: "mem_agg_usage/mem_limit", want: Metric{Name: "mem_agg_usage", Divisor: "mem_limit"}},
+		{input: " mem_agg_usage / mem_limit ", want: Metric{Name: "mem_agg_usage", Divisor: "mem_limit"}},
+		{input: "", wantErr: true},
+		{input: "mem_agg_usage/", wantErr: true},
+		{input: "/mem_limit", wantErr: true},
+		{input: "a/b/c", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMetric(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMetric(%q) = %v, want error %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMetric(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestMetricValue(t *testing.T) {
+	samples := Samples{"mem_agg_usage": 512, "mem_limit": 1024, "swap_limit": 0}
+
+	tests := []struct {
+		metric  Metric
+		want    float64
+		wantErr bool
+	}{
+		{metric: Metric{Name: "mem_agg_usage"}, want: 512},
+		{metric: Metric{Name: "mem_agg_usage", Divisor: "mem_limit"}, want: 0.5},
+		{metric: Metric{Name: "mem_agg_usage", Divisor: "swap_limit"}, wantErr: true},
+		{metric: Metric{Name: "mem_agg_usage", Divisor: "cpu_cap"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.metric.value(samples["mem_agg_usage"], samples)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: value = %v, want error %v", tt.metric, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: value = %v, want %v", tt.metric, got, tt.want)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		aggregation string
+		values      []float64
+		want        float64
+	}{
+		{aggregation: AggregateAverage, values: []float64{1, 2, 6}, want: 3},
+		{aggregation: AggregateSum, values: []float64{1, 2, 6}, want: 9},
+		{aggregation: AggregateMin, values: []float64{2, 1, 6}, want: 1},
+		{aggregation: AggregateMax, values: []float64{2, 6, 1}, want: 6},
+		{aggregation: AggregateAverage, values: []float64{4}, want: 4},
+		{aggregation: AggregateSum, values: []float64{4}, want: 4},
+		{aggregation: AggregateMin, values: []float64{4}, want: 4},
+		{aggregation: AggregateMax, values: []float64{4}, want: 4},
+	}
+
+	for _, tt := range tests {
+		if got := aggregate(tt.aggregation, tt.values); got != tt.want {
+			t.Errorf("aggregate(%q, %v) = %v, want %v", tt.aggregation, tt.values, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+// Package cmon reads the per-instance metrics Triton Container Monitor
+// exposes for the members of a group.
+package cmon
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// scrapeParallelism bounds the number of instances scraped at once.
+	scrapeParallelism = 10
+
+	scrapeTimeout = 30 * time.Second
+)
+
+// Client scrapes the metrics CMON exposes for each instance, authenticating
+// with a certificate for the account's SSH key.
+type Client struct {
+	endpoint    *url.URL
+	accountName string
+	key         crypto.Signer
+	httpClient  *http.Client
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+// NewClient returns a client for the CMON endpoint of a data center, such
+// as https://cmon.us-east-1.triton.zone:9163. The TLS handshake is signed
+// with the account's SSH private key, so it has to be given as key
+// material; see ValidateCredentials.
+func NewClient(cfg *config.TritonClientConfig, endpoint string) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid CMON URL %q", endpoint)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid CMON URL %q", endpoint)
+	}
+
+	key, err := accountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		endpoint:    u,
+		accountName: cfg.Config.AccountName,
+		key:         key,
+	}
+
+	c.httpClient = &http.Client{
+		Timeout: scrapeTimeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				GetClientCertificate: c.clientCertificate,
+			},
+		},
+	}
+
+	return c, nil
+}
+
+// ValidateCredentials returns an error unless the account's SSH private key
+// is given as key material and is a key CMON accepts. The signer CloudAPI
+// requests are made with can not be used instead: CMON checks a TLS client
+// certificate, and both the certificate and the TLS handshake sign a digest
+// computed by the client, which an SSH agent can not sign, since it only
+// signs whole messages that it hashes itself.
+func ValidateCredentials() error {
+	_, err := accountKey()
+	return err
+}
+
+func accountKey() (crypto.Signer, error) {
+	keyBytes, err := config.GetTritonPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	if keyBytes == nil {
+		return nil, fmt.Errorf("authenticating to CMON needs the SSH private key given with --key-material, " +
+			"a key held by an SSH agent can not sign its TLS client certificate")
+	}
+
+	return parseAccountKey(keyBytes)
+}
+
+// DefaultEndpoint returns the CMON endpoint of the data center whose
+// CloudAPI is at cloudAPIURL, or "" if it cannot be derived.
+func DefaultEndpoint(cloudAPIURL string) string {
+	u, err := url.Parse(cloudAPIURL)
+	if err != nil {
+		return ""
+	}
+
+	host := u.Hostname()
+	if !strings.HasSuffix(host, ".api.joyent.com") {
+		return ""
+	}
+
+	dc := strings.TrimSuffix(host, ".api.joyent.com")
+	return fmt.Sprintf("https://cmon.%s.triton.zone:9163", dc)
+}
+
+// InstanceURL returns the URL of the metrics of an instance. CMON serves
+// each instance's metrics on a host named after the instance ID.
+func (c *Client) InstanceURL(instanceID string) string {
+	u := *c.endpoint
+	u.Host = instanceID + "." + u.Host
+	u.Path = "/metrics"
+	return u.String()
+}
+
+// Scrape returns the current metrics of an instance.
+func (c *Client) Scrape(ctx context.Context, instanceID string) (Samples, error) {
+	req, err := http.NewRequest(http.MethodGet, c.InstanceURL(instanceID), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error scraping CMON")
+	}
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "error scraping CMON")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CMON returned HTTP %d", resp.StatusCode)
+	}
+
+	samples, err := parseSamples(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing CMON metrics")
+	}
+
+	return samples, nil
+}
+
+// clientCertificate returns the certificate presented to CMON, replacing it
+// once half of its lifetime has passed so that a long-running agent never
+// presents an expired one.
+func (c *Client) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cert == nil || time.Until(c.cert.Leaf.NotAfter) < certLifetime/2 {
+		cert, err := newClientCertificate(c.accountName, c.key)
+		if err != nil {
+			return nil, err
+		}
+		c.cert = cert
+	}
+
+	return c.cert, nil
+}
+
+// MembersFunc returns the IDs of the instances whose metrics are read.
+type MembersFunc func(ctx context.Context) ([]string, error)
+
+// Source computes a metric for each member of a group from CMON and
+// aggregates it across the group.
+type Source struct {
+	Client      *Client
+	Members     MembersFunc
+	Metric      Metric
+	Aggregation string
+
+	// RateWindow, when set, treats the metric as a counter and uses its
+	// per-second rate over this window, scraping each member twice.
+	RateWindow time.Duration
+}
+
+func (s *Source) Value(ctx context.Context) (float64, error) {
+	ids, err := s.Members(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("no running instances to read %s from", s.Metric)
+	}
+
+	first := s.scrapeAll(ctx, ids)
+
+	var last map[string]Samples
+	if s.RateWindow > 0 {
+		select {
+		case <-time.After(s.RateWindow):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		last = s.scrapeAll(ctx, ids)
+	}
+
+	var values []float64
+	for _, id := range ids {
+		if first[id] == nil || (s.RateWindow > 0 && last[id] == nil) {
+			// The failed scrape has already been logged.
+			continue
+		}
+
+		value, err := s.instanceValue(first[id], last[id])
+		if err != nil {
+			log.Warn().
+				Str("tsg_name", config.GetTsgName()).
+				Str("instance_id", id).
+				Str("notification_type", "TSG_METRICS_UNAVAILABLE").
+				Str("description", err.Error()).
+				Msgf("Unable to read %s of an instance", s.Metric)
+			continue
+		}
+		values = append(values, value)
+	}
+
+	if len(values) == 0 {
+		return 0, fmt.Errorf("unable to read %s of any of %d instance(s)", s.Metric, len(ids))
+	}
+	// The other aggregations are estimated from the members that were read,
+	// but a sum leaving members out would under-report the group.
+	if s.Aggregation == AggregateSum && len(values) < len(ids) {
+		return 0, fmt.Errorf("unable to sum %s, read only %d of %d instance(s)", s.Metric, len(values), len(ids))
+	}
+
+	return aggregate(s.Aggregation, values), nil
+}
+
+func (s *Source) instanceValue(first, last Samples) (float64, error) {
+	value, ok := first[s.Metric.Name]
+	if !ok {
+		return 0, fmt.Errorf("metric %q not found", s.Metric.Name)
+	}
+
+	if s.RateWindow == 0 {
+		return s.Metric.value(value, first)
+	}
+
+	later, ok := last[s.Metric.Name]
+	if !ok {
+		return 0, fmt.Errorf("metric %q not found", s.Metric.Name)
+	}
+	if later < value {
+		return 0, fmt.Errorf("counter %q was reset", s.Metric.Name)
+	}
+
+	return s.Metric.value((later-value)/s.RateWindow.Seconds(), last)
+}
+
+// scrapeAll scrapes every instance, leaving out those that fail.
+func (s *Source) scrapeAll(ctx context.Context, ids []string) map[string]Samples {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]Samples, len(ids))
+		sem     = make(chan struct{}, scrapeParallelism)
+	)
+
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			samples, err := s.Client.Scrape(ctx, id)
+			if err != nil {
+				log.Warn().
+					Str("tsg_name", config.GetTsgName()).
+					Str("instance_id", id).
+					Str("notification_type", "TSG_METRICS_UNAVAILABLE").
+					Str("description", err.Error()).
+					Msgf("Unable to scrape CMON metrics of an instance")
+				return
+			}
+
+			mu.Lock()
+			results[id] = samples
+			mu.Unlock()
+		}(id)
+	}
+
+	wg.Wait()
+	return results
+}
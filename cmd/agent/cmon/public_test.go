@@ -0,0 +1,210 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package cmon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeScrapes serves, for each instance, the metrics text of its first
+// scrape, then of its second and later scrapes. An instance without
+// metrics fails to be scraped.
+type fakeScrapes map[string][]string
+
+// testClient returns a client scraping instances from an HTTP server
+// serving scrapes.
+func testClient(t *testing.T, scrapes fakeScrapes) *Client {
+	t.Helper()
+
+	var (
+		mu    sync.Mutex
+		count = map[string]int{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(r.Host, ".cmon.example.com")
+
+		mu.Lock()
+		n := count[id]
+		count[id]++
+		mu.Unlock()
+
+		bodies := scrapes[id]
+		if len(bodies) == 0 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if n >= len(bodies) {
+			n = len(bodies) - 1
+		}
+		fmt.Fprint(w, bodies[n])
+	}))
+	t.Cleanup(srv.Close)
+
+	return &Client{
+		endpoint: &url.URL{Scheme: "http", Host: "cmon.example.com"},
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				// Every instance host name is served by the test server.
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+				},
+			},
+		},
+	}
+}
+
+func TestSourceValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		scrapes     fakeScrapes
+		metric      Metric
+		aggregation string
+		rateWindow  time.Duration
+		want        float64
+		wantErr     bool
+	}{
+		{
+			name:        "average",
+			scrapes:     fakeScrapes{"a": {"load_average 1\n"}, "b": {"load_average 3\n"}},
+			metric:      Metric{Name: "load_average"},
+			aggregation: AggregateAverage,
+			want:        2,
+		},
+		{
+			name: "utilization",
+			scrapes: fakeScrapes{
+				"a": {"mem_agg_usage 256\nmem_limit 1024\n"},
+				"b": {"mem_agg_usage 768\nmem_limit 1024\n"},
+			},
+			metric:      Metric{Name: "mem_agg_usage", Divisor: "mem_limit"},
+			aggregation: AggregateMax,
+			want:        0.75,
+		},
+		{
+			name:        "average of the instances read",
+			scrapes:     fakeScrapes{"a": {"load_average 1\n"}, "b": {"load_average 3\n"}, "c": nil},
+			metric:      Metric{Name: "load_average"},
+			aggregation: AggregateAverage,
+			want:        2,
+		},
+		{
+			name:        "sum of every instance",
+			scrapes:     fakeScrapes{"a": {"load_average 1\n"}, "b": {"load_average 3\n"}},
+			metric:      Metric{Name: "load_average"},
+			aggregation: AggregateSum,
+			want:        4,
+		},
+		{
+			name:        "sum missing an instance",
+			scrapes:     fakeScrapes{"a": {"load_average 1\n"}, "b": {"load_average 3\n"}, "c": nil},
+			metric:      Metric{Name: "load_average"},
+			aggregation: AggregateSum,
+			wantErr:     true,
+		},
+		{
+			name:        "sum missing a metric",
+			scrapes:     fakeScrapes{"a": {"load_average 1\n"}, "b": {"mem_limit 3\n"}},
+			metric:      Metric{Name: "load_average"},
+			aggregation: AggregateSum,
+			wantErr:     true,
+		},
+		{
+			name:        "no instance read",
+			scrapes:     fakeScrapes{"a": nil, "b": nil},
+			metric:      Metric{Name: "load_average"},
+			aggregation: AggregateAverage,
+			wantErr:     true,
+		},
+		{
+			name: "rate",
+			scrapes: fakeScrapes{
+				"a": {"cpu_user_usage 100\n", "cpu_user_usage 110\n"},
+				"b": {"cpu_user_usage 500\n", "cpu_user_usage 530\n"},
+			},
+			metric:      Metric{Name: "cpu_user_usage"},
+			aggregation: AggregateAverage,
+			rateWindow:  125 * time.Millisecond,
+			want:        160,
+		},
+		{
+			name: "rate utilization",
+			scrapes: fakeScrapes{
+				"a": {"cpu_user_usage 100\ncpu_cap 100\n", "cpu_user_usage 110\ncpu_cap 200\n"},
+			},
+			metric:      Metric{Name: "cpu_user_usage", Divisor: "cpu_cap"},
+			aggregation: AggregateAverage,
+			rateWindow:  125 * time.Millisecond,
+			want:        0.4,
+		},
+		{
+			name: "counter reset",
+			scrapes: fakeScrapes{
+				"a": {"cpu_user_usage 100\n", "cpu_user_usage 110\n"},
+				"b": {"cpu_user_usage 500\n", "cpu_user_usage 20\n"},
+			},
+			metric:      Metric{Name: "cpu_user_usage"},
+			aggregation: AggregateAverage,
+			rateWindow:  125 * time.Millisecond,
+			want:        80,
+		},
+		{
+			name: "counter reset in a sum",
+			scrapes: fakeScrapes{
+				"a": {"cpu_user_usage 100\n", "cpu_user_usage 110\n"},
+				"b": {"cpu_user_usage 500\n", "cpu_user_usage 20\n"},
+			},
+			metric:      Metric{Name: "cpu_user_usage"},
+			aggregation: AggregateSum,
+			rateWindow:  125 * time.Millisecond,
+			wantErr:     true,
+		},
+		{
+			name:        "every counter reset",
+			scrapes:     fakeScrapes{"a": {"cpu_user_usage 100\n", "cpu_user_usage 10\n"}},
+			metric:      Metric{Name: "cpu_user_usage"},
+			aggregation: AggregateAverage,
+			rateWindow:  125 * time.Millisecond,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			for id := range tt.scrapes {
+				ids = append(ids, id)
+			}
+
+			s := &Source{
+				Client:      testClient(t, tt.scrapes),
+				Members:     func(context.Context) ([]string, error) { return ids, nil },
+				Metric:      tt.metric,
+				Aggregation: tt.aggregation,
+				RateWindow:  tt.rateWindow,
+			}
+
+			got, err := s.Value(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Value = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Value = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package daemon
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/rs/zerolog/log"
+)
+
+// jitterSource is only used from the Run loop, so it needs no locking.
+var jitterSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// ReconcileFunc performs a single reconciliation of a group.
+type ReconcileFunc func(ctx context.Context) error
+
+// Run calls reconcile repeatedly until stop is closed or ctx is done.
+// Reconciliations are spaced by the configured interval plus a random
+// jitter, and by an exponentially increasing backoff after consecutive
+// failures. An error from reconcile is logged and never ends the loop. Run
+// only checks stop between reconciliations, so a reconciliation in progress
+// finishes its creates and deletes before Run returns unless ctx is
+// cancelled.
+func Run(ctx context.Context, reconcile ReconcileFunc, stop <-chan struct{}) {
+	var failures int
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		start := time.Now()
+		err := reconcile(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+
+		delay := nextDelay(failures)
+
+		if err != nil {
+			log.Error().
+				Str("tsg_name", config.GetTsgName()).
+				Str("status", "failed").
+				Str("notification_type", "TSG_RECONCILE_ERROR").
+				Int("consecutive_failures", failures).
+				Dur("next_attempt_in", delay).
+				Err(err).
+				Msg("Reconciliation failed")
+		} else {
+			log.Debug().
+				Str("tsg_name", config.GetTsgName()).
+				Dur("duration", time.Since(start)).
+				Dur("next_attempt_in", delay).
+				Msg("Reconciliation complete")
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// nextDelay returns how long to wait before the next reconciliation. After
+// consecutive failures the interval is doubled for each failure, up to the
+// configured maximum backoff. A maximum backoff shorter than the interval is
+// raised to it, so that failures never make the agent reconcile more often.
+func nextDelay(failures int) time.Duration {
+	delay := config.GetAgentInterval()
+
+	maxBackoff := config.GetAgentMaxBackoff()
+	if maxBackoff < delay {
+		maxBackoff = delay
+	}
+	for i := 0; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if failures > 0 && delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	if jitter := config.GetAgentJitter(); jitter > 0 {
+		delay += time.Duration(jitterSource.Int63n(int64(jitter)))
+	}
+
+	return delay
+}
@@ -0,0 +1,46 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestNextDelay(t *testing.T) {
+	defer viper.Reset()
+
+	tests := []struct {
+		interval   time.Duration
+		maxBackoff time.Duration
+		failures   int
+		want       time.Duration
+	}{
+		{interval: time.Minute, maxBackoff: 15 * time.Minute, failures: 0, want: time.Minute},
+		{interval: time.Minute, maxBackoff: 15 * time.Minute, failures: 2, want: 4 * time.Minute},
+		{interval: time.Minute, maxBackoff: 15 * time.Minute, failures: 10, want: 15 * time.Minute},
+		// A maximum backoff below the interval never shortens the delay.
+		{interval: time.Hour, maxBackoff: 15 * time.Minute, failures: 0, want: time.Hour},
+		{interval: time.Hour, maxBackoff: 15 * time.Minute, failures: 3, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		viper.Set(config.KeyAgentInterval, tt.interval)
+		viper.Set(config.KeyAgentMaxBackoff, tt.maxBackoff)
+		viper.Set(config.KeyAgentJitter, 0)
+
+		if got := nextDelay(tt.failures); got != tt.want {
+			t.Errorf("nextDelay(%d) with interval %s and max backoff %s = %s, want %s",
+				tt.failures, tt.interval, tt.maxBackoff, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	TypeHTTP = "http"
+	TypeTCP  = "tcp"
+)
+
+// Check probes a service on an instance's primary IP. An HTTP check expects
+// a GET of Path to answer with ExpectedStatus; a TCP check expects a
+// connection to Port to be accepted.
+type Check struct {
+	Type           string
+	Port           int
+	Path           string
+	ExpectedStatus int
+	Interval       time.Duration
+	Timeout        time.Duration
+
+	// HealthyThreshold is how many consecutive successful probes a newly
+	// launched instance needs before it is considered ready.
+	HealthyThreshold int
+
+	// UnhealthyThreshold is how many consecutive failed probes make a
+	// member unhealthy.
+	UnhealthyThreshold int
+
+	// GracePeriod is how long after it was created a member is left
+	// unchecked, so that its service has time to start.
+	GracePeriod time.Duration
+
+	Client *http.Client
+}
+
+// NewFromConfig returns the health check described by the configuration,
+// or nil if no health check type is configured.
+func NewFromConfig() (*Check, error) {
+	checkType := config.GetHealthCheckType()
+	if checkType == "" {
+		return nil, nil
+	}
+
+	c := &Check{
+		Type:               checkType,
+		Port:               config.GetHealthCheckPort(),
+		Path:               normalizePath(config.GetHealthCheckPath()),
+		ExpectedStatus:     config.GetHealthCheckStatus(),
+		Interval:           config.GetHealthCheckInterval(),
+		Timeout:            config.GetHealthCheckTimeout(),
+		HealthyThreshold:   config.GetHealthyThreshold(),
+		UnhealthyThreshold: config.GetUnhealthyThreshold(),
+		GracePeriod:        config.GetHealthCheckGracePeriod(),
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	c.Client = &http.Client{
+		Timeout: c.Timeout,
+		// A redirect is reported as its own status rather than followed.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return c, nil
+}
+
+// normalizePath makes path absolute, so that it can be appended to the
+// host and port of an instance.
+func normalizePath(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// Validate returns an error if the check can not be run.
+func (c *Check) Validate() error {
+	switch c.Type {
+	case TypeHTTP, TypeTCP:
+	default:
+		return fmt.Errorf("unknown health check type %q (valid types: %s, %s)", c.Type, TypeHTTP, TypeTCP)
+	}
+
+	if c.Type == TypeHTTP && !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("health check path %q must start with \"/\"", c.Path)
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("a health check port between 1 and 65535 is required")
+	}
+	if c.Interval <= 0 || c.Timeout <= 0 {
+		return fmt.Errorf("health check interval and timeout must be positive")
+	}
+	if c.HealthyThreshold < 1 || c.UnhealthyThreshold < 1 {
+		return fmt.Errorf("healthy and unhealthy thresholds must be at least 1")
+	}
+
+	return nil
+}
+
+// Probe runs the check once against ip.
+func (c *Check) Probe(ctx context.Context, ip string) error {
+	if ip == "" {
+		return fmt.Errorf("instance has no primary IP")
+	}
+
+	addr := net.JoinHostPort(ip, strconv.Itoa(c.Port))
+
+	if c.Type == TypeTCP {
+		dialer := &net.Dialer{Timeout: c.Timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+c.Path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != c.ExpectedStatus {
+		return fmt.Errorf("GET %s returned %d, expected %d", c.Path, resp.StatusCode, c.ExpectedStatus)
+	}
+
+	return nil
+}
+
+// WaitHealthy probes ip every interval until HealthyThreshold consecutive
+// probes succeed or ctx is done, in which case the last probe error is
+// returned.
+func (c *Check) WaitHealthy(ctx context.Context, ip string) error {
+	var passed int
+	var lastErr error
+	for {
+		if err := c.Probe(ctx, ip); err != nil {
+			passed = 0
+			lastErr = err
+		} else if passed++; passed >= c.HealthyThreshold {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr == nil {
+				lastErr = ctx.Err()
+			}
+			return errors.Wrapf(lastErr, "%s:%d did not pass %d consecutive health checks", ip, c.Port, c.HealthyThreshold)
+		case <-time.After(c.Interval):
+		}
+	}
+}
+
+// Evaluate probes ip until a probe succeeds or UnhealthyThreshold
+// consecutive probes fail. It returns nil if ip is healthy and the last
+// probe error otherwise.
+func (c *Check) Evaluate(ctx context.Context, ip string) error {
+	for failed := 1; ; failed++ {
+		err := c.Probe(ctx, ip)
+		if err == nil || failed >= c.UnhealthyThreshold {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.Interval):
+		}
+	}
+}
+
+// InGracePeriod reports whether an instance created at created is still too
+// new to be checked.
+func (c *Check) InGracePeriod(created time.Time) bool {
+	return time.Since(created) < c.GracePeriod
+}
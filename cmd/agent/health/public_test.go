@@ -0,0 +1,265 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package health
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+// splitAddr returns the IP and port a test server listens on.
+func splitAddr(t *testing.T, addr string) (string, int) {
+	t.Helper()
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, port
+}
+
+func newHTTPCheck(port int) *Check {
+	return &Check{
+		Type:               TypeHTTP,
+		Port:               port,
+		Path:               "/health",
+		ExpectedStatus:     http.StatusOK,
+		Interval:           10 * time.Millisecond,
+		Timeout:            time.Second,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 1,
+		Client: &http.Client{
+			Timeout: time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func TestProbeHTTPStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.WriteHeader(http.StatusOK)
+		case "/starting":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/moved":
+			http.Redirect(w, r, "/health", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ip, port := splitAddr(t, srv.Listener.Addr().String())
+
+	tests := []struct {
+		path     string
+		expected int
+		healthy  bool
+	}{
+		{path: "/health", expected: http.StatusOK, healthy: true},
+		{path: "/starting", expected: http.StatusOK},
+		{path: "/starting", expected: http.StatusServiceUnavailable, healthy: true},
+		{path: "/moved", expected: http.StatusOK},
+		{path: "/moved", expected: http.StatusFound, healthy: true},
+		{path: "/missing", expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		c := newHTTPCheck(port)
+		c.Path = tt.path
+		c.ExpectedStatus = tt.expected
+
+		err := c.Probe(context.Background(), ip)
+		if tt.healthy && err != nil {
+			t.Errorf("GET %s expecting %d: %v", tt.path, tt.expected, err)
+		}
+		if !tt.healthy && err == nil {
+			t.Errorf("GET %s expecting %d passed, want it to fail", tt.path, tt.expected)
+		}
+	}
+}
+
+func TestProbeHTTPTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	ip, port := splitAddr(t, srv.Listener.Addr().String())
+
+	c := newHTTPCheck(port)
+	c.Client.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if err := c.Probe(context.Background(), ip); err == nil {
+		t.Fatal("expected a probe of a hung server to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("probe took %s, want it cut off by the timeout", elapsed)
+	}
+}
+
+func TestProbeTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, port := splitAddr(t, ln.Addr().String())
+
+	var accepted int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			conn.Close()
+		}
+	}()
+
+	c := &Check{Type: TypeTCP, Port: port, Timeout: time.Second}
+	if err := c.Probe(context.Background(), ip); err != nil {
+		t.Fatalf("probe of a listening port: %v", err)
+	}
+
+	// Nothing listens on the port once the listener is closed.
+	ln.Close()
+	if err := c.Probe(context.Background(), ip); err == nil {
+		t.Error("expected a probe of a closed port to fail")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Probe(ctx, ip); err == nil {
+		t.Error("expected a cancelled probe to fail")
+	}
+
+	if err := c.Probe(context.Background(), ""); err == nil {
+		t.Error("expected a probe without an IP to fail")
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first two probes fail, the rest pass.
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	ip, port := splitAddr(t, srv.Listener.Addr().String())
+
+	c := newHTTPCheck(port)
+	c.UnhealthyThreshold = 2
+	if err := c.Evaluate(context.Background(), ip); err == nil {
+		t.Error("expected two consecutive failures to be unhealthy")
+	}
+
+	c.HealthyThreshold = 3
+	if err := c.WaitHealthy(context.Background(), ip); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 5 {
+		t.Errorf("made %d probes, want 5", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c.Path = "/"
+	c.ExpectedStatus = http.StatusTeapot
+	if err := c.WaitHealthy(ctx, ip); err == nil {
+		t.Error("expected WaitHealthy to fail once ctx is done")
+	}
+}
+
+func TestInGracePeriod(t *testing.T) {
+	c := &Check{GracePeriod: time.Minute}
+
+	if !c.InGracePeriod(time.Now().Add(-30 * time.Second)) {
+		t.Error("instance created 30s ago is not in a 1m grace period")
+	}
+	if c.InGracePeriod(time.Now().Add(-2 * time.Minute)) {
+		t.Error("instance created 2m ago is in a 1m grace period")
+	}
+	if (&Check{}).InGracePeriod(time.Now()) {
+		t.Error("instance is in a grace period of 0")
+	}
+}
+
+func TestNewFromConfigPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ip, port := splitAddr(t, srv.Listener.Addr().String())
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/health", want: "/health"},
+		{path: "health", want: "/health"},
+		{path: "", want: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			defer viper.Reset()
+			viper.Set(iconfig.KeyHealthCheckType, TypeHTTP)
+			viper.Set(iconfig.KeyHealthCheckPort, port)
+			viper.Set(iconfig.KeyHealthCheckPath, tt.path)
+			viper.Set(iconfig.KeyHealthCheckStatus, http.StatusOK)
+			viper.Set(iconfig.KeyHealthCheckInterval, 10*time.Millisecond)
+			viper.Set(iconfig.KeyHealthCheckTimeout, time.Second)
+			viper.Set(iconfig.KeyHealthCheckHealthy, 1)
+			viper.Set(iconfig.KeyHealthCheckUnhealthy, 1)
+
+			c, err := NewFromConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Path != tt.want {
+				t.Errorf("Path = %q, want %q", c.Path, tt.want)
+			}
+			if err := c.Probe(context.Background(), ip); (err == nil) != (tt.want == "/health") {
+				t.Errorf("Probe = %v", err)
+			}
+		})
+	}
+
+	c := newHTTPCheck(port)
+	c.Path = "health"
+	if err := c.Validate(); err == nil {
+		t.Error("Validate accepted an HTTP check path without a leading \"/\"")
+	}
+	c.Type = TypeTCP
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate = %v for a TCP check, which has no path", err)
+	}
+}
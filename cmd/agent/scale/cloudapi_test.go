@@ -0,0 +1,335 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	triton "github.com/joyent/triton-go"
+	"github.com/joyent/triton-go/authentication"
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/config"
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+const testAccount = "acct"
+
+// fakeCloudAPI serves the parts of CloudAPI's machines endpoints the scale
+// package uses, backed by an in-memory list of instances.
+type fakeCloudAPI struct {
+	mu        sync.Mutex
+	instances map[string]*tcc.Instance
+	seq       int
+
+	// pageSize is the largest page ListMachines returns.
+	pageSize int
+
+	// createState, if set, is the state of newly created instances instead
+	// of running.
+	createState string
+
+	// stopState, if set, is the state of stopped instances instead of
+	// stopped.
+	stopState string
+
+	// countSkew is added to the count reported by each HEAD request in
+	// turn, to mimic instances created or deleted while a group is listed.
+	countSkew []int
+
+	// intercept, if set, is called before each request is served. A
+	// non-zero status is returned as a CloudAPI error instead.
+	intercept func(r *http.Request) int
+
+	// requests records each request as "METHOD path".
+	requests []string
+}
+
+func newFakeCloudAPI() *fakeCloudAPI {
+	return &fakeCloudAPI{
+		instances: make(map[string]*tcc.Instance),
+		pageSize:  listPageSize,
+	}
+}
+
+// addMembers adds n running members of tsgName, each on one of nodes in
+// turn.
+func (f *fakeCloudAPI) addMembers(tsgName string, n int, nodes ...string) []*tcc.Instance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var added []*tcc.Instance
+	for i := 0; i < n; i++ {
+		instance := f.newInstance(StateRunning, map[string]interface{}{"tsg.name": tsgName})
+		if len(nodes) > 0 {
+			instance.ComputeNode = nodes[i%len(nodes)]
+		}
+		added = append(added, instance)
+	}
+	return added
+}
+
+func (f *fakeCloudAPI) newInstance(state string, tags map[string]interface{}) *tcc.Instance {
+	f.seq++
+	instance := &tcc.Instance{
+		ID:       fmt.Sprintf("00000000-0000-0000-0000-%012d", f.seq),
+		State:    state,
+		Tags:     tags,
+		Metadata: make(map[string]string),
+		Created:  time.Now().Add(time.Duration(f.seq) * time.Second),
+	}
+	f.instances[instance.ID] = instance
+	return instance
+}
+
+func (f *fakeCloudAPI) instance(id string) *tcc.Instance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.instances[id]
+}
+
+func (f *fakeCloudAPI) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.instances)
+}
+
+func (f *fakeCloudAPI) requestCount(method, prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var n int
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, method+" "+prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeCloudAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	intercept := f.intercept
+	f.mu.Unlock()
+
+	if intercept != nil {
+		if status := intercept(r); status != 0 {
+			writeError(w, status)
+			return
+		}
+	}
+
+	f.serve(w, r)
+}
+
+// serve answers a request without recording or intercepting it.
+func (f *fakeCloudAPI) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != testAccount || parts[1] != "machines" {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodHead, http.MethodGet:
+			f.list(w, r)
+		case http.MethodPost:
+			f.create(w, r)
+		}
+		return
+	}
+
+	instance, ok := f.instances[parts[2]]
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(instance)
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		delete(f.instances, instance.ID)
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		r.ParseForm()
+		switch r.Form.Get("action") {
+		case "stop":
+			instance.State = "stopped"
+			if f.stopState != "" {
+				instance.State = f.stopState
+			}
+		case "start":
+			instance.State = StateRunning
+		case "enable_deletion_protection":
+			instance.DeletionProtection = true
+		case "disable_deletion_protection":
+			instance.DeletionProtection = false
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case parts[3] == "tags" && r.Method == http.MethodDelete && len(parts) == 5:
+		if _, ok := instance.Tags[parts[4]]; !ok {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		delete(instance.Tags, parts[4])
+		w.WriteHeader(http.StatusNoContent)
+	case parts[3] == "tags":
+		var tags map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&tags)
+		if r.Method == http.MethodPut {
+			instance.Tags = make(map[string]interface{})
+		}
+		for k, v := range tags {
+			instance.Tags[k] = v
+		}
+		json.NewEncoder(w).Encode(instance.Tags)
+	default:
+		writeError(w, http.StatusNotFound)
+	}
+}
+
+func (f *fakeCloudAPI) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var matched []*tcc.Instance
+	for _, instance := range f.instances {
+		ok := true
+		for k, v := range query {
+			if strings.HasPrefix(k, "tag.") && fmt.Sprint(instance.Tags[k[len("tag."):]]) != v[0] {
+				ok = false
+			}
+			if k == "name" && instance.Name != v[0] {
+				ok = false
+			}
+		}
+		if ok {
+			matched = append(matched, instance)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+
+	if r.Method == http.MethodHead {
+		count := len(matched)
+		if len(f.countSkew) > 0 {
+			count += f.countSkew[0]
+			f.countSkew = f.countSkew[1:]
+		}
+		w.Header().Set("X-Resource-Count", strconv.Itoa(count))
+		return
+	}
+
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit == 0 || limit > f.pageSize {
+		limit = f.pageSize
+	}
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	json.NewEncoder(w).Encode(matched[offset:end])
+}
+
+func (f *fakeCloudAPI) create(w http.ResponseWriter, r *http.Request) {
+	var input map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	tags := make(map[string]interface{})
+	for k, v := range input {
+		if strings.HasPrefix(k, "tag.") {
+			tags[k[len("tag."):]] = v
+		}
+	}
+
+	state := StateRunning
+	if f.createState != "" {
+		state = f.createState
+	}
+	instance := f.newInstance(state, tags)
+	if name, ok := input["name"].(string); ok {
+		instance.Name = name
+	}
+	for k, v := range input {
+		if strings.HasPrefix(k, "metadata.") {
+			instance.Metadata[k[len("metadata."):]] = fmt.Sprint(v)
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(instance)
+}
+
+func writeError(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"code":    strings.Replace(http.StatusText(status), " ", "", -1),
+		"message": http.StatusText(status),
+	})
+}
+
+// newTestClient starts api and returns a client for the group tsgName
+// connected to it. The group's settings are reset when the test ends.
+func newTestClient(t *testing.T, api *fakeCloudAPI, tsgName string) *AgentComputeClient {
+	t.Helper()
+
+	srv := httptest.NewServer(api)
+	stateDir, err := ioutil.TempDir("", "tsg-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		srv.Close()
+		os.RemoveAll(stateDir)
+		viper.Reset()
+	})
+
+	viper.Set(iconfig.KeyTsgGroupName, tsgName)
+	viper.Set(iconfig.KeyTsgTemplateID, "template")
+	viper.Set(iconfig.KeyStateDir, stateDir)
+	viper.Set(iconfig.KeyRetryMaxAttempts, 1)
+
+	signer, err := authentication.NewTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewComputeClient(&config.TritonClientConfig{
+		Config: &triton.ClientConfig{
+			TritonURL:   srv.URL,
+			AccountName: testAccount,
+			Signers:     []authentication.Signer{signer},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
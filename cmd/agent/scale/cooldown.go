@@ -0,0 +1,201 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/joyent/tsg-cli/cmd/agent/schedule"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/pkg/errors"
+)
+
+// groupState is what tsg remembers about a group between runs. It is kept
+// in a JSON file per account and group under the state directory.
+type groupState struct {
+	LastScaleOut time.Time `json:"last_scale_out,omitempty"`
+	LastScaleIn  time.Time `json:"last_scale_in,omitempty"`
+
+	Schedules []*schedule.Schedule `json:"schedules,omitempty"`
+}
+
+func groupStatePath(accountName, tsgName string) string {
+	name := fmt.Sprintf("%s-%s.json",
+		unsafeFileChars.ReplaceAllString(accountName, "_"),
+		unsafeFileChars.ReplaceAllString(tsgName, "_"))
+	return filepath.Join(config.GetStateDir(), name)
+}
+
+func loadGroupState(accountName, tsgName string) (*groupState, error) {
+	state := &groupState{}
+
+	path := groupStatePath(accountName, tsgName)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading state from %s", path)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrapf(err, "error decoding state from %s", path)
+	}
+
+	return state, nil
+}
+
+func (s *groupState) save(accountName, tsgName string) error {
+	path := groupStatePath(accountName, tsgName)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrapf(err, "error creating state directory %s", filepath.Dir(path))
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error encoding state")
+	}
+
+	// Write to a temporary file and rename it so that an interrupted run
+	// never leaves a truncated state file behind.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return errors.Wrapf(err, "error writing state to %s", tmp)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrapf(err, "error writing state to %s", path)
+	}
+
+	return nil
+}
+
+// applyCooldown removes scale-out or scale-in actions from plan when the
+// group last scaled in that direction more recently than the configured
+// cooldown, recording why in plan.Deferred. Replacing unhealthy members and
+// expiring warm members are never deferred: the first len(plan.Unhealthy)
+// instances started or created replace unhealthy members and only the rest
+// are scale-out.
+func applyCooldown(plan *Plan) error {
+	if config.GetScaleForce() {
+		return nil
+	}
+
+	outCooldown := config.GetScaleOutCooldown()
+	inCooldown := config.GetScaleInCooldown()
+	if outCooldown <= 0 && inCooldown <= 0 {
+		return nil
+	}
+
+	state, err := loadGroupState(plan.AccountName, plan.TsgName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if n := scaleOutCount(plan); n > 0 && outCooldown > 0 {
+		if until := state.LastScaleOut.Add(outCooldown); now.Before(until) {
+			plan.Deferred = append(plan.Deferred, fmt.Sprintf(
+				"scale-out of %d instance(s) deferred by cooldown until %s", n, until.UTC().Format(time.RFC3339)))
+
+			// Warm members are started before any instance is created, so
+			// replacements are kept from Start first.
+			replace := len(plan.Unhealthy)
+			if len(plan.Start) > replace {
+				plan.Start = plan.Start[:replace]
+			}
+			plan.Create = replace - len(plan.Start)
+			plan.CreateIn = trimAllocation(plan.CreateIn, plan.Create)
+			if plan.Create == 0 {
+				plan.CreateInput = nil
+			}
+		}
+	}
+
+	if n := len(plan.Delete) + len(plan.Stop); n > 0 && inCooldown > 0 {
+		if until := state.LastScaleIn.Add(inCooldown); now.Before(until) {
+			plan.Deferred = append(plan.Deferred, fmt.Sprintf(
+				"scale-in of %d instance(s) deferred by cooldown until %s", n, until.UTC().Format(time.RFC3339)))
+			plan.Delete = nil
+			plan.Stop = nil
+		}
+	}
+
+	return nil
+}
+
+// scaleOutCount returns how many of the instances plan starts or creates
+// grow the group rather than replace unhealthy members.
+func scaleOutCount(plan *Plan) int {
+	if n := plan.Create + len(plan.Start) - len(plan.Unhealthy); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// trimAllocation reduces the number of instances created in each data
+// center to total, taking them from the data centers with the most first.
+func trimAllocation(createIn map[string]int, total int) map[string]int {
+	if createIn == nil || total == 0 {
+		return nil
+	}
+
+	trimmed := make(map[string]int, len(createIn))
+	var sum int
+	for name, n := range createIn {
+		trimmed[name] = n
+		sum += n
+	}
+
+	for ; sum > total; sum-- {
+		var largest string
+		for name, n := range trimmed {
+			if largest == "" || n > trimmed[largest] || (n == trimmed[largest] && name < largest) {
+				largest = name
+			}
+		}
+		trimmed[largest]--
+		if trimmed[largest] == 0 {
+			delete(trimmed, largest)
+		}
+	}
+
+	return trimmed
+}
+
+// recordScaling stores when the group last scaled out and in, so that the
+// next run can respect the cooldowns. Replacing unhealthy members is not
+// scaling out.
+func recordScaling(plan *Plan) error {
+	scaledOut := scaleOutCount(plan) > 0
+	scaledIn := len(plan.Delete) > 0 || len(plan.Stop) > 0
+	if !scaledOut && !scaledIn {
+		return nil
+	}
+
+	state, err := loadGroupState(plan.AccountName, plan.TsgName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if scaledOut {
+		state.LastScaleOut = now
+	}
+	if scaledIn {
+		state.LastScaleIn = now
+	}
+
+	return state.save(plan.AccountName, plan.TsgName)
+}
@@ -0,0 +1,94 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestApplyCooldownKeepsReplacements(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "tsg-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	defer viper.Reset()
+
+	viper.Set(iconfig.KeyStateDir, stateDir)
+	viper.Set(iconfig.KeyScaleOutCooldown, 10*time.Minute)
+
+	state := &groupState{LastScaleOut: time.Now()}
+	if err := state.save(testAccount, "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		unhealthy  int
+		start      int
+		create     int
+		wantStart  int
+		wantCreate int
+		deferred   bool
+	}{
+		{name: "replacements only", unhealthy: 2, create: 2, wantCreate: 2},
+		{name: "scale-out beyond replacements", unhealthy: 2, create: 5, wantCreate: 2, deferred: true},
+		{name: "replacements started from warm pool", unhealthy: 2, start: 1, create: 4, wantStart: 1, wantCreate: 1, deferred: true},
+		{name: "warm pool covers replacements", unhealthy: 1, start: 3, create: 2, wantStart: 1, deferred: true},
+		{name: "scale-out only", start: 1, create: 3, deferred: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &Plan{
+				AccountName: testAccount,
+				TsgName:     "web",
+				Unhealthy:   make([]string, tt.unhealthy),
+				Start:       make([]string, tt.start),
+				Create:      tt.create,
+				CreateInput: &tcc.CreateInstanceInput{},
+			}
+
+			if err := applyCooldown(plan); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(plan.Start) != tt.wantStart || plan.Create != tt.wantCreate {
+				t.Errorf("plan starts %d and creates %d, want %d and %d",
+					len(plan.Start), plan.Create, tt.wantStart, tt.wantCreate)
+			}
+			if (len(plan.Deferred) > 0) != tt.deferred {
+				t.Errorf("deferred = %q, want deferred %v", plan.Deferred, tt.deferred)
+			}
+			if plan.Create > 0 && plan.CreateInput == nil {
+				t.Error("plan creates instances without a create input")
+			}
+			if len(plan.Unhealthy) != tt.unhealthy {
+				t.Errorf("plan replaces %d unhealthy members, want %d", len(plan.Unhealthy), tt.unhealthy)
+			}
+		})
+	}
+}
+
+func TestTrimAllocation(t *testing.T) {
+	got := trimAllocation(map[string]int{"east": 3, "west": 2}, 2)
+	if got["east"] != 1 || got["west"] != 1 {
+		t.Errorf("trimAllocation = %v, want east 1 and west 1", got)
+	}
+	if got := trimAllocation(map[string]int{"east": 3}, 0); got != nil {
+		t.Errorf("trimAllocation to 0 = %v, want nil", got)
+	}
+}
@@ -0,0 +1,349 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/pkg/errors"
+)
+
+// allDatacenters selects every data center CloudAPI knows about.
+const allDatacenters = "*"
+
+// Datacenter is one of the data centers a group's members are spread
+// across. Target is the share of the expected count it should hold, and is
+// only set in a plan.
+type Datacenter struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Target int    `json:"target"`
+
+	client *tcc.ComputeClient
+}
+
+// ParseDatacenter parses a data center given as "name" or "name=weight".
+func ParseDatacenter(spec string) (*Datacenter, error) {
+	dc := &Datacenter{Name: spec, Weight: 1}
+	if i := strings.LastIndex(spec, "="); i >= 0 {
+		weight, err := strconv.Atoi(spec[i+1:])
+		if err != nil || weight < 1 {
+			return nil, fmt.Errorf("invalid weight in data center %q, expected a positive integer", spec)
+		}
+		dc.Name, dc.Weight = spec[:i], weight
+	}
+	if dc.Name == "" {
+		return nil, fmt.Errorf("invalid data center %q, expected name or name=weight", spec)
+	}
+	return dc, nil
+}
+
+// SetDatacenters spreads the group across the data centers named in specs,
+// looking up their CloudAPI URLs from the configured CloudAPI. A spec of "*"
+// adds every data center not otherwise listed with a weight of 1. With no
+// specs the group stays in the configured data center.
+func (c *AgentComputeClient) SetDatacenters(ctx context.Context, specs []string) error {
+	if len(specs) == 0 {
+		return nil
+	}
+
+	known, err := c.client.Datacenters().List(ctx, &tcc.ListDataCentersInput{})
+	if err != nil {
+		return errors.Wrap(err, "unable to list data centers")
+	}
+	urls := make(map[string]string, len(known))
+	names := make([]string, 0, len(known))
+	for _, dc := range known {
+		urls[dc.Name] = dc.URL
+		names = append(names, dc.Name)
+	}
+	sort.Strings(names)
+
+	var dcs []*Datacenter
+	seen := make(map[string]bool)
+	all := false
+	for _, spec := range specs {
+		if spec == allDatacenters {
+			all = true
+			continue
+		}
+
+		dc, err := ParseDatacenter(spec)
+		if err != nil {
+			return err
+		}
+		if seen[dc.Name] {
+			return fmt.Errorf("data center %q is listed more than once", dc.Name)
+		}
+		url, ok := urls[dc.Name]
+		if !ok {
+			return fmt.Errorf("unknown data center %q (known data centers: %s)", dc.Name, strings.Join(names, ", "))
+		}
+		dc.URL = url
+		seen[dc.Name] = true
+		dcs = append(dcs, dc)
+	}
+
+	if all {
+		for _, name := range names {
+			if !seen[name] {
+				dcs = append(dcs, &Datacenter{Name: name, URL: urls[name], Weight: 1})
+			}
+		}
+	}
+
+	return c.useDatacenters(dcs)
+}
+
+// useDatacenters creates a CloudAPI client for each data center and makes
+// the group span them.
+func (c *AgentComputeClient) useDatacenters(dcs []*Datacenter) error {
+	clients := make([]*Datacenter, 0, len(dcs))
+	for _, dc := range dcs {
+		cfg := *c.cfg
+		cfg.TritonURL = dc.URL
+
+		client, err := tcc.NewClient(&cfg)
+		if err != nil {
+			return errors.Wrapf(err, "Error Creating Triton Compute Client for %s", dc.Name)
+		}
+
+		clients = append(clients, &Datacenter{
+			Name:   dc.Name,
+			URL:    dc.URL,
+			Weight: dc.Weight,
+			client: client,
+		})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.datacenters = clients
+	return nil
+}
+
+// multiDC reports whether the group spans several data centers.
+func (c *AgentComputeClient) multiDC() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.datacenters) > 0
+}
+
+// allDCs returns the data centers the group spans, or only the configured
+// one if it spans a single data center.
+func (c *AgentComputeClient) allDCs() []*Datacenter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.datacenters) == 0 {
+		return []*Datacenter{c.primary}
+	}
+	return c.datacenters
+}
+
+func (c *AgentComputeClient) datacenterNamed(name string) *Datacenter {
+	for _, dc := range c.allDCs() {
+		if dc.Name == name {
+			return dc
+		}
+	}
+	return c.primary
+}
+
+// remember records which data center instances were found in, so that
+// later calls about them go to that data center's CloudAPI.
+func (c *AgentComputeClient) remember(dc *Datacenter, instances ...*tcc.Instance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, instance := range instances {
+		c.instanceDC[instance.ID] = dc
+	}
+}
+
+// dcOf returns the data center instanceID was found in, or nil if it is not
+// known.
+func (c *AgentComputeClient) dcOf(instanceID string) *Datacenter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.instanceDC[instanceID]
+}
+
+// locate returns the data center holding instanceID, looking it up in each
+// data center if it has not been seen yet.
+func (c *AgentComputeClient) locate(ctx context.Context, instanceID string) *Datacenter {
+	if dc := c.dcOf(instanceID); dc != nil {
+		return dc
+	}
+	if !c.multiDC() {
+		return c.primary
+	}
+
+	for _, dc := range c.allDCs() {
+		instance, err := dc.client.Instances().Get(ctx, &tcc.GetInstanceInput{ID: instanceID})
+		if err == nil {
+			c.remember(dc, instance)
+			return dc
+		}
+	}
+
+	return c.primary
+}
+
+// datacenterName returns the name of the data center instanceID was found
+// in, or "" if the group spans a single data center.
+func (c *AgentComputeClient) datacenterName(instanceID string) string {
+	if dc := c.dcOf(instanceID); dc != nil {
+		return dc.Name
+	}
+	return ""
+}
+
+// distribute splits total across dcs in proportion to their weights, giving
+// the units left over by rounding down to the largest remainders.
+func distribute(total int, dcs []*Datacenter) map[string]int {
+	shares := make(map[string]int, len(dcs))
+
+	var weights int
+	for _, dc := range dcs {
+		weights += dc.Weight
+	}
+	if weights == 0 || total <= 0 {
+		return shares
+	}
+
+	type remainder struct {
+		name string
+		rem  int
+	}
+	rems := make([]remainder, 0, len(dcs))
+	assigned := 0
+	for _, dc := range dcs {
+		shares[dc.Name] = total * dc.Weight / weights
+		assigned += shares[dc.Name]
+		rems = append(rems, remainder{dc.Name, total * dc.Weight % weights})
+	}
+
+	sort.SliceStable(rems, func(i, j int) bool {
+		return rems[i].rem > rems[j].rem
+	})
+	for i := 0; assigned < total; i++ {
+		shares[rems[i%len(rems)].name]++
+		assigned++
+	}
+
+	return shares
+}
+
+// allocateCreates assigns count new instances to the data centers furthest
+// below their targets.
+func allocateCreates(count int, current, targets map[string]int, dcs []*Datacenter) map[string]int {
+	allocated := make(map[string]int)
+	for i := 0; i < count; i++ {
+		var best string
+		bestDeficit := 0
+		for j, dc := range dcs {
+			deficit := targets[dc.Name] - current[dc.Name] - allocated[dc.Name]
+			if j == 0 || deficit > bestDeficit {
+				best, bestDeficit = dc.Name, deficit
+			}
+		}
+		allocated[best]++
+	}
+	return allocated
+}
+
+// spreadTermination picks count instances from ordered, which is already in
+// termination policy order, taking each from the data center furthest above
+// its target so that scale-in moves the group toward its target
+// distribution.
+func (c *AgentComputeClient) spreadTermination(ordered []*tcc.Instance, count int, current, targets map[string]int) []*tcc.Instance {
+	remaining := make(map[string]int, len(current))
+	for name, n := range current {
+		remaining[name] = n
+	}
+
+	taken := make([]bool, len(ordered))
+	var selected []*tcc.Instance
+	for len(selected) < count {
+		pick := -1
+		bestSurplus := 0
+		for i, instance := range ordered {
+			if taken[i] {
+				continue
+			}
+			name := c.datacenterName(instance.ID)
+			surplus := remaining[name] - targets[name]
+			if pick < 0 || surplus > bestSurplus {
+				pick, bestSurplus = i, surplus
+			}
+		}
+		if pick < 0 {
+			break
+		}
+
+		taken[pick] = true
+		remaining[c.datacenterName(ordered[pick].ID)]--
+		selected = append(selected, ordered[pick])
+	}
+
+	return selected
+}
+
+// launchDatacenters returns the data center each of the plan's new
+// instances is created in, alternating between data centers so that
+// launches running in parallel are spread across them.
+func (c *AgentComputeClient) launchDatacenters(plan *Plan) []*Datacenter {
+	names := make([]string, 0, len(plan.CreateIn))
+	remaining := make(map[string]int, len(plan.CreateIn))
+	for name, n := range plan.CreateIn {
+		names = append(names, name)
+		remaining[name] = n
+	}
+	sort.Strings(names)
+
+	dcs := make([]*Datacenter, 0, plan.Create)
+	for added := true; added; {
+		added = false
+		for _, name := range names {
+			if remaining[name] > 0 {
+				dcs = append(dcs, c.datacenterNamed(name))
+				remaining[name]--
+				added = true
+			}
+		}
+	}
+
+	fallback := c.allDCs()[0]
+	for len(dcs) < plan.Create {
+		dcs = append(dcs, fallback)
+	}
+
+	return dcs
+}
+
+// countByDatacenter counts instances by the data center they were found in.
+func (c *AgentComputeClient) countByDatacenter(instances ...[]*tcc.Instance) map[string]int {
+	counts := make(map[string]int)
+	for _, list := range instances {
+		for _, instance := range list {
+			counts[c.datacenterName(instance.ID)]++
+		}
+	}
+	return counts
+}
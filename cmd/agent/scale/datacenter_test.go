@@ -0,0 +1,63 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestPlanFailsWhenADatacenterCanNotBeListed(t *testing.T) {
+	east := newFakeCloudAPI()
+	east.addMembers("web", 2)
+	west := newFakeCloudAPI()
+	// Instance IDs are unique across data centers.
+	west.seq = 1000
+	west.addMembers("web", 2)
+
+	c := newTestClient(t, east, "web")
+	westSrv := httptest.NewServer(west)
+	defer westSrv.Close()
+
+	eastURL := c.cfg.TritonURL
+	if err := c.useDatacenters([]*Datacenter{
+		{Name: "east", URL: eastURL, Weight: 1},
+		{Name: "west", URL: westSrv.URL, Weight: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set(iconfig.KeyInstanceCount, 4)
+
+	plan, err := c.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Create != 0 || len(plan.Delete) != 0 {
+		t.Errorf("plan creates %d and deletes %d, want 0 and 0", plan.Create, len(plan.Delete))
+	}
+
+	// West's members are not planned as gone and replaced in east.
+	west.intercept = func(r *http.Request) int {
+		if r.URL.Path == "/acct/machines" {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+	if _, err := c.Plan(context.Background()); err == nil {
+		t.Fatal("expected Plan to fail when a data center can not be listed")
+	}
+	if got := east.requestCount("POST", "/acct/machines"); got != 0 {
+		t.Errorf("created %d instances in east, want 0", got)
+	}
+}
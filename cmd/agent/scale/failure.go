@@ -0,0 +1,112 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// What to do with an instance that was created during scale-out but never
+// became ready or could not be tagged.
+const (
+	OnFailureRollback = "rollback"
+	OnFailureKeep     = "keep"
+
+	DefaultOnFailure = OnFailureRollback
+)
+
+// Instances kept after a failed launch are tagged tsg.status=failed. They
+// are left out of the group's accounting so that they are neither counted
+// nor replaced and deleted, and remain for inspection until removed by
+// hand. Every plan lists them, and each run logs a warning, so that they
+// are not forgotten.
+const (
+	statusTag    = "tsg.status"
+	statusFailed = "failed"
+)
+
+// cleanupTimeout bounds how long cleaning up failed launches may take.
+const cleanupTimeout = 2 * time.Minute
+
+func ValidateOnFailure(mode string) error {
+	switch mode {
+	case OnFailureRollback, OnFailureKeep:
+		return nil
+	}
+	return fmt.Errorf("unknown on-failure mode %q (valid modes: %s, %s)", mode, OnFailureRollback, OnFailureKeep)
+}
+
+func isFailed(instance *tcc.Instance) bool {
+	status, _ := instance.Tags[statusTag].(string)
+	return status == statusFailed
+}
+
+// cleanupFailedLaunches rolls back or marks every instance that scale-out
+// created in this run but could not finish launching. It runs with its own
+// deadline rather than the reconcile's context, so that instances are still
+// cleaned up when a run is cancelled part way through a launch.
+func (c *AgentComputeClient) cleanupFailedLaunches(plan *Plan, results []*actionResult) {
+	mode := config.GetOnFailure()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	for _, r := range results {
+		if r.Err == nil || r.Instance == nil {
+			continue
+		}
+		if perr, ok := errors.Cause(r.Err).(*ProvisioningError); ok && perr.State == "deleted" {
+			continue
+		}
+
+		var err error
+		switch mode {
+		case OnFailureKeep:
+			err = c.instances().AddTags(ctx, &tcc.AddTagsInput{
+				ID:   r.Instance.ID,
+				Tags: map[string]string{statusTag: statusFailed},
+			})
+		default:
+			err = c.DeleteInstance(ctx, r.Instance.ID)
+		}
+
+		if err != nil {
+			log.Error().
+				Str("account_name", plan.AccountName).
+				Str("tsg_name", plan.TsgName).
+				Str("instance_id", r.Instance.ID).
+				Str("status", "failed").
+				Str("notification_type", "TSG_INSTANCE_CLEANUP_ERROR").
+				Str("description", fmt.Sprintf("Error cleaning up instance %s (%s): %s", r.Instance.ID, mode, err)).
+				Msg("An instance left behind by a failed launch could not be cleaned up")
+			continue
+		}
+
+		description := fmt.Sprintf("Deleted instance %s after a failed launch", r.Instance.ID)
+		if mode == OnFailureKeep {
+			description = fmt.Sprintf("Kept instance %s after a failed launch and tagged it %s=%s", r.Instance.ID, statusTag, statusFailed)
+		}
+
+		log.Warn().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("instance_id", r.Instance.ID).
+			Str("status", "successful").
+			Str("notification_type", "TSG_INSTANCE_CLEANUP").
+			Str("description", description).
+			Msgf("An instance left behind by a failed launch was cleaned up (%s)", mode)
+	}
+}
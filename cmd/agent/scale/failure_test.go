@@ -0,0 +1,122 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+// failProvisioning makes every instance looked up by ID other than those
+// in keep fail to provision, or vanish if deleted is set.
+func (f *fakeCloudAPI) failProvisioning(deleted bool, keep ...string) {
+	kept := make(map[string]bool, len(keep))
+	for _, id := range keep {
+		kept[id] = true
+	}
+
+	f.intercept = func(r *http.Request) int {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if r.Method != http.MethodGet || len(parts) != 3 || kept[parts[2]] {
+			return 0
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if instance, ok := f.instances[parts[2]]; ok {
+			instance.State = "failed"
+			if deleted {
+				delete(f.instances, instance.ID)
+			}
+		}
+		return 0
+	}
+}
+
+func TestCleanupFailedLaunches(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		deleted    bool
+		wantCount  int
+		wantFailed int
+		wantDelete int
+	}{
+		{name: "rollback", mode: OnFailureRollback, wantCount: 1, wantDelete: 1},
+		{name: "default", wantCount: 1, wantDelete: 1},
+		{name: "keep", mode: OnFailureKeep, wantCount: 2, wantFailed: 1},
+		{name: "already deleted", mode: OnFailureRollback, deleted: true, wantCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			member := api.addMembers("web", 1)[0]
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyInstanceCount, 2)
+			viper.Set(iconfig.KeyInstanceReadyPoll, 10*time.Millisecond)
+			viper.Set(iconfig.KeyInstanceReadyTimeout, 5*time.Second)
+			if tt.mode != "" {
+				viper.Set(iconfig.KeyScaleOnFailure, tt.mode)
+			}
+			api.failProvisioning(tt.deleted, member.ID)
+
+			if err := c.MaintainInstanceCount(context.Background()); err == nil {
+				t.Error("MaintainInstanceCount succeeded, want the failed launch reported")
+			}
+
+			if got := api.count(); got != tt.wantCount {
+				t.Errorf("fake has %d instances, want %d", got, tt.wantCount)
+			}
+			if got := api.requestCount("DELETE", "/acct/machines/"); got != tt.wantDelete {
+				t.Errorf("deleted %d instances, want %d", got, tt.wantDelete)
+			}
+			if api.instance(member.ID) == nil {
+				t.Fatalf("member %s was deleted", member.ID)
+			}
+
+			// Kept instances are reported by every later plan, but neither
+			// counted nor replaced.
+			api.intercept = nil
+			instances, err := c.GetInstanceList(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan, err := c.newPlan(context.Background(), instances)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plan.Failed) != tt.wantFailed {
+				t.Fatalf("plan has failed members %v, want %d", plan.Failed, tt.wantFailed)
+			}
+			if plan.ReadyCount() != 1 || plan.Create != 1 || len(plan.Delete) != 0 {
+				t.Errorf("plan has %d ready, creates %d and deletes %v, want 1 ready and 1 created", plan.ReadyCount(), plan.Create, plan.Delete)
+			}
+
+			var b strings.Builder
+			if err := plan.WriteText(&b); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range plan.Failed {
+				if tags := api.instance(id).Tags; tags[statusTag] != statusFailed {
+					t.Errorf("kept instance has tags %v, want %s=%s", tags, statusTag, statusFailed)
+				}
+				if !strings.Contains(b.String(), "  - "+id+"\n") {
+					t.Errorf("plan text does not list kept instance %s:\n%s", id, b.String())
+				}
+			}
+		})
+	}
+}
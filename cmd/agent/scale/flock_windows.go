@@ -0,0 +1,24 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"errors"
+	"os"
+)
+
+var errLockHeld = errors.New("lock is held")
+
+func tryFlock(f *os.File) error {
+	return errors.New("local locks are not supported on windows, use --lock=cloudapi or --lock=none")
+}
+
+func unflock(f *os.File) error {
+	return nil
+}
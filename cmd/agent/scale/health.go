@@ -0,0 +1,63 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/rs/zerolog/log"
+)
+
+// healthCheckParallelism bounds how many members are health checked at once.
+const healthCheckParallelism = 10
+
+// checkMembers runs the health check against every ready member that is past
+// its grace period and moves the members that fail it to unhealthy, so that
+// they are replaced.
+func (c *AgentComputeClient) checkMembers(ctx context.Context, plan *Plan, members *groupMembers) {
+	var checked, healthy []*tcc.Instance
+	for _, instance := range members.ready {
+		if c.health.InGracePeriod(instance.Created) {
+			healthy = append(healthy, instance)
+			continue
+		}
+		checked = append(checked, instance)
+	}
+
+	results := forEachParallel(ctx, len(checked), healthCheckParallelism, func(i int) *actionResult {
+		return &actionResult{
+			Instance: checked[i],
+			Err:      c.health.Evaluate(ctx, checked[i].PrimaryIP),
+		}
+	})
+
+	for i, r := range results {
+		// A member whose check was cut short by cancellation is given the
+		// benefit of the doubt.
+		if r.Err == nil || r.Skipped || ctx.Err() != nil {
+			healthy = append(healthy, checked[i])
+			continue
+		}
+
+		log.Warn().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("instance_id", r.Instance.ID).
+			Str("status", "failed").
+			Str("notification_type", "TSG_INSTANCE_UNHEALTHY").
+			Str("description", fmt.Sprintf("Instance %s failed %d consecutive health checks: %s", r.Instance.ID, c.health.UnhealthyThreshold, r.Err)).
+			Msgf("An instance failed its health check and will be replaced")
+
+		members.unhealthy = append(members.unhealthy, r.Instance)
+	}
+
+	members.ready = healthy
+}
@@ -0,0 +1,67 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/agent/health"
+)
+
+func TestCheckMembers(t *testing.T) {
+	// Only 127.0.0.1 listens, so a probe of 127.0.0.2 is refused.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, portStr, _ := net.SplitHostPort(ln.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	c := &AgentComputeClient{health: &health.Check{
+		Type:               health.TypeTCP,
+		Port:               port,
+		Interval:           10 * time.Millisecond,
+		Timeout:            time.Second,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 2,
+		GracePeriod:        time.Minute,
+	}}
+
+	old := time.Now().Add(-time.Hour)
+	members := &groupMembers{ready: []*tcc.Instance{
+		{ID: "healthy", PrimaryIP: "127.0.0.1", Created: old},
+		{ID: "unhealthy", PrimaryIP: "127.0.0.2", Created: old},
+		{ID: "starting", PrimaryIP: "127.0.0.2", Created: time.Now()},
+	}}
+
+	c.checkMembers(context.Background(), &Plan{TsgName: "web"}, members)
+
+	if got := instanceIDs(members.ready); !sameMembers(got, []string{"healthy", "starting"}) {
+		t.Errorf("ready = %v, want healthy and the member in its grace period", got)
+	}
+	if got := instanceIDs(members.unhealthy); !sameMembers(got, []string{"unhealthy"}) {
+		t.Errorf("unhealthy = %v, want unhealthy", got)
+	}
+}
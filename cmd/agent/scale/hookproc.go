@@ -0,0 +1,28 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+//go:build !windows
+// +build !windows
+
+package scale
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killHookGroup runs a hook command in its own process group and kills the
+// whole group when the hook is cancelled. Killing only the shell would leave
+// the commands it started holding its output open, and the hook would run
+// past its timeout.
+func killHookGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
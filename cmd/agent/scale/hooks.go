@@ -0,0 +1,217 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Lifecycle hook events.
+const (
+	HookPreLaunch     = "pre-launch"
+	HookPostLaunch    = "post-launch"
+	HookPreTerminate  = "pre-terminate"
+	HookPostTerminate = "post-terminate"
+)
+
+// What happens when a hook fails.
+const (
+	HookAbort    = "abort"
+	HookContinue = "continue"
+)
+
+// hookOutputLimit bounds how much of a failed hook's output is kept in its
+// error.
+const hookOutputLimit = 512
+
+// hookEvent is the JSON document passed to a hook. Action is what the
+// scaler is doing to the member: "launch" or "delete", or "start" and
+// "stop" for members moved in and out of the warm pool.
+type hookEvent struct {
+	Event       string        `json:"event"`
+	Action      string        `json:"action"`
+	AccountName string        `json:"account_name"`
+	TsgName     string        `json:"tsg_name"`
+	TemplateID  string        `json:"template_id,omitempty"`
+	Instance    *tcc.Instance `json:"instance,omitempty"`
+}
+
+func hookConfig(event string) config.Hook {
+	switch event {
+	case HookPreLaunch:
+		return config.GetPreLaunchHook()
+	case HookPostLaunch:
+		return config.GetPostLaunchHook()
+	case HookPreTerminate:
+		return config.GetPreTerminateHook()
+	case HookPostTerminate:
+		return config.GetPostTerminateHook()
+	}
+	return config.Hook{}
+}
+
+// ValidateHooks returns an error if any hook has an unknown failure policy.
+func ValidateHooks() error {
+	for _, event := range []string{HookPreLaunch, HookPostLaunch, HookPreTerminate, HookPostTerminate} {
+		switch mode := hookConfig(event).OnFailure; mode {
+		case "", HookAbort, HookContinue:
+		default:
+			return fmt.Errorf("unknown %s hook on-failure mode %q (valid modes: %s, %s)", event, mode, HookAbort, HookContinue)
+		}
+	}
+	return nil
+}
+
+// runHook runs the hook configured for event, if any. A failed hook returns
+// an error only when its failure policy is to abort the action. A post hook
+// runs once the action has happened, so aborting can not undo it: the
+// action is reported as failed, and a launched instance is cleaned up like
+// any other failed launch.
+func runHook(ctx context.Context, plan *Plan, event, action string, instance *tcc.Instance) error {
+	hook := hookConfig(event)
+	if hook.Run == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(&hookEvent{
+		Event:       event,
+		Action:      action,
+		AccountName: plan.AccountName,
+		TsgName:     plan.TsgName,
+		TemplateID:  plan.TemplateID,
+		Instance:    instance,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	if isWebhook(hook.Run) {
+		err = postHook(ctx, hook.Run, event, body)
+	} else {
+		err = execHook(ctx, hook.Run, event, plan.TsgName, instance, body)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", hook.Timeout)
+	}
+
+	instanceID := ""
+	if instance != nil {
+		instanceID = instance.ID
+	}
+
+	if err == nil {
+		log.Debug().
+			Str("tsg_name", plan.TsgName).
+			Str("instance_id", instanceID).
+			Msgf("%s hook succeeded", event)
+		return nil
+	}
+
+	log.Warn().
+		Str("account_name", plan.AccountName).
+		Str("tsg_name", plan.TsgName).
+		Str("instance_id", instanceID).
+		Str("status", "failed").
+		Str("notification_type", "TSG_HOOK_ERROR").
+		Str("description", fmt.Sprintf("%s hook for %s failed: %s", event, action, err)).
+		Msgf("A lifecycle hook failed (%s)", hookOnFailure(hook))
+
+	if hookOnFailure(hook) == HookContinue {
+		return nil
+	}
+
+	return errors.Wrapf(err, "%s hook failed", event)
+}
+
+func hookOnFailure(hook config.Hook) string {
+	if hook.OnFailure == "" {
+		return HookAbort
+	}
+	return hook.OnFailure
+}
+
+func isWebhook(run string) bool {
+	return strings.HasPrefix(run, "http://") || strings.HasPrefix(run, "https://")
+}
+
+// execHook runs a hook command with /bin/sh -c, passing the event on stdin
+// and its identifying fields in the environment.
+func execHook(ctx context.Context, run, event, tsgName string, instance *tcc.Instance, body []byte) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", run)
+	killHookGroup(cmd)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"TSG_HOOK_EVENT="+event,
+		"TSG_NAME="+tsgName,
+	)
+	if instance != nil {
+		cmd.Env = append(cmd.Env,
+			"TSG_INSTANCE_ID="+instance.ID,
+			"TSG_INSTANCE_IP="+instance.PrimaryIP,
+		)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, truncateOutput(out))
+	}
+
+	return nil
+}
+
+// postHook POSTs the event to a webhook, which must answer with a 2xx
+// status.
+func postHook(ctx context.Context, url, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-TSG-Hook-Event", event)
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		out, _ := ioutil.ReadAll(io.LimitReader(resp.Body, hookOutputLimit))
+		return fmt.Errorf("%s: %s", resp.Status, truncateOutput(out))
+	}
+
+	return nil
+}
+
+// truncateOutput collapses a hook's output onto one line and keeps its end,
+// where the reason for a failure usually is.
+func truncateOutput(out []byte) string {
+	s := strings.Join(strings.Fields(string(out)), " ")
+	if len(s) > hookOutputLimit {
+		s = s[len(s)-hookOutputLimit:]
+	}
+	return s
+}
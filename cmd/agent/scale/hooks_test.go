@@ -0,0 +1,160 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestExecHookEnvironment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsg-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer viper.Reset()
+
+	out := filepath.Join(dir, "env")
+	viper.Set(iconfig.KeyHookPreLaunch, `echo "$TSG_HOOK_EVENT $TSG_NAME $TSG_INSTANCE_ID" > `+out)
+	viper.Set(iconfig.KeyHookPreLaunchTimeout, 10*time.Second)
+	// A saved plan is applied without a group name configured.
+	viper.Set(iconfig.KeyTsgGroupName, "")
+
+	plan := &Plan{AccountName: testAccount, TsgName: "web"}
+	if err := runHook(context.Background(), plan, HookPreLaunch, "launch", &tcc.Instance{ID: "i-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(data)), "pre-launch web i-1"; got != want {
+		t.Errorf("hook saw %q, want %q", got, want)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	var got struct {
+		event string
+		body  hookEvent
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.event = r.Header.Get("X-TSG-Hook-Event")
+		json.NewDecoder(r.Body).Decode(&got.body)
+		switch r.URL.Path {
+		case "/ok":
+		case "/slow":
+			time.Sleep(time.Second)
+		default:
+			http.Error(w, "refused", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		run       string
+		onFailure string
+		wantErr   bool
+	}{
+		{name: "no hook"},
+		{name: "command", run: "exit 0"},
+		{name: "failed command", run: "echo broken; exit 3", wantErr: true},
+		{name: "failed command, continue", run: "exit 3", onFailure: HookContinue},
+		{name: "slow command", run: "sleep 5", wantErr: true},
+		{name: "slow command, continue", run: "sleep 5", onFailure: HookContinue},
+		{name: "webhook", run: srv.URL + "/ok"},
+		{name: "failed webhook", run: srv.URL + "/fail", wantErr: true},
+		{name: "failed webhook, continue", run: srv.URL + "/fail", onFailure: HookContinue},
+		{name: "slow webhook", run: srv.URL + "/slow", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer viper.Reset()
+			viper.Set(iconfig.KeyHookPreTerminate, tt.run)
+			viper.Set(iconfig.KeyHookPreTerminateTimeout, 100*time.Millisecond)
+			viper.Set(iconfig.KeyHookPreTerminateOnFailure, tt.onFailure)
+
+			plan := &Plan{AccountName: testAccount, TsgName: "web", TemplateID: "template"}
+			start := time.Now()
+			err := runHook(context.Background(), plan, HookPreTerminate, "delete", &tcc.Instance{ID: "i-1"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runHook = %v, want error %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+				t.Errorf("hook ran for %s, want it cut off by its timeout", elapsed)
+			}
+		})
+	}
+
+	if got.event != HookPreTerminate {
+		t.Errorf("webhook got event header %q, want %q", got.event, HookPreTerminate)
+	}
+	want := hookEvent{Event: HookPreTerminate, Action: "delete", AccountName: testAccount, TsgName: "web", TemplateID: "template"}
+	if got.body.Instance == nil || got.body.Instance.ID != "i-1" {
+		t.Errorf("webhook got instance %+v, want i-1", got.body.Instance)
+	}
+	got.body.Instance = nil
+	if got.body != want {
+		t.Errorf("webhook got %+v, want %+v", got.body, want)
+	}
+}
+
+func TestPostLaunchHookAbort(t *testing.T) {
+	tests := []struct {
+		name      string
+		onFailure string
+		wantErr   bool
+		wantCount int
+	}{
+		{name: "abort rolls back the launch", onFailure: HookAbort, wantErr: true, wantCount: 1},
+		{name: "continue keeps the launch", onFailure: HookContinue, wantCount: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			api.addMembers("web", 1)
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyInstanceCount, 2)
+			viper.Set(iconfig.KeyInstanceReadyPoll, 10*time.Millisecond)
+			viper.Set(iconfig.KeyInstanceReadyTimeout, 5*time.Second)
+			viper.Set(iconfig.KeyHookPostLaunch, "exit 1")
+			viper.Set(iconfig.KeyHookPostLaunchTimeout, 10*time.Second)
+			viper.Set(iconfig.KeyHookPostLaunchOnFailure, tt.onFailure)
+
+			err := c.MaintainInstanceCount(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MaintainInstanceCount = %v, want error %v", err, tt.wantErr)
+			}
+
+			if got := api.requestCount("POST", "/acct/machines"); got < 1 {
+				t.Fatal("no instance was launched")
+			}
+			if got := api.count(); got != tt.wantCount {
+				t.Errorf("group has %d instances, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
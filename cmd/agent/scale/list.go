@@ -0,0 +1,119 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// listPageSize is the largest page CloudAPI returns from ListMachines.
+	listPageSize = 1000
+
+	// listAttempts bounds how many times a listing is repeated when the
+	// number of instances returned disagrees with the count CloudAPI
+	// reports, which happens when instances are created or deleted while
+	// pages are being fetched.
+	listAttempts = 3
+)
+
+// listAllInstances returns every instance matching tags in each of the
+// group's data centers. The listing fails if any data center can not be
+// listed, so that a data center's members are never planned as if they
+// were gone and its share moved to the others.
+func (c *AgentComputeClient) listAllInstances(ctx context.Context, tags map[string]interface{}) ([]*tcc.Instance, error) {
+	if !c.multiDC() {
+		return c.listDatacenter(ctx, c.primary, tags)
+	}
+
+	var all []*tcc.Instance
+	for _, dc := range c.allDCs() {
+		instances, err := c.listDatacenter(ctx, dc, tags)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list instances in data center %s", dc.Name)
+		}
+		all = append(all, instances...)
+	}
+
+	return all, nil
+}
+
+// listDatacenter returns every instance matching tags in dc, fetching as
+// many pages as needed and cross-checking the result with CloudAPI's count
+// of matching instances so that a group is never silently truncated.
+func (c *AgentComputeClient) listDatacenter(ctx context.Context, dc *Datacenter, tags map[string]interface{}) ([]*tcc.Instance, error) {
+	var lastErr error
+	for attempt := 1; attempt <= listAttempts; attempt++ {
+		expected, err := c.instancesIn(dc).Count(ctx, &tcc.ListInstancesInput{
+			Tags: tags,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		instances, err := c.listPages(ctx, dc, tags, expected)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(instances) == expected {
+			return instances, nil
+		}
+
+		lastErr = fmt.Errorf("listed %d instances but CloudAPI reported %d", len(instances), expected)
+		log.Debug().
+			Int("attempt", attempt).
+			Int("listed", len(instances)).
+			Int("expected", expected).
+			Msg("instance count changed while listing, retrying")
+	}
+
+	return nil, errors.Wrap(lastErr, "unable to get a consistent list of instances")
+}
+
+func (c *AgentComputeClient) listPages(ctx context.Context, dc *Datacenter, tags map[string]interface{}, expected int) ([]*tcc.Instance, error) {
+	seen := make(map[string]bool, expected)
+	instances := make([]*tcc.Instance, 0, expected)
+
+	for offset := 0; ; offset += listPageSize {
+		if offset > math.MaxUint16 {
+			return nil, fmt.Errorf("more than %d instances match, which exceeds what CloudAPI can page through", math.MaxUint16)
+		}
+
+		page, err := c.instancesIn(dc).List(ctx, &tcc.ListInstancesInput{
+			Tags:   tags,
+			Limit:  listPageSize,
+			Offset: uint16(offset),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Instances created or deleted between pages shift the offsets,
+		// which can return the same instance on two pages.
+		for _, instance := range page {
+			if seen[instance.ID] {
+				continue
+			}
+			seen[instance.ID] = true
+			instances = append(instances, instance)
+		}
+
+		if len(page) < listPageSize {
+			c.remember(dc, instances...)
+			return instances, nil
+		}
+	}
+}
@@ -0,0 +1,104 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"testing"
+
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestGetInstanceListPages(t *testing.T) {
+	tests := []struct {
+		name    string
+		members int
+		pages   int
+	}{
+		{name: "single short page", members: 3, pages: 1},
+		{name: "short final page", members: 2500, pages: 3},
+		{name: "empty final page", members: 2000, pages: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			api.addMembers("web", tt.members)
+			api.addMembers("other", 5)
+			c := newTestClient(t, api, "web")
+
+			instances, err := c.GetInstanceList(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(instances) != tt.members {
+				t.Errorf("listed %d instances, want %d", len(instances), tt.members)
+			}
+			if got := api.requestCount("GET", "/acct/machines"); got != tt.pages {
+				t.Errorf("fetched %d pages, want %d", got, tt.pages)
+			}
+			if got := classifyMembers(instances).capacity(); got != tt.members {
+				t.Errorf("capacity = %d, want %d", got, tt.members)
+			}
+		})
+	}
+}
+
+func TestGetInstanceListCountMismatch(t *testing.T) {
+	api := newFakeCloudAPI()
+	api.addMembers("web", 1500)
+	// The first count includes an instance deleted before it was listed.
+	api.countSkew = []int{1}
+	c := newTestClient(t, api, "web")
+
+	instances, err := c.GetInstanceList(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1500 {
+		t.Errorf("listed %d instances, want 1500", len(instances))
+	}
+	if got := api.requestCount("HEAD", "/acct/machines"); got != 2 {
+		t.Errorf("counted %d times, want 2", got)
+	}
+
+	viper.Set(iconfig.KeyInstanceCount, 1600)
+	plan, err := c.newPlan(context.Background(), instances)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Create != 100 || len(plan.Delete) != 0 {
+		t.Errorf("plan creates %d and deletes %d, want 100 and 0", plan.Create, len(plan.Delete))
+	}
+	if len(plan.Members) != 1500 {
+		t.Errorf("plan has %d members, want 1500", len(plan.Members))
+	}
+}
+
+func TestGetInstanceListInconsistent(t *testing.T) {
+	api := newFakeCloudAPI()
+	api.addMembers("web", 1200)
+	api.countSkew = []int{-1, 2, 1}
+	c := newTestClient(t, api, "web")
+
+	if _, err := c.GetInstanceList(context.Background()); err == nil {
+		t.Fatal("expected an error when the count never matches the listing")
+	}
+	if got := api.requestCount("HEAD", "/acct/machines"); got != listAttempts {
+		t.Errorf("counted %d times, want %d", got, listAttempts)
+	}
+
+	// A plan is never computed from a truncated group.
+	viper.Set(iconfig.KeyInstanceCount, 1200)
+	api.countSkew = []int{1, 1, 1}
+	if _, err := c.Plan(context.Background()); err == nil {
+		t.Fatal("expected Plan to fail")
+	}
+}
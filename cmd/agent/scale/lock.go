@@ -0,0 +1,493 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	LockNone     = "none"
+	LockLocal    = "local"
+	LockCloudAPI = "cloudapi"
+
+	lockTag = "tsg.lock"
+
+	// leaseTTL is how long a CloudAPI lease is valid without being renewed.
+	// The holder renews it every leaseRenewInterval while it reconciles.
+	leaseTTL           = 2 * time.Minute
+	leaseRenewInterval = 30 * time.Second
+
+	// leaseCallTimeout bounds each renewal of a lease and each CloudAPI
+	// call releasing it, which can not use the run's context since they
+	// outlive it.
+	leaseCallTimeout = 20 * time.Second
+
+	// leaseAnchors is how many members carry a copy of the lease, so that
+	// deleting one of them during scale-in does not drop the lease.
+	leaseAnchors = 3
+
+	// leaseSettle is how long to wait after writing a lease before reading
+	// it back. CloudAPI has no compare-and-swap, so two scalers racing for
+	// an unheld lease both write it and the one that reads back someone
+	// else's identity backs off. The lease is read back twice, a settle
+	// apart, so that a racing write that lands late is still seen. This
+	// makes a collision unlikely rather than impossible.
+	leaseSettle = time.Second
+
+	lockPollInterval = 2 * time.Second
+)
+
+// groupLock serializes reconciliation of a group. Lost is closed if the
+// lock is taken over while it is held; it is nil for locks that can not be.
+type groupLock interface {
+	Acquire(ctx context.Context, timeout time.Duration) error
+	Lost() <-chan struct{}
+	Release() error
+}
+
+// ValidateLockMode returns an error if mode is not a known lock mode.
+func ValidateLockMode(mode string) error {
+	switch mode {
+	case LockNone, LockLocal, LockCloudAPI:
+		return nil
+	}
+	return fmt.Errorf("unknown lock mode %q (valid modes: %s, %s, %s)", mode, LockNone, LockLocal, LockCloudAPI)
+}
+
+// WithGroupLock runs fn with ctx while holding the configured lock for
+// tsgName.
+func (c *AgentComputeClient) WithGroupLock(ctx context.Context, tsgName string, fn func(context.Context) error) error {
+	mode := config.GetLockMode()
+	if err := ValidateLockMode(mode); err != nil {
+		return err
+	}
+
+	var lock groupLock
+	switch mode {
+	case LockNone:
+		return fn(ctx)
+	case LockLocal:
+		lock = newFileLock(c.client.Client.AccountName, tsgName)
+	case LockCloudAPI:
+		lock = newLeaseLock(c, tsgName)
+	}
+
+	if err := lock.Acquire(ctx, config.GetLockTimeout()); err != nil {
+		return errors.Wrapf(err, "unable to lock TSG %q", tsgName)
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Warn().
+				Str("tsg_name", tsgName).
+				Err(err).
+				Msg("unable to release TSG lock")
+		}
+	}()
+
+	// A reconciliation that loses its lock is cancelled, since another
+	// scaler is now changing the group.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lost := lock.Lost()
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := fn(ctx)
+	select {
+	case <-lost:
+		return fmt.Errorf("lost the lock on TSG %q to another scaler", tsgName)
+	default:
+	}
+	return err
+}
+
+func lockHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// fileLock is an flock(2) on a file named after the account and group, for
+// scalers that all run on the same host.
+type fileLock struct {
+	path string
+	file *os.File
+}
+
+func newFileLock(accountName, tsgName string) *fileLock {
+	name := fmt.Sprintf("tsg-%s-%s.lock",
+		unsafeFileChars.ReplaceAllString(accountName, "_"),
+		unsafeFileChars.ReplaceAllString(tsgName, "_"))
+	return &fileLock{path: filepath.Join(os.TempDir(), name)}
+}
+
+func (l *fileLock) Acquire(ctx context.Context, timeout time.Duration) error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "error opening lock file %s", l.path)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tryFlock(f)
+		if err == nil {
+			l.file = f
+			return nil
+		}
+		if err != errLockHeld {
+			f.Close()
+			return errors.Wrapf(err, "error locking %s", l.path)
+		}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return fmt.Errorf("timed out waiting for lock %s", l.path)
+		}
+		if err := sleepContext(ctx, lockPollInterval); err != nil {
+			f.Close()
+			return err
+		}
+	}
+}
+
+func (l *fileLock) Lost() <-chan struct{} {
+	return nil
+}
+
+func (l *fileLock) Release() error {
+	if l.file == nil {
+		return nil
+	}
+	err := unflock(l.file)
+	l.file.Close()
+	l.file = nil
+	return err
+}
+
+// leaseLock is a lease stored as a tsg.lock tag on the group's members, for
+// scalers on different hosts. Every member listing includes tags, so reading
+// the lease costs a single List; writing it tags the leaseAnchors members
+// with the lowest IDs. A group with no members has nowhere to store a lease,
+// so it can not be locked this way.
+type leaseLock struct {
+	client  *AgentComputeClient
+	tsgName string
+	holder  string
+
+	mu      sync.Mutex
+	anchors []string
+	stop    chan struct{}
+	done    chan struct{}
+	lost    chan struct{}
+}
+
+func newLeaseLock(c *AgentComputeClient, tsgName string) *leaseLock {
+	return &leaseLock{
+		client:  c,
+		tsgName: tsgName,
+		holder:  lockHolder(),
+		lost:    make(chan struct{}),
+	}
+}
+
+type lease struct {
+	holder  string
+	expires time.Time
+}
+
+func parseLease(value string) (lease, bool) {
+	i := strings.LastIndex(value, "|")
+	if i < 0 {
+		return lease{}, false
+	}
+	secs, err := strconv.ParseInt(value[i+1:], 10, 64)
+	if err != nil {
+		return lease{}, false
+	}
+	return lease{holder: value[:i], expires: time.Unix(secs, 0)}, true
+}
+
+// current returns the unexpired lease with the latest expiry found on any
+// member, and the members sorted by ID. Leases with the same expiry, as
+// written by scalers racing within the same second, are ordered by holder,
+// so that every scaler agrees on the winner whatever order the members are
+// listed in.
+func (l *leaseLock) current(ctx context.Context) (*lease, []*tcc.Instance, error) {
+	instances, err := l.client.listGroupInstances(ctx, l.tsgName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var found *lease
+	now := time.Now()
+	for _, instance := range instances {
+		value, ok := instance.Tags[lockTag].(string)
+		if !ok {
+			continue
+		}
+		ls, ok := parseLease(value)
+		if !ok || ls.expires.Before(now) {
+			continue
+		}
+		if found == nil || ls.expires.After(found.expires) ||
+			(ls.expires.Equal(found.expires) && ls.holder < found.holder) {
+			found = &ls
+		}
+	}
+
+	sorted := make([]*tcc.Instance, len(instances))
+	copy(sorted, instances)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	return found, sorted, nil
+}
+
+func (l *leaseLock) write(ctx context.Context, instances []*tcc.Instance) error {
+	value := fmt.Sprintf("%s|%d", l.holder, time.Now().Add(leaseTTL).Unix())
+
+	anchors := instances
+	if len(anchors) > leaseAnchors {
+		anchors = anchors[:leaseAnchors]
+	}
+
+	ids := make([]string, 0, len(anchors))
+	for _, instance := range anchors {
+		err := l.client.instances().AddTags(ctx, &tcc.AddTagsInput{
+			ID:   instance.ID,
+			Tags: map[string]string{lockTag: value},
+		})
+		if err != nil {
+			return err
+		}
+		ids = append(ids, instance.ID)
+	}
+
+	l.mu.Lock()
+	l.anchors = mergeIDs(l.anchors, ids)
+	l.mu.Unlock()
+
+	return nil
+}
+
+func (l *leaseLock) Acquire(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		held, instances, err := l.current(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(instances) == 0 {
+			return fmt.Errorf("TSG has no instances to hold a lease, "+
+				"use --lock=%s or --lock=%s to scale it from zero", LockLocal, LockNone)
+		}
+
+		if held == nil || held.holder == l.holder {
+			if err := l.write(ctx, instances); err != nil {
+				return err
+			}
+
+			held, err = l.settle(ctx)
+			if err != nil {
+				return err
+			}
+			if held != nil && held.holder == l.holder {
+				l.startRenewing()
+				return nil
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			holder := "another scaler"
+			if held != nil {
+				holder = held.holder
+			}
+			return fmt.Errorf("timed out waiting for lease held by %s", holder)
+		}
+
+		if err := sleepContext(ctx, lockPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// settle reads the lease back after it was written, twice, a settle apart,
+// and returns the first lease found that is not this scaler's, or this
+// scaler's lease if it was found both times.
+func (l *leaseLock) settle(ctx context.Context) (*lease, error) {
+	var held *lease
+	for i := 0; i < 2; i++ {
+		if err := sleepContext(ctx, leaseSettle); err != nil {
+			return nil, err
+		}
+
+		var err error
+		held, _, err = l.current(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if held == nil || held.holder != l.holder {
+			return held, nil
+		}
+	}
+	return held, nil
+}
+
+func (l *leaseLock) startRenewing() {
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+
+	go func() {
+		defer close(l.done)
+
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+
+		renewed := time.Now()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				// The lease is renewed until Release even if the run is
+				// cancelled, since rollback still happens under it.
+				ctx, cancel := context.WithTimeout(context.Background(), leaseCallTimeout)
+				err := l.renew(ctx)
+				cancel()
+				if err == errLeaseLost {
+					l.loseLease("TSG lease was taken by another scaler")
+					return
+				}
+				if err != nil {
+					log.Warn().
+						Str("tsg_name", l.tsgName).
+						Err(err).
+						Msg("unable to renew TSG lease")
+					if time.Since(renewed) >= leaseTTL {
+						l.loseLease("TSG lease expired before it could be renewed")
+						return
+					}
+					continue
+				}
+				renewed = time.Now()
+			}
+		}
+	}()
+}
+
+var errLeaseLost = errors.New("lease held by another scaler")
+
+// renew extends the lease, unless another scaler has taken it since it was
+// last written.
+func (l *leaseLock) renew(ctx context.Context) error {
+	held, instances, err := l.current(ctx)
+	if err != nil {
+		return err
+	}
+	if held != nil && held.holder != l.holder {
+		return errLeaseLost
+	}
+
+	return l.write(ctx, instances)
+}
+
+func (l *leaseLock) loseLease(msg string) {
+	log.Error().
+		Str("tsg_name", l.tsgName).
+		Msg(msg)
+	close(l.lost)
+}
+
+func (l *leaseLock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops renewing the lease and removes it from the members still
+// carrying this scaler's lease, leaving any lease since written by another
+// scaler in place.
+func (l *leaseLock) Release() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+	}
+
+	l.mu.Lock()
+	anchors := l.anchors
+	l.anchors = nil
+	l.mu.Unlock()
+
+	if len(anchors) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), leaseCallTimeout)
+	_, instances, err := l.current(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, instance := range lookupMembers(instances, anchors) {
+		value, _ := instance.Tags[lockTag].(string)
+		if ls, ok := parseLease(value); !ok || ls.holder != l.holder {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), leaseCallTimeout)
+		err := l.client.instances().DeleteTag(ctx, &tcc.DeleteTagInput{
+			ID:  instance.ID,
+			Key: lockTag,
+		})
+		cancel()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func mergeIDs(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	merged := make([]string, 0, len(a)+len(b))
+	for _, id := range append(a, b...) {
+		if !seen[id] {
+			seen[id] = true
+			merged = append(merged, id)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,171 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestLeaseLockEmptyGroup(t *testing.T) {
+	api := newFakeCloudAPI()
+	c := newTestClient(t, api, "web")
+	viper.Set(iconfig.KeyLockMode, LockCloudAPI)
+
+	called := false
+	err := c.WithGroupLock(context.Background(), "web", func(context.Context) error {
+		called = true
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected locking an empty group to fail")
+	}
+	if called {
+		t.Error("reconciled an empty group without a lock")
+	}
+}
+
+func TestLeaseLockAcquireRelease(t *testing.T) {
+	api := newFakeCloudAPI()
+	members := api.addMembers("web", 5)
+	c := newTestClient(t, api, "web")
+	viper.Set(iconfig.KeyLockMode, LockCloudAPI)
+
+	err := c.WithGroupLock(context.Background(), "web", func(context.Context) error {
+		var anchored int
+		for _, m := range members {
+			if _, ok := api.instance(m.ID).Tags[lockTag]; ok {
+				anchored++
+			}
+		}
+		if anchored != leaseAnchors {
+			t.Errorf("lease is on %d members, want %d", anchored, leaseAnchors)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range members {
+		if _, ok := api.instance(m.ID).Tags[lockTag]; ok {
+			t.Errorf("lease left on %s after release", m.ID)
+		}
+	}
+}
+
+func TestLeaseLockRenewTakenOver(t *testing.T) {
+	api := newFakeCloudAPI()
+	members := api.addMembers("web", 2)
+	c := newTestClient(t, api, "web")
+
+	l := newLeaseLock(c, "web")
+	l.anchors = []string{members[0].ID, members[1].ID}
+
+	// Another scaler took the lease after ours expired.
+	other := fmt.Sprintf("other|%d", time.Now().Add(leaseTTL).Unix())
+	for _, m := range members {
+		api.instance(m.ID).Tags[lockTag] = other
+	}
+
+	if err := l.renew(context.Background()); err != errLeaseLost {
+		t.Fatalf("renew = %v, want %v", err, errLeaseLost)
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range members {
+		if got := api.instance(m.ID).Tags[lockTag]; got != other {
+			t.Errorf("lease on %s = %v, want the other scaler's lease kept", m.ID, got)
+		}
+	}
+}
+
+func TestLeaseCurrentTieBreak(t *testing.T) {
+	expires := time.Now().Add(leaseTTL).Unix()
+	leases := []string{
+		fmt.Sprintf("b|%d", expires),
+		fmt.Sprintf("a|%d", expires),
+		fmt.Sprintf("c|%d", expires-1),
+	}
+
+	// Every listing order must agree on the same lease.
+	for shift := range leases {
+		t.Run(fmt.Sprint(shift), func(t *testing.T) {
+			api := newFakeCloudAPI()
+			members := api.addMembers("web", len(leases))
+			for i, m := range members {
+				m.Tags[lockTag] = leases[(i+shift)%len(leases)]
+			}
+			c := newTestClient(t, api, "web")
+
+			held, _, err := newLeaseLock(c, "web").current(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if held == nil || held.holder != "a" {
+				t.Errorf("current lease = %+v, want the one held by a", held)
+			}
+		})
+	}
+}
+
+func TestLeaseLockRace(t *testing.T) {
+	tests := []struct {
+		name string
+		// list is the listing of the group, counted from the first,
+		// before which the other scaler's write lands.
+		list int
+	}{
+		{name: "during the first settle", list: 2},
+		{name: "during the second settle", list: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			members := api.addMembers("web", 1)
+			c := newTestClient(t, api, "web")
+
+			// The other scaler read the lease as free at the same time
+			// and its holder sorts first, so it wins.
+			var lists int
+			api.intercept = func(r *http.Request) int {
+				if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/machines") {
+					return 0
+				}
+				if lists++; lists == tt.list {
+					api.mu.Lock()
+					defer api.mu.Unlock()
+					value, _ := api.instances[members[0].ID].Tags[lockTag].(string)
+					ls, _ := parseLease(value)
+					api.instances[members[0].ID].Tags[lockTag] = fmt.Sprintf("!other|%d", ls.expires.Unix())
+				}
+				return 0
+			}
+
+			l := newLeaseLock(c, "web")
+			err := l.Acquire(context.Background(), 0)
+			if err == nil {
+				l.Release()
+				t.Fatal("acquired a lease another scaler took")
+			}
+			if !strings.Contains(err.Error(), "!other") {
+				t.Errorf("Acquire = %v, want it to name the other scaler", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// actionResult records the outcome of a single create or delete performed
+// while reconciling a group.
+type actionResult struct {
+	Instance *tcc.Instance
+	Err      error
+
+	// Skipped is set when the action was never started because ctx was
+	// done before a worker picked it up.
+	Skipped bool
+}
+
+// forEachParallel calls fn for every index in [0, count) using at most
+// parallelism concurrent workers and returns the results in index order.
+// Once ctx is done no further calls are started, and the indexes that were
+// not reached are returned as skipped.
+func forEachParallel(ctx context.Context, count int, parallelism int, fn func(i int) *actionResult) []*actionResult {
+	results := make([]*actionResult, count)
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if parallelism > count {
+		parallelism = count
+	}
+
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = fn(i)
+			}
+		}()
+	}
+
+dispatch:
+	for i := 0; i < count; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			for ; i < count; i++ {
+				results[i] = &actionResult{Err: ctx.Err(), Skipped: true}
+			}
+			break dispatch
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	return results
+}
+
+// summarizeResults returns the first error found in results, annotated with
+// how many of the actions failed, or nil if every action succeeded. If ctx
+// was cancelled while the actions ran, it also logs which of them completed,
+// which failed and how many were never started.
+func summarizeResults(ctx context.Context, plan *Plan, action string, results []*actionResult) error {
+	var completed, failed []string
+	var skipped int
+	var first error
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			skipped++
+		case r.Err == nil:
+			completed = append(completed, resultID(r))
+		default:
+			failed = append(failed, resultID(r))
+		}
+		if r.Err != nil && first == nil {
+			first = r.Err
+		}
+	}
+
+	if ctx.Err() != nil {
+		log.Warn().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "cancelled").
+			Str("notification_type", "TSG_RECONCILE_CANCELLED").
+			Str("description", fmt.Sprintf("%s: completed [%s], failed [%s], not started %d",
+				action, strings.Join(completed, ", "), strings.Join(failed, ", "), skipped)).
+			Msgf("Reconciliation was cancelled: %d of %d instance %s(s) completed", len(completed), len(results), action)
+	}
+
+	if first == nil {
+		return nil
+	}
+
+	return errors.Wrapf(first, "%d of %d instance %s(s) failed", len(failed)+skipped, len(results), action)
+}
+
+func resultID(r *actionResult) string {
+	if r.Instance == nil {
+		return "(not created)"
+	}
+	return r.Instance.ID
+}
@@ -0,0 +1,141 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/pkg/errors"
+)
+
+func TestForEachParallel(t *testing.T) {
+	tests := []struct {
+		name        string
+		count       int
+		parallelism int
+		wantPeak    int
+	}{
+		{name: "bounded", count: 10, parallelism: 3, wantPeak: 3},
+		{name: "fewer than parallelism", count: 2, parallelism: 5, wantPeak: 2},
+		{name: "serial", count: 4, parallelism: 1, wantPeak: 1},
+		{name: "no parallelism", count: 4, parallelism: 0, wantPeak: 1},
+		{name: "none", count: 0, parallelism: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var inFlight, peak int
+
+			results := forEachParallel(context.Background(), tt.count, tt.parallelism, func(i int) *actionResult {
+				mu.Lock()
+				if inFlight++; inFlight > peak {
+					peak = inFlight
+				}
+				mu.Unlock()
+
+				// Later indexes finish first.
+				time.Sleep(time.Duration(tt.count-i) * 5 * time.Millisecond)
+
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				return &actionResult{Instance: &tcc.Instance{ID: strconv.Itoa(i)}}
+			})
+
+			if peak != tt.wantPeak {
+				t.Errorf("%d calls in flight at once, want %d", peak, tt.wantPeak)
+			}
+			if len(results) != tt.count {
+				t.Fatalf("got %d results, want %d", len(results), tt.count)
+			}
+			for i, r := range results {
+				if r.Instance.ID != strconv.Itoa(i) {
+					t.Errorf("result %d is for %s, want results in index order", i, r.Instance.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestForEachParallelCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int
+	results := forEachParallel(ctx, 5, 1, func(i int) *actionResult {
+		calls++
+		cancel()
+		// The worker is busy when the next index is dispatched, so only
+		// the cancellation is ready.
+		time.Sleep(50 * time.Millisecond)
+		return &actionResult{}
+	})
+
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+	if results[0].Skipped || results[0].Err != nil {
+		t.Errorf("result 0 = %+v, want it completed", results[0])
+	}
+	for i, r := range results[1:] {
+		if !r.Skipped || r.Err != context.Canceled {
+			t.Errorf("result %d = %+v, want it skipped with %v", i+1, r, context.Canceled)
+		}
+	}
+}
+
+func TestSummarizeResults(t *testing.T) {
+	boom := errors.New("boom")
+	ok := &actionResult{Instance: &tcc.Instance{ID: "i-1"}}
+	failed := &actionResult{Instance: &tcc.Instance{ID: "i-2"}, Err: boom}
+	notCreated := &actionResult{Err: errors.New("refused")}
+	skipped := &actionResult{Err: context.Canceled, Skipped: true}
+
+	tests := []struct {
+		name    string
+		results []*actionResult
+		want    string
+	}{
+		{name: "none"},
+		{name: "all completed", results: []*actionResult{ok, ok}},
+		{name: "one failed", results: []*actionResult{ok, failed, ok}, want: "1 of 3 instance delete(s) failed: boom"},
+		{name: "first error wins", results: []*actionResult{notCreated, failed}, want: "2 of 2 instance delete(s) failed: refused"},
+		{name: "skipped count as failed", results: []*actionResult{failed, skipped, skipped}, want: "3 of 3 instance delete(s) failed: boom"},
+		{name: "only skipped", results: []*actionResult{ok, skipped}, want: "1 of 2 instance delete(s) failed: context canceled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &Plan{AccountName: testAccount, TsgName: "web"}
+			err := summarizeResults(context.Background(), plan, "delete", tt.results)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("summarizeResults = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("summarizeResults = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := summarizeResults(ctx, &Plan{}, "delete", []*actionResult{ok, failed, skipped})
+	if errors.Cause(err) != boom {
+		t.Errorf("summarizeResults after cancellation = %v, want it to wrap %v", err, boom)
+	}
+}
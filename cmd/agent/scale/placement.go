@@ -0,0 +1,99 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tcc "github.com/joyent/triton-go/compute"
+)
+
+// How new members are spread across compute nodes.
+const (
+	SpreadNone = "none"
+	SpreadSoft = "soft"
+	SpreadHard = "hard"
+)
+
+// ValidateSpread returns an error if mode is not a known spread mode.
+func ValidateSpread(mode string) error {
+	switch mode {
+	case "", SpreadNone, SpreadSoft, SpreadHard:
+		return nil
+	}
+	return fmt.Errorf("unknown spread mode %q (valid modes: %s, %s, %s)", mode, SpreadNone, SpreadSoft, SpreadHard)
+}
+
+// spreadAffinityRule returns the affinity rule keeping a new member of
+// tsgName off the compute nodes already running one, or "" if members are
+// not spread. The rule matches the tsg.name tag, so it covers every member
+// without listing them. A soft rule is only a preference; a hard rule makes
+// the launch fail when every compute node already has a member.
+func spreadAffinityRule(mode, tsgName string) string {
+	if tsgName == "" {
+		return ""
+	}
+
+	switch mode {
+	case SpreadSoft:
+		return fmt.Sprintf("tsg.name!=~%s", tsgName)
+	case SpreadHard:
+		return fmt.Sprintf("tsg.name!=%s", tsgName)
+	}
+	return ""
+}
+
+// computeNodeCounts counts instances by the compute node they are on.
+// Instances not yet placed on a compute node are not counted.
+func computeNodeCounts(instances []*tcc.Instance) map[string]int {
+	counts := make(map[string]int)
+	for _, instance := range instances {
+		if instance.ComputeNode != "" {
+			counts[instance.ComputeNode]++
+		}
+	}
+	return counts
+}
+
+// maxPerNode returns the largest number of instances on one compute node.
+func maxPerNode(counts map[string]int) int {
+	var max int
+	for _, n := range counts {
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// writeComputeNodes writes the number of members on each compute node, most
+// crowded first.
+func writeComputeNodes(b *strings.Builder, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+
+	nodes := make([]string, 0, len(counts))
+	for node := range counts {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if counts[nodes[i]] != counts[nodes[j]] {
+			return counts[nodes[i]] > counts[nodes[j]]
+		}
+		return nodes[i] < nodes[j]
+	})
+
+	fmt.Fprintf(b, "\nMembers per compute node (%d node(s), at most %d on one node):\n", len(nodes), maxPerNode(counts))
+	for _, node := range nodes {
+		fmt.Fprintf(b, "  %s  %d\n", node, counts[node])
+	}
+}
@@ -0,0 +1,128 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestSpreadAffinityRule(t *testing.T) {
+	tests := []struct {
+		mode    string
+		tsgName string
+		want    string
+	}{
+		{mode: SpreadSoft, tsgName: "web", want: "tsg.name!=~web"},
+		{mode: SpreadHard, tsgName: "web", want: "tsg.name!=web"},
+		{mode: SpreadNone, tsgName: "web", want: ""},
+		{mode: "", tsgName: "web", want: ""},
+		{mode: SpreadSoft, tsgName: "", want: ""},
+		{mode: SpreadHard, tsgName: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := spreadAffinityRule(tt.mode, tt.tsgName); got != tt.want {
+			t.Errorf("spreadAffinityRule(%q, %q) = %q, want %q", tt.mode, tt.tsgName, got, tt.want)
+		}
+	}
+}
+
+func TestCreateInputSpread(t *testing.T) {
+	tests := []struct {
+		name     string
+		spread   string
+		affinity []string
+		want     []string
+	}{
+		{name: "none", spread: SpreadNone},
+		{name: "soft", spread: SpreadSoft, want: []string{"tsg.name!=~web"}},
+		{name: "hard", spread: SpreadHard, want: []string{"tsg.name!=web"}},
+		{name: "with affinity rules", spread: SpreadSoft, affinity: []string{"role!=db"}, want: []string{"role!=db", "tsg.name!=~web"}},
+		{name: "only affinity rules", spread: SpreadNone, affinity: []string{"role!=db"}, want: []string{"role!=db"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer viper.Reset()
+			viper.Set(iconfig.KeyTsgGroupName, "web")
+			viper.Set(iconfig.KeyInstanceSpread, tt.spread)
+			if tt.affinity != nil {
+				viper.Set(iconfig.KeyInstanceAffinityRule, tt.affinity)
+			}
+
+			input, err := BuildCreateInstanceInput("template")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(input.Affinity, tt.want) {
+				t.Errorf("Affinity = %q, want %q", input.Affinity, tt.want)
+			}
+		})
+	}
+
+	defer viper.Reset()
+	viper.Set(iconfig.KeyInstanceSpread, "everywhere")
+	if _, err := BuildCreateInstanceInput("template"); err == nil {
+		t.Error("expected an error for an unknown spread mode")
+	}
+}
+
+func TestPlanComputeNodes(t *testing.T) {
+	api := newFakeCloudAPI()
+	api.addMembers("web", 3, "cn1")
+	api.addMembers("web", 2, "cn3", "cn2")
+	// Members not yet on a compute node, and warm members, are not counted.
+	api.addMembers("web", 1)
+	warm := api.addMembers("web", 1, "cn4")[0]
+	warm.State = "stopped"
+	warm.Tags[poolTag] = poolWarm
+
+	c := newTestClient(t, api, "web")
+	viper.Set(iconfig.KeyInstanceCount, 6)
+
+	instances, err := c.GetInstanceList(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := c.newPlan(context.Background(), instances)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{"cn1": 3, "cn2": 1, "cn3": 1}
+	if !reflect.DeepEqual(plan.ComputeNodes, want) {
+		t.Errorf("ComputeNodes = %v, want %v", plan.ComputeNodes, want)
+	}
+
+	var b strings.Builder
+	if err := plan.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	report := "\nMembers per compute node (3 node(s), at most 3 on one node):\n" +
+		"  cn1  3\n" +
+		"  cn2  1\n" +
+		"  cn3  1\n"
+	if !strings.Contains(b.String(), report) {
+		t.Errorf("plan text does not contain the compute node report\n%s\nwant\n%s", b.String(), report)
+	}
+
+	var empty strings.Builder
+	if err := (&Plan{}).WriteText(&empty); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(empty.String(), "compute node") {
+		t.Errorf("plan without placed members reports compute nodes:\n%s", empty.String())
+	}
+}
@@ -0,0 +1,483 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/pkg/errors"
+)
+
+const planFormatVersion = 1
+
+// Plan describes the actions needed to reconcile a group with its expected
+// instance count.
+type Plan struct {
+	Version       int       `json:"version"`
+	CreatedAt     time.Time `json:"created_at"`
+	AccountName   string    `json:"account_name"`
+	TsgName       string    `json:"tsg_name"`
+	TemplateID    string    `json:"template_id"`
+	ExpectedCount int       `json:"expected_count"`
+	Schedule      string    `json:"schedule,omitempty"`
+
+	// Members is the group as it was when the plan was computed, so that a
+	// saved plan is only ever applied to the same group.
+	Members []string `json:"members"`
+	Delete  []string `json:"delete"`
+
+	// Provisioning members count toward the expected size as pending
+	// capacity; Terminating members do not.
+	Provisioning []string `json:"provisioning,omitempty"`
+	Terminating  []string `json:"terminating,omitempty"`
+
+	// Unhealthy members are deleted once any instances being created are
+	// running.
+	Unhealthy []string `json:"unhealthy,omitempty"`
+
+	// Protected members are never deleted. Shortfall is how many deletions
+	// scale-in needed but could not plan because of them.
+	Protected []string `json:"protected,omitempty"`
+	Shortfall int      `json:"shortfall,omitempty"`
+
+	// Warm members are in the warm pool. Stop lists scale-in members stopped
+	// into the pool rather than deleted, Start lists warm members started
+	// before any new instance is created and Expire lists warm members that
+	// are deleted.
+	Warm   []string `json:"warm,omitempty"`
+	Stop   []string `json:"stop,omitempty"`
+	Start  []string `json:"start,omitempty"`
+	Expire []string `json:"expire,omitempty"`
+
+	// Failed members are failed launches kept for inspection.
+	Failed []string `json:"failed,omitempty"`
+
+	// Deferred explains any actions held back by a cooldown.
+	Deferred []string `json:"deferred,omitempty"`
+
+	Create      int                      `json:"create"`
+	CreateInput *tcc.CreateInstanceInput `json:"create_input,omitempty"`
+
+	// CreateIn is how many new instances are created in each data center,
+	// and Datacenters the expected count's target share in each one, when
+	// the group spans several data centers.
+	CreateIn    map[string]int `json:"create_in,omitempty"`
+	Datacenters []*Datacenter  `json:"datacenters,omitempty"`
+
+	// ComputeNodes counts the ready and provisioning members on each
+	// compute node.
+	ComputeNodes map[string]int `json:"compute_nodes,omitempty"`
+}
+
+// Plan computes the actions MaintainInstanceCount would take without
+// performing any of them.
+func (c *AgentComputeClient) Plan(ctx context.Context) (*Plan, error) {
+	instances, err := c.GetInstanceList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := c.newPlan(ctx, instances)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyCooldown(plan); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// Apply executes a previously computed plan. It refuses to run if the
+// group's members have changed since the plan was computed, and the
+// cooldowns are checked again so that a saved plan does not scale a group
+// that has scaled since.
+func (c *AgentComputeClient) Apply(ctx context.Context, plan *Plan) error {
+	if plan.Version != planFormatVersion {
+		return fmt.Errorf("unsupported plan version %d", plan.Version)
+	}
+
+	if plan.AccountName != c.client.Client.AccountName {
+		return fmt.Errorf("plan was computed for account %q, not %q",
+			plan.AccountName, c.client.Client.AccountName)
+	}
+
+	if len(plan.Datacenters) > 0 {
+		if err := c.useDatacenters(plan.Datacenters); err != nil {
+			return err
+		}
+	}
+
+	instances, err := c.listGroupInstances(ctx, plan.TsgName)
+	if err != nil {
+		return err
+	}
+
+	if !sameMembers(plan.Members, instanceIDs(instances)) {
+		return fmt.Errorf("the instances in TSG %q have changed since the plan was computed, "+
+			"please create a new plan", plan.TsgName)
+	}
+
+	if err := applyCooldown(plan); err != nil {
+		return err
+	}
+
+	return c.execute(ctx, plan, instances)
+}
+
+func (c *AgentComputeClient) newPlan(ctx context.Context, instances []*tcc.Instance) (*Plan, error) {
+	templateID := config.GetTsgTemplateID()
+
+	plan := &Plan{
+		Version:     planFormatVersion,
+		CreatedAt:   time.Now().UTC(),
+		AccountName: c.client.Client.AccountName,
+		TsgName:     config.GetTsgName(),
+		TemplateID:  templateID,
+		Members:     instanceIDs(instances),
+	}
+
+	members := classifyMembers(instances)
+	if c.health != nil {
+		c.checkMembers(ctx, plan, members)
+	}
+
+	expected, scheduleName, err := c.expectedCount(ctx, members.capacity())
+	if err != nil {
+		return nil, err
+	}
+	plan.ExpectedCount = expected
+	plan.Schedule = scheduleName
+	plan.Provisioning = instanceIDs(members.provisioning)
+	plan.Terminating = instanceIDs(members.terminating)
+	plan.Unhealthy = instanceIDs(members.unhealthy)
+	plan.ComputeNodes = computeNodeCounts(append(members.ready, members.provisioning...))
+
+	_, protected := splitProtected(instances)
+	plan.Protected = instanceIDs(protected)
+
+	// A group spread across data centers is moved toward each data
+	// center's target share as it scales in and out.
+	var dcs []*Datacenter
+	var targets map[string]int
+	if c.multiDC() {
+		dcs = c.allDCs()
+		targets = distribute(plan.ExpectedCount, dcs)
+		for _, dc := range dcs {
+			plan.Datacenters = append(plan.Datacenters, &Datacenter{
+				Name:   dc.Name,
+				URL:    dc.URL,
+				Weight: dc.Weight,
+				Target: targets[dc.Name],
+			})
+		}
+	}
+
+	scaleCount := plan.ExpectedCount - members.capacity()
+
+	start, expire, kept := planWarmPool(members.warm, scaleCount)
+	plan.Warm = instanceIDs(members.warm)
+	plan.Failed = instanceIDs(members.failed)
+	plan.Expire = instanceIDs(expire)
+
+	if scaleCount < 0 {
+		// Members that are still provisioning are only deleted once there
+		// are no ready members left to choose from.
+		// Protected members are never candidates.
+		policies := config.GetTerminationPolicies()
+		ready, _ := splitProtected(members.ready)
+		candidates, err := selectForTermination(ready, -scaleCount, policies)
+		if err != nil {
+			return nil, err
+		}
+		if targets != nil {
+			ordered, err := selectForTermination(ready, len(ready), policies)
+			if err != nil {
+				return nil, err
+			}
+			current := c.countByDatacenter(members.ready, members.provisioning)
+			candidates = c.spreadTermination(ordered, -scaleCount, current, targets)
+		}
+		if remaining := -scaleCount - len(candidates); remaining > 0 {
+			provisioning, _ := splitProtected(members.provisioning)
+			pending, err := selectForTermination(provisioning, remaining, policies)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, pending...)
+		}
+		plan.Shortfall = -scaleCount - len(candidates)
+
+		// Running members are stopped into the warm pool while it has room;
+		// the rest are deleted.
+		room := config.GetWarmPoolSize() - len(kept)
+		for _, instance := range candidates {
+			if room > 0 && instance.State == StateRunning {
+				plan.Stop = append(plan.Stop, instance.ID)
+				room--
+				continue
+			}
+			plan.Delete = append(plan.Delete, instance.ID)
+		}
+	} else if scaleCount > 0 {
+		plan.Start = instanceIDs(start)
+		if create := scaleCount - len(start); create > 0 {
+			input, err := BuildCreateInstanceInput(templateID)
+			if err != nil {
+				return nil, err
+			}
+			plan.Create = create
+			plan.CreateInput = input
+			if targets != nil {
+				current := c.countByDatacenter(members.ready, members.provisioning, start)
+				plan.CreateIn = allocateCreates(create, current, targets, dcs)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// HasChanges reports whether applying the plan would create or delete any
+// instances.
+func (p *Plan) HasChanges() bool {
+	return len(p.Delete) > 0 || p.Create > 0 || len(p.Unhealthy) > 0 ||
+		len(p.Stop) > 0 || len(p.Start) > 0 || len(p.Expire) > 0
+}
+
+// ReadyCount returns the number of members that were neither provisioning,
+// terminating, unhealthy nor warm when the plan was computed.
+func (p *Plan) ReadyCount() int {
+	return len(p.Members) - len(p.Provisioning) - len(p.Terminating) - len(p.Unhealthy) - len(p.Warm) - len(p.Failed)
+}
+
+// members returns the instances with the given IDs, failing if any of them
+// is no longer a member of the group.
+func (p *Plan) members(instances []*tcc.Instance, ids []string) ([]*tcc.Instance, error) {
+	found := lookupMembers(instances, ids)
+	if len(found) != len(ids) {
+		return nil, fmt.Errorf("planned instances are no longer members of TSG %q", p.TsgName)
+	}
+	return found, nil
+}
+
+// WriteText writes a human readable description of the plan to w.
+func (p *Plan) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "TSG %q (account %q, template %q)\n", p.TsgName, p.AccountName, p.TemplateID)
+	fmt.Fprintf(&b, "  Expected instances: %d\n", p.ExpectedCount)
+	if p.Schedule != "" {
+		fmt.Fprintf(&b, "  Schedule:           %s\n", p.Schedule)
+	}
+	fmt.Fprintf(&b, "  Current instances:  %d\n", len(p.Members))
+	fmt.Fprintf(&b, "  Ready:              %d\n", p.ReadyCount())
+	fmt.Fprintf(&b, "  Provisioning:       %d\n", len(p.Provisioning))
+	fmt.Fprintf(&b, "  Terminating:        %d\n", len(p.Terminating))
+	fmt.Fprintf(&b, "  Unhealthy:          %d\n", len(p.Unhealthy))
+	fmt.Fprintf(&b, "  Protected:          %d\n", len(p.Protected))
+	fmt.Fprintf(&b, "  Warm:               %d\n", len(p.Warm))
+	if len(p.Failed) > 0 {
+		fmt.Fprintf(&b, "  Failed (kept):      %d\n", len(p.Failed))
+	}
+	for _, dc := range p.Datacenters {
+		fmt.Fprintf(&b, "  Data center %s: weight %d, target %d\n", dc.Name, dc.Weight, dc.Target)
+	}
+	writeComputeNodes(&b, p.ComputeNodes)
+
+	if p.Shortfall > 0 {
+		fmt.Fprintf(&b, "\n%d instance(s) can not be deleted because they are protected.\n", p.Shortfall)
+	}
+
+	writeIDs(&b, "%d failed launch(es) kept for inspection, not counted and never deleted by the agent; delete them by hand:", p.Failed)
+
+	for _, reason := range p.Deferred {
+		fmt.Fprintf(&b, "\nCooldown: %s.\n", reason)
+	}
+
+	if !p.HasChanges() {
+		fmt.Fprintf(&b, "\nNo changes.\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	writeIDs(&b, "Stop %d instance(s) into the warm pool:", p.Stop)
+	writeIDs(&b, "Delete %d instance(s):", p.Delete)
+	writeIDs(&b, "Start %d warm instance(s):", p.Start)
+	writeIDs(&b, "Delete %d expired warm instance(s):", p.Expire)
+
+	if len(p.Unhealthy) > 0 {
+		protected := make(map[string]bool, len(p.Protected))
+		for _, id := range p.Protected {
+			protected[id] = true
+		}
+
+		fmt.Fprintf(&b, "\nReplace %d unhealthy instance(s):\n", len(p.Unhealthy))
+		for _, id := range p.Unhealthy {
+			if protected[id] {
+				fmt.Fprintf(&b, "  - %s (protected, will not be deleted)\n", id)
+				continue
+			}
+			fmt.Fprintf(&b, "  - %s\n", id)
+		}
+	}
+
+	if p.Create > 0 && p.CreateInput != nil {
+		in := p.CreateInput
+		fmt.Fprintf(&b, "\nCreate %d instance(s):\n", p.Create)
+		fmt.Fprintf(&b, "  Package:  %s\n", in.Package)
+		fmt.Fprintf(&b, "  Image:    %s\n", in.Image)
+		fmt.Fprintf(&b, "  Firewall: %t\n", in.FirewallEnabled)
+		for _, dc := range p.Datacenters {
+			if n := p.CreateIn[dc.Name]; n > 0 {
+				fmt.Fprintf(&b, "  In %s:  %d\n", dc.Name, n)
+			}
+		}
+		if len(in.Networks) > 0 {
+			fmt.Fprintf(&b, "  Networks: %s\n", strings.Join(in.Networks, ", "))
+		}
+		if len(in.Affinity) > 0 {
+			fmt.Fprintf(&b, "  Affinity: %s\n", strings.Join(in.Affinity, ", "))
+		}
+		if len(in.Tags) > 0 {
+			fmt.Fprintf(&b, "  Tags:\n")
+			for _, k := range sortedKeys(in.Tags) {
+				fmt.Fprintf(&b, "    %s=%s\n", k, in.Tags[k])
+			}
+		}
+		if len(in.Metadata) > 0 {
+			fmt.Fprintf(&b, "  Metadata keys: %s\n", strings.Join(sortedKeys(in.Metadata), ", "))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeIDs(b *strings.Builder, heading string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "\n"+heading+"\n", len(ids))
+	for _, id := range ids {
+		fmt.Fprintf(b, "  - %s\n", id)
+	}
+}
+
+// redactedValue replaces metadata values in plans shown to users.
+const redactedValue = "<redacted>"
+
+// Redacted returns a copy of the plan whose metadata values, which may hold
+// user-data and secrets, are replaced with a placeholder. Only the saved
+// plan file keeps them, since applying it needs them.
+func (p *Plan) Redacted() *Plan {
+	if p.CreateInput == nil || len(p.CreateInput.Metadata) == 0 {
+		return p
+	}
+
+	redacted := *p
+	input := *p.CreateInput
+	input.Metadata = make(map[string]string, len(p.CreateInput.Metadata))
+	for k := range p.CreateInput.Metadata {
+		input.Metadata[k] = redactedValue
+	}
+	redacted.CreateInput = &input
+
+	return &redacted
+}
+
+// WriteFile saves the plan as JSON so that it can later be passed to Apply.
+func (p *Plan) WriteFile(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error encoding plan")
+	}
+
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return errors.Wrapf(err, "error writing plan to %s", path)
+	}
+
+	return nil
+}
+
+// ReadPlanFile loads a plan previously saved with WriteFile.
+func ReadPlanFile(path string) (*Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading plan from %s", path)
+	}
+
+	plan := &Plan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, errors.Wrapf(err, "error decoding plan from %s", path)
+	}
+
+	return plan, nil
+}
+
+func instanceIDs(instances []*tcc.Instance) []string {
+	ids := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		ids = append(ids, instance.ID)
+	}
+	return ids
+}
+
+func lookupMembers(instances []*tcc.Instance, ids []string) []*tcc.Instance {
+	byID := make(map[string]*tcc.Instance, len(instances))
+	for _, instance := range instances {
+		byID[instance.ID] = instance
+	}
+
+	found := make([]*tcc.Instance, 0, len(ids))
+	for _, id := range ids {
+		if instance, ok := byID[id]; ok {
+			found = append(found, instance)
+		}
+	}
+	return found
+}
+
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	seen := make(map[string]bool, len(a))
+	for _, id := range a {
+		seen[id] = true
+	}
+	for _, id := range b {
+		if !seen[id] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
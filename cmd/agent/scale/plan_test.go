@@ -0,0 +1,101 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestPlanRedacted(t *testing.T) {
+	p := &Plan{
+		Create: 1,
+		CreateInput: &tcc.CreateInstanceInput{
+			Metadata: map[string]string{"user-data": "#!/bin/sh\nexport TOKEN=secret"},
+		},
+	}
+
+	redacted := p.Redacted()
+	if got := redacted.CreateInput.Metadata["user-data"]; got != redactedValue {
+		t.Errorf("redacted user-data = %q, want %q", got, redactedValue)
+	}
+	if got := p.CreateInput.Metadata["user-data"]; got == redactedValue {
+		t.Errorf("Redacted modified the original plan")
+	}
+}
+
+func TestApplyRechecksCooldown(t *testing.T) {
+	tests := []struct {
+		name      string
+		scaledOut bool
+		scaledIn  bool
+		create    int
+		delete    bool
+		wantCount int
+	}{
+		{name: "scale-out", create: 1, wantCount: 3},
+		{name: "scale-out in cooldown", scaledOut: true, create: 1, wantCount: 2},
+		{name: "scale-in", delete: true, wantCount: 1},
+		{name: "scale-in in cooldown", scaledIn: true, delete: true, wantCount: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			members := api.addMembers("web", 2)
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyInstanceReadyPoll, 10*time.Millisecond)
+			viper.Set(iconfig.KeyScaleOutCooldown, 10*time.Minute)
+			viper.Set(iconfig.KeyScaleInCooldown, 10*time.Minute)
+
+			// The group scaled after the plan was saved.
+			state := &groupState{}
+			if tt.scaledOut {
+				state.LastScaleOut = time.Now()
+			}
+			if tt.scaledIn {
+				state.LastScaleIn = time.Now()
+			}
+			if err := state.save(testAccount, "web"); err != nil {
+				t.Fatal(err)
+			}
+
+			plan := &Plan{
+				Version:     planFormatVersion,
+				AccountName: testAccount,
+				TsgName:     "web",
+				TemplateID:  "template",
+				Members:     instanceIDs(members),
+			}
+			if tt.create > 0 {
+				plan.Create = tt.create
+				plan.CreateInput = &tcc.CreateInstanceInput{Tags: map[string]string{"tsg.name": "web"}}
+			}
+			if tt.delete {
+				plan.Delete = []string{members[0].ID}
+			}
+
+			if err := c.Apply(context.Background(), plan); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := api.count(); got != tt.wantCount {
+				t.Errorf("group has %d instances, want %d", got, tt.wantCount)
+			}
+			if deferred := tt.scaledOut || tt.scaledIn; (len(plan.Deferred) > 0) != deferred {
+				t.Errorf("deferred = %q, want deferred %v", plan.Deferred, deferred)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tcc "github.com/joyent/triton-go/compute"
+)
+
+const (
+	PolicyOldestFirst         = "oldest-first"
+	PolicyNewestFirst         = "newest-first"
+	PolicyNonRunningFirst     = "non-running-first"
+	PolicyMostCrowdedNode     = "most-crowded-node-first"
+	PolicyOldestTemplateFirst = "oldest-template-first"
+
+	DefaultTerminationPolicy = PolicyOldestFirst
+)
+
+// instanceComparator returns a negative number when a should be terminated
+// before b, a positive number when b should be terminated first and zero
+// when the policy has no preference.
+type instanceComparator func(a, b *tcc.Instance) int
+
+// terminationPolicy builds a comparator for the given set of group members.
+// Policies that need a view of the whole group (e.g. compute node density)
+// compute it once here rather than on every comparison.
+type terminationPolicy func(members []*tcc.Instance) instanceComparator
+
+var terminationPolicies = map[string]terminationPolicy{
+	PolicyOldestFirst:         oldestFirst,
+	PolicyNewestFirst:         newestFirst,
+	PolicyNonRunningFirst:     nonRunningFirst,
+	PolicyMostCrowdedNode:     mostCrowdedNodeFirst,
+	PolicyOldestTemplateFirst: oldestTemplateFirst,
+}
+
+// TerminationPolicyNames returns the names of all built-in termination
+// policies in a stable order.
+func TerminationPolicyNames() []string {
+	names := make([]string, 0, len(terminationPolicies))
+	for name := range terminationPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateTerminationPolicies returns an error naming the first policy that
+// is not a built-in termination policy.
+func ValidateTerminationPolicies(names []string) error {
+	for _, name := range names {
+		if _, ok := terminationPolicies[name]; !ok {
+			return fmt.Errorf("unknown termination policy %q (valid policies: %s)",
+				name, strings.Join(TerminationPolicyNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// selectForTermination returns count members ordered by the named policies.
+// Each policy is only consulted when all of the policies before it consider
+// two members equal, so policies compose as ordered tie-breakers.
+func selectForTermination(members []*tcc.Instance, count int, names []string) ([]*tcc.Instance, error) {
+	if err := ValidateTerminationPolicies(names); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		names = []string{DefaultTerminationPolicy}
+	}
+
+	comparators := make([]instanceComparator, 0, len(names))
+	for _, name := range names {
+		comparators = append(comparators, terminationPolicies[name](members))
+	}
+
+	candidates := make([]*tcc.Instance, len(members))
+	copy(candidates, members)
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		for _, compare := range comparators {
+			if c := compare(candidates[i], candidates[j]); c != 0 {
+				return c < 0
+			}
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	if count > len(candidates) {
+		count = len(candidates)
+	}
+
+	return candidates[:count], nil
+}
+
+func oldestFirst(_ []*tcc.Instance) instanceComparator {
+	return func(a, b *tcc.Instance) int {
+		return compareTimes(a.Created.Unix(), b.Created.Unix())
+	}
+}
+
+func newestFirst(_ []*tcc.Instance) instanceComparator {
+	return func(a, b *tcc.Instance) int {
+		return compareTimes(b.Created.Unix(), a.Created.Unix())
+	}
+}
+
+func nonRunningFirst(_ []*tcc.Instance) instanceComparator {
+	return func(a, b *tcc.Instance) int {
+		aRunning := a.State == "running"
+		bRunning := b.State == "running"
+		switch {
+		case aRunning == bRunning:
+			return 0
+		case bRunning:
+			return -1
+		default:
+			return 1
+		}
+	}
+}
+
+func mostCrowdedNodeFirst(members []*tcc.Instance) instanceComparator {
+	crowding := make(map[string]int, len(members))
+	for _, instance := range members {
+		crowding[instance.ComputeNode]++
+	}
+
+	return func(a, b *tcc.Instance) int {
+		return crowding[b.ComputeNode] - crowding[a.ComputeNode]
+	}
+}
+
+// oldestTemplateFirst prefers members whose tsg.template tag is older. A
+// template's age is taken from the earliest creation time of any member
+// launched from it, since CloudAPI has no record of the template itself.
+func oldestTemplateFirst(members []*tcc.Instance) instanceComparator {
+	firstSeen := make(map[string]int64, len(members))
+	for _, instance := range members {
+		template := instanceTemplateID(instance)
+		created := instance.Created.Unix()
+		if seen, ok := firstSeen[template]; !ok || created < seen {
+			firstSeen[template] = created
+		}
+	}
+
+	return func(a, b *tcc.Instance) int {
+		return compareTimes(firstSeen[instanceTemplateID(a)], firstSeen[instanceTemplateID(b)])
+	}
+}
+
+func instanceTemplateID(instance *tcc.Instance) string {
+	if v, ok := instance.Tags["tsg.template"]; ok {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+func compareTimes(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,81 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+)
+
+func TestSelectForTermination(t *testing.T) {
+	base := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	member := func(id string, age int, state, node, template string) *tcc.Instance {
+		return &tcc.Instance{
+			ID:          id,
+			State:       state,
+			ComputeNode: node,
+			Created:     base.Add(time.Duration(age) * time.Hour),
+			Tags:        map[string]interface{}{"tsg.template": template},
+		}
+	}
+
+	// a and b were launched from the old template v1, which was first seen
+	// when a was created. c, d and e share compute node n1, and d and e
+	// were created at the same time.
+	members := []*tcc.Instance{
+		member("c", 2, StateRunning, "n1", "v2"),
+		member("a", 0, StateRunning, "n2", "v1"),
+		member("e", 3, StateRunning, "n1", "v2"),
+		member("b", 4, "stopped", "n3", "v1"),
+		member("d", 3, StateRunning, "n1", "v2"),
+	}
+
+	tests := []struct {
+		name     string
+		policies []string
+		count    int
+		want     []string
+	}{
+		{name: "default", count: 5, want: []string{"a", "c", "d", "e", "b"}},
+		{name: "oldest first", policies: []string{PolicyOldestFirst}, count: 5, want: []string{"a", "c", "d", "e", "b"}},
+		{name: "newest first", policies: []string{PolicyNewestFirst}, count: 5, want: []string{"b", "d", "e", "c", "a"}},
+		{name: "non-running first", policies: []string{PolicyNonRunningFirst}, count: 5, want: []string{"b", "a", "c", "d", "e"}},
+		{name: "most crowded node first", policies: []string{PolicyMostCrowdedNode}, count: 5, want: []string{"c", "d", "e", "a", "b"}},
+		{name: "oldest template first", policies: []string{PolicyOldestTemplateFirst}, count: 5, want: []string{"a", "b", "c", "d", "e"}},
+		{name: "crowded then newest", policies: []string{PolicyMostCrowdedNode, PolicyNewestFirst}, count: 5, want: []string{"d", "e", "c", "b", "a"}},
+		{name: "template then newest", policies: []string{PolicyOldestTemplateFirst, PolicyNewestFirst}, count: 5, want: []string{"b", "a", "d", "e", "c"}},
+		{name: "non-running then crowded then oldest", policies: []string{PolicyNonRunningFirst, PolicyMostCrowdedNode, PolicyOldestFirst}, count: 5, want: []string{"b", "c", "d", "e", "a"}},
+		{name: "count", policies: []string{PolicyNewestFirst}, count: 2, want: []string{"b", "d"}},
+		{name: "count above members", policies: []string{PolicyOldestFirst}, count: 9, want: []string{"a", "c", "d", "e", "b"}},
+		{name: "none", count: 0, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectForTermination(members, tt.count, tt.policies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := instanceIDs(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("selection order = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	if ids := instanceIDs(members); !reflect.DeepEqual(ids, []string{"c", "a", "e", "b", "d"}) {
+		t.Errorf("selectForTermination reordered the members it was given: %v", ids)
+	}
+
+	if _, err := selectForTermination(members, 1, []string{PolicyOldestFirst, "random"}); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,106 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/rs/zerolog/log"
+)
+
+const protectedTag = "tsg.protected"
+
+// isProtected reports whether an instance must not be deleted when scaling
+// in, either because Triton deletion protection is enabled or because it
+// carries a tsg.protected=true tag.
+func isProtected(instance *tcc.Instance) bool {
+	if instance.DeletionProtection {
+		return true
+	}
+
+	switch v := instance.Tags[protectedTag].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+
+	return false
+}
+
+// splitProtected separates instances that may be deleted from those that
+// are protected, preserving order.
+func splitProtected(instances []*tcc.Instance) (deletable, protected []*tcc.Instance) {
+	for _, instance := range instances {
+		if isProtected(instance) {
+			protected = append(protected, instance)
+			continue
+		}
+		deletable = append(deletable, instance)
+	}
+	return deletable, protected
+}
+
+// ProtectInstance enables Triton deletion protection on an instance and tags
+// it with tsg.protected=true so that scale-in never selects it.
+func (c *AgentComputeClient) ProtectInstance(ctx context.Context, instanceID string) error {
+	err := c.instances().EnableDeletionProtection(ctx, &tcc.EnableDeletionProtectionInput{
+		InstanceID: instanceID,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.instances().AddTags(ctx, &tcc.AddTagsInput{
+		ID:   instanceID,
+		Tags: map[string]string{protectedTag: "true"},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("account_name", c.client.Client.AccountName).
+		Str("status", "successful").
+		Str("notification_type", "TSG_INSTANCE_PROTECT").
+		Str("description", fmt.Sprintf("Protecting instance %s", instanceID)).
+		Msgf("An instance was protected from scale-in")
+
+	return nil
+}
+
+// UnprotectInstance reverses ProtectInstance. An instance protected only
+// through Triton deletion protection has no tsg.protected tag to remove.
+func (c *AgentComputeClient) UnprotectInstance(ctx context.Context, instanceID string) error {
+	err := c.instances().DisableDeletionProtection(ctx, &tcc.DisableDeletionProtectionInput{
+		InstanceID: instanceID,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.instances().DeleteTag(ctx, &tcc.DeleteTagInput{
+		ID:  instanceID,
+		Key: protectedTag,
+	})
+	if err != nil && classifyError(err) != errorNotFound {
+		return err
+	}
+
+	log.Info().
+		Str("account_name", c.client.Client.AccountName).
+		Str("status", "successful").
+		Str("notification_type", "TSG_INSTANCE_UNPROTECT").
+		Str("description", fmt.Sprintf("Unprotecting instance %s", instanceID)).
+		Msgf("An instance is no longer protected from scale-in")
+
+	return nil
+}
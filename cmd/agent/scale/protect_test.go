@@ -0,0 +1,182 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestProtectedMembersAreNotDeleted(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup adds the group's members and returns those that must
+		// survive the reconcile.
+		setup     func(api *fakeCloudAPI) []string
+		count     int
+		warmPool  int
+		wantCount int
+	}{
+		{
+			name: "scale-in",
+			setup: func(api *fakeCloudAPI) []string {
+				members := api.addMembers("web", 3)
+				members[0].DeletionProtection = true
+				members[1].Tags[protectedTag] = "true"
+				return instanceIDs(members[:2])
+			},
+			count:     1,
+			wantCount: 2,
+		},
+		{
+			name: "expired warm member",
+			setup: func(api *fakeCloudAPI) []string {
+				members := api.addMembers("web", 2)
+				members[1].State = "stopped"
+				members[1].Tags[poolTag] = poolWarm
+				members[1].Tags[poolSinceTag] = strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+				members[1].Tags[protectedTag] = "true"
+				return []string{members[1].ID}
+			},
+			count:     1,
+			warmPool:  1,
+			wantCount: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			keep := tt.setup(api)
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyInstanceCount, tt.count)
+			viper.Set(iconfig.KeyWarmPoolSize, tt.warmPool)
+			viper.Set(iconfig.KeyWarmPoolMaxAge, time.Hour)
+
+			if err := c.MaintainInstanceCount(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, id := range keep {
+				if api.instance(id) == nil {
+					t.Errorf("protected instance %s was deleted", id)
+				}
+			}
+			if got := api.count(); got != tt.wantCount {
+				t.Errorf("group has %d instances, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestApplySkipsMembersProtectedSincePlanning(t *testing.T) {
+	api := newFakeCloudAPI()
+	members := api.addMembers("web", 2)
+	c := newTestClient(t, api, "web")
+
+	plan := &Plan{
+		Version:     planFormatVersion,
+		AccountName: testAccount,
+		TsgName:     "web",
+		Members:     instanceIDs(members),
+		Delete:      instanceIDs(members),
+	}
+	api.instance(members[0].ID).Tags[protectedTag] = "true"
+
+	if err := c.Apply(context.Background(), plan); err != nil {
+		t.Fatal(err)
+	}
+
+	if api.instance(members[0].ID) == nil {
+		t.Errorf("protected instance %s was deleted", members[0].ID)
+	}
+	if api.instance(members[1].ID) != nil {
+		t.Errorf("instance %s was not deleted", members[1].ID)
+	}
+}
+
+func TestProtectInstance(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares the member whose protection is changed, or is
+		// nil to change an instance that does not exist.
+		setup    func(member *tcc.Instance)
+		protect  bool
+		wantErr  bool
+		wantProt bool
+		wantTag  bool
+	}{
+		{name: "protect", setup: func(*tcc.Instance) {}, protect: true, wantProt: true, wantTag: true},
+		{
+			name: "protect again",
+			setup: func(member *tcc.Instance) {
+				member.DeletionProtection = true
+				member.Tags[protectedTag] = "true"
+			},
+			protect:  true,
+			wantProt: true,
+			wantTag:  true,
+		},
+		{
+			name: "unprotect",
+			setup: func(member *tcc.Instance) {
+				member.DeletionProtection = true
+				member.Tags[protectedTag] = "true"
+			},
+		},
+		{name: "unprotect deletion protection only", setup: func(member *tcc.Instance) { member.DeletionProtection = true }},
+		{name: "unprotect tag only", setup: func(member *tcc.Instance) { member.Tags[protectedTag] = "true" }},
+		{name: "unprotect unprotected", setup: func(*tcc.Instance) {}},
+		{name: "unknown instance", setup: nil, protect: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			member := api.addMembers("web", 1)[0]
+			id := member.ID
+			if tt.setup == nil {
+				id = "00000000-0000-0000-0000-000000000000"
+			} else {
+				tt.setup(member)
+			}
+			c := newTestClient(t, api, "web")
+
+			var err error
+			if tt.protect {
+				err = c.ProtectInstance(context.Background(), id)
+			} else {
+				err = c.UnprotectInstance(context.Background(), id)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			instance := api.instance(id)
+			if instance.DeletionProtection != tt.wantProt {
+				t.Errorf("DeletionProtection = %v, want %v", instance.DeletionProtection, tt.wantProt)
+			}
+			if _, ok := instance.Tags[protectedTag]; ok != tt.wantTag {
+				t.Errorf("tags = %v, want %s tag %v", instance.Tags, protectedTag, tt.wantTag)
+			}
+			if isProtected(instance) != tt.protect {
+				t.Errorf("isProtected = %v, want %v", isProtected(instance), tt.protect)
+			}
+		})
+	}
+}
@@ -12,121 +12,329 @@ import (
 	"context"
 	"fmt"
 	"sort"
-
-	"time"
+	"sync"
 
 	"github.com/imdario/mergo"
+	"github.com/joyent/triton-go"
 	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/agent/health"
 	"github.com/joyent/tsg-cli/cmd/config"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
 type AgentComputeClient struct {
-	client *tcc.ComputeClient
+	client       *tcc.ComputeClient
+	cfg          *triton.ClientConfig
+	desiredCount DesiredCountFunc
+	health       *health.Check
+	ready        *readyWaiter
+
+	// primary is the configured data center. A group spread across several
+	// data centers has them in datacenters, and each member's data center
+	// is recorded in instanceDC when it is listed or created.
+	primary     *Datacenter
+	mu          sync.Mutex
+	datacenters []*Datacenter
+	instanceDC  map[string]*Datacenter
 }
 
+// DesiredCountFunc computes the expected instance count of a group from the
+// number of members currently counted toward its size.
+type DesiredCountFunc func(ctx context.Context, current int) (int, error)
+
 func NewComputeClient(cfg *config.TritonClientConfig) (*AgentComputeClient, error) {
 	computeClient, err := tcc.NewClient(cfg.Config)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error Creating Triton Compute Client")
 	}
-	return &AgentComputeClient{
-		client: computeClient,
-	}, nil
+	c := &AgentComputeClient{
+		client:     computeClient,
+		cfg:        cfg.Config,
+		primary:    &Datacenter{Weight: 1, client: computeClient},
+		instanceDC: make(map[string]*Datacenter),
+	}
+	c.ready = newReadyWaiter(c)
+
+	return c, nil
+}
+
+// SetDesiredCountFunc makes the client compute the expected instance count
+// with fn instead of using the configured count.
+func (c *AgentComputeClient) SetDesiredCountFunc(fn DesiredCountFunc) {
+	c.desiredCount = fn
+}
+
+// SetHealthCheck makes the client wait for launched instances to pass check
+// and replace members that fail it.
+func (c *AgentComputeClient) SetHealthCheck(check *health.Check) {
+	c.health = check
 }
 
-func (c *AgentComputeClient) MaintainInstanceCount() error {
-	instances, err := c.GetInstanceList()
+func (c *AgentComputeClient) MaintainInstanceCount(ctx context.Context) error {
+	instances, err := c.GetInstanceList(ctx)
 	if err != nil {
 		return err
 	}
 
-	runningInstances := len(instances)
-	expectedInstances := config.GetExpectedMachineCount()
-	scaleCount := expectedInstances - runningInstances
-
-	if scaleCount < 0 {
-		instancesToRemove := runningInstances - expectedInstances
-		for i := 1; i < instancesToRemove+1; i++ {
-			instance := instances[len(instances)-1]
-
-			err := c.DeleteInstance(instance.ID)
-			if err != nil {
-				log.Error().
-					Str("account_name", c.client.Client.AccountName).
-					Str("tsg_name", config.GetTsgName()).
-					Str("status", "failed").
-					Str("notification_type", "TSG_INSTANCE_TERMINATE_ERROR").
-					Str("description", fmt.Sprintf("Error deleting instance %s", instance.ID)).
-					Err(err)
-				return err
-			}
-
-			log.Log().
-				Str("account_name", c.client.Client.AccountName).
-				Str("tsg_name", config.GetTsgName()).
-				Str("status", "successful").
-				Str("notification_type", "TSG_INSTANCE_TERMINATE").
-				Str("description", fmt.Sprintf("Terminating instance %s", instance.ID)).
-				Msgf("An instance was deleted due to a difference between the expected and actual instance count")
-
-			instances = instances[:len(instances)-1]
-		}
+	plan, err := c.newPlan(ctx, instances)
+	if err != nil {
+		return err
+	}
 
-	} else if scaleCount > 0 {
-		for i := 0; i < scaleCount; i++ {
-
-			templateID := config.GetTsgTemplateID()
-
-			instance, err := c.CreateInstance(templateID)
-			if err != nil {
-				log.Error().
-					Str("account_name", c.client.Client.AccountName).
-					Str("tsg_name", config.GetTsgName()).
-					Str("status", "failed").
-					Str("notification_type", "TSG_INSTANCE_LAUNCH_ERROR").
-					Str("description", "Error launching new instance").
-					Err(err)
-				return err
-			}
-
-			err = c.TagInstance(instance.ID, templateID)
-			if err != nil {
-				log.Error().
-					Str("account_name", c.client.Client.AccountName).
-					Str("tsg_name", config.GetTsgName()).
-					Str("status", "failed").
-					Str("notification_type", "TSG_INSTANCE_LAUNCH_ERROR").
-					Str("description", "Error launching new instance").
-					Err(err)
-				return err
-			}
-
-			log.Info().
-				Str("account_name", c.client.Client.AccountName).
-				Str("tsg_name", config.GetTsgName()).
-				Str("status", "successful").
-				Str("notification_type", "TSG_INSTANCE_LAUNCH").
-				Str("description", fmt.Sprintf("Launching new instance %s", instance.ID)).
-				Msgf("An instance was created due to a difference between the expected and actual instance count")
-
-			instances = append(instances, instance)
-		}
-	} else {
+	if err := applyCooldown(plan); err != nil {
+		return err
+	}
+
+	return c.execute(ctx, plan, instances)
+}
+
+func (c *AgentComputeClient) execute(ctx context.Context, plan *Plan, instances []*tcc.Instance) error {
+	if plan.Shortfall > 0 {
+		log.Warn().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "failed").
+			Str("notification_type", "TSG_INSTANCE_PROTECTED").
+			Str("description", fmt.Sprintf("Expected %d instances in TSG: %q but %d protected instance(s) can not be deleted", plan.ExpectedCount, plan.TsgName, plan.Shortfall)).
+			Msgf("The expected instance count can not be reached because of protected instances")
+	}
+
+	if len(plan.Failed) > 0 {
+		log.Warn().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "failed").
+			Str("notification_type", "TSG_INSTANCE_FAILED").
+			Strs("instance_ids", plan.Failed).
+			Str("description", fmt.Sprintf("%d instance(s) in TSG: %q were kept after a failed launch and are tagged %s=%s", len(plan.Failed), plan.TsgName, statusTag, statusFailed)).
+			Msgf("Instances kept after a failed launch must be deleted by hand")
+	}
+
+	for _, reason := range plan.Deferred {
 		log.Info().
-			Str("account_name", c.client.Client.AccountName).
-			Str("tsg_name", config.GetTsgName()).
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "deferred").
+			Str("notification_type", "TSG_INSTANCE_COOLDOWN").
+			Str("description", reason).
+			Msgf("A scaling action was deferred by a cooldown")
+	}
+
+	if !plan.HasChanges() && len(plan.Deferred) > 0 {
+		return nil
+	}
+
+	if !plan.HasChanges() {
+		log.Info().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
 			Str("status", "successful").
 			Str("notification_type", "TSG_INSTANCE_NO_OP").
-			Str("description", fmt.Sprintf("Expected %d instances in TSG: %q - found %d instances", expectedInstances, config.GetTsgName(), runningInstances)).
+			Str("description", fmt.Sprintf("Expected %d instances in TSG: %q - found %d instances", plan.ExpectedCount, plan.TsgName, len(plan.Members))).
+			Int("ready", plan.ReadyCount()).
+			Int("provisioning", len(plan.Provisioning)).
+			Int("terminating", len(plan.Terminating)).
+			Int("unhealthy", len(plan.Unhealthy)).
 			Msgf("TSG is healthy")
+		return nil
+	}
+
+	log.Info().
+		Str("account_name", plan.AccountName).
+		Str("tsg_name", plan.TsgName).
+		Str("status", "in_progress").
+		Str("notification_type", "TSG_RECONCILE").
+		Str("description", fmt.Sprintf("Expected %d instances in TSG: %q - found %d instances", plan.ExpectedCount, plan.TsgName, len(plan.Members))).
+		Int("ready", plan.ReadyCount()).
+		Int("provisioning", len(plan.Provisioning)).
+		Int("terminating", len(plan.Terminating)).
+		Int("unhealthy", len(plan.Unhealthy)).
+		Int("create", plan.Create).
+		Int("delete", len(plan.Delete)).
+		Msgf("Reconciling TSG")
+
+	for _, instance := range lookupMembers(instances, plan.Unhealthy) {
+		log.Warn().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "unhealthy").
+			Str("notification_type", "TSG_INSTANCE_UNHEALTHY").
+			Str("description", fmt.Sprintf("Instance %s is in state %q", instance.ID, instance.State)).
+			Msgf("An unhealthy instance will be replaced")
+	}
+
+	if len(plan.Stop) > 0 {
+		candidates, err := plan.members(instances, plan.Stop)
+		if err != nil {
+			return err
+		}
+
+		if err := c.stopToPool(ctx, plan, candidates); err != nil {
+			return err
+		}
+	}
+
+	if len(plan.Delete) > 0 {
+		candidates, err := plan.members(instances, plan.Delete)
+		if err != nil {
+			return err
+		}
+
+		if err := c.scaleIn(ctx, plan, c.skipProtected(plan, candidates)); err != nil {
+			return err
+		}
+	}
+
+	if len(plan.Start) > 0 {
+		warm, err := plan.members(instances, plan.Start)
+		if err != nil {
+			return err
+		}
+
+		if err := c.startFromPool(ctx, plan, warm); err != nil {
+			return err
+		}
+	}
+
+	if plan.Create > 0 {
+		if err := c.scaleOut(ctx, plan); err != nil {
+			return err
+		}
+	}
+
+	if len(plan.Unhealthy) > 0 {
+		unhealthy, err := plan.members(instances, plan.Unhealthy)
+		if err != nil {
+			return err
+		}
+
+		if err := c.scaleIn(ctx, plan, c.skipProtected(plan, unhealthy)); err != nil {
+			return err
+		}
+	}
+
+	if len(plan.Expire) > 0 {
+		expired, err := plan.members(instances, plan.Expire)
+		if err != nil {
+			return err
+		}
+
+		if err := c.scaleIn(ctx, plan, c.skipProtected(plan, expired)); err != nil {
+			return err
+		}
+	}
+
+	if err := recordScaling(plan); err != nil {
+		log.Warn().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Err(err).
+			Msg("unable to record when the TSG scaled, cooldowns will not apply to the next run")
 	}
 
 	return nil
 }
 
-func (c *AgentComputeClient) TagInstance(instanceID string, templateID string) error {
+// skipProtected drops protected instances from a list of planned deletions.
+// Instances are checked again here because a saved plan may be applied
+// after an instance was protected.
+func (c *AgentComputeClient) skipProtected(plan *Plan, instances []*tcc.Instance) []*tcc.Instance {
+	deletable, protected := splitProtected(instances)
+	for _, instance := range protected {
+		log.Warn().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "skipped").
+			Str("notification_type", "TSG_INSTANCE_PROTECTED").
+			Str("description", fmt.Sprintf("Instance %s is protected and will not be deleted", instance.ID)).
+			Msgf("A protected instance was not deleted")
+	}
+	return deletable
+}
+
+func (c *AgentComputeClient) scaleIn(ctx context.Context, plan *Plan, instances []*tcc.Instance) error {
+	results := forEachParallel(ctx, len(instances), config.GetScaleParallelism(), func(i int) *actionResult {
+		instance := instances[i]
+
+		err := runHook(ctx, plan, HookPreTerminate, "delete", instance)
+		if err == nil {
+			err = c.DeleteInstance(ctx, instance.ID)
+		}
+		if err != nil {
+			log.Error().
+				Str("account_name", plan.AccountName).
+				Str("tsg_name", plan.TsgName).
+				Str("status", "failed").
+				Str("notification_type", "TSG_INSTANCE_TERMINATE_ERROR").
+				Str("description", fmt.Sprintf("Error deleting instance %s", instance.ID)).
+				Err(err).
+				Msg("An instance could not be deleted")
+			return &actionResult{Instance: instance, Err: err}
+		}
+
+		log.Log().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "successful").
+			Str("notification_type", "TSG_INSTANCE_TERMINATE").
+			Str("description", fmt.Sprintf("Terminating instance %s", instance.ID)).
+			Msgf("An instance was deleted due to a difference between the expected and actual instance count")
+
+		if err := runHook(ctx, plan, HookPostTerminate, "delete", instance); err != nil {
+			return &actionResult{Instance: instance, Err: err}
+		}
+
+		return &actionResult{Instance: instance}
+	})
+
+	return summarizeResults(ctx, plan, "delete", results)
+}
+
+func (c *AgentComputeClient) scaleOut(ctx context.Context, plan *Plan) error {
+	dcs := c.launchDatacenters(plan)
+
+	results := forEachParallel(ctx, plan.Create, config.GetScaleParallelism(), func(i int) *actionResult {
+		var instance *tcc.Instance
+		err := runHook(ctx, plan, HookPreLaunch, "launch", nil)
+		if err == nil {
+			instance, err = c.launchIn(ctx, dcs[i], plan.CreateInput)
+		}
+		if err == nil {
+			err = c.TagInstance(ctx, instance.ID, plan.TemplateID)
+		}
+		if err == nil {
+			err = runHook(ctx, plan, HookPostLaunch, "launch", instance)
+		}
+		if err != nil {
+			log.Error().
+				Str("account_name", plan.AccountName).
+				Str("tsg_name", plan.TsgName).
+				Str("status", "failed").
+				Str("notification_type", "TSG_INSTANCE_LAUNCH_ERROR").
+				Str("description", "Error launching new instance").
+				Err(err).
+				Msg("An instance could not be launched")
+			return &actionResult{Instance: instance, Err: err}
+		}
+
+		log.Info().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "successful").
+			Str("notification_type", "TSG_INSTANCE_LAUNCH").
+			Str("description", fmt.Sprintf("Launching new instance %s", instance.ID)).
+			Msgf("An instance was created due to a difference between the expected and actual instance count")
+
+		return &actionResult{Instance: instance}
+	})
+
+	c.cleanupFailedLaunches(plan, results)
+
+	return summarizeResults(ctx, plan, "launch", results)
+}
+
+func (c *AgentComputeClient) TagInstance(ctx context.Context, instanceID string, templateID string) error {
 	params := &tcc.AddTagsInput{
 		ID: instanceID,
 	}
@@ -136,7 +344,7 @@ func (c *AgentComputeClient) TagInstance(instanceID string, templateID string) e
 
 	params.Tags = t
 
-	err := c.client.Instances().AddTags(context.Background(), params)
+	err := c.instances().AddTags(ctx, params)
 	if err != nil {
 		return err
 	}
@@ -148,34 +356,52 @@ func formulateInstanceNameTag(templateID string, instanceID string) string {
 	return fmt.Sprintf("tsg-%s-%s", templateID[:8], instanceID[:8])
 }
 
-func (c *AgentComputeClient) GetInstanceList() ([]*tcc.Instance, error) {
-	params := &tcc.ListInstancesInput{}
+func (c *AgentComputeClient) GetInstanceList(ctx context.Context) ([]*tcc.Instance, error) {
+	return c.listGroupInstances(ctx, config.GetTsgName())
+}
+
+// GetReadyInstanceIDs returns the IDs of the members of the group that are
+// in a healthy state, excluding the warm pool.
+func (c *AgentComputeClient) GetReadyInstanceIDs(ctx context.Context) ([]string, error) {
+	instances, err := c.GetInstanceList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return instanceIDs(classifyMembers(instances).ready), nil
+}
 
+func (c *AgentComputeClient) listGroupInstances(ctx context.Context, tsgName string) ([]*tcc.Instance, error) {
 	t := make(map[string]interface{}, 0)
 
-	tsgName := config.GetTsgName()
 	if tsgName != "" {
 		t["tsg.name"] = tsgName
 	}
 
-	params.Tags = t
-
-	instances, err := c.client.Instances().List(context.Background(), params)
+	instances, err := c.listAllInstances(ctx, t)
 	if err != nil {
 		return nil, err
 	}
 
 	return sortInstances(instances), nil
-
 }
 
-func (c *AgentComputeClient) DeleteInstance(instanceID string) error {
-	return c.client.Instances().Delete(context.Background(), &tcc.DeleteInstanceInput{
+func (c *AgentComputeClient) DeleteInstance(ctx context.Context, instanceID string) error {
+	return c.instances().Delete(ctx, &tcc.DeleteInstanceInput{
 		ID: instanceID,
 	})
 }
 
-func (c *AgentComputeClient) CreateInstance(templateID string) (*tcc.Instance, error) {
+func (c *AgentComputeClient) CreateInstance(ctx context.Context, templateID string) (*tcc.Instance, error) {
+	params, err := BuildCreateInstanceInput(templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.LaunchInstance(ctx, params)
+}
+
+func BuildCreateInstanceInput(templateID string) (*tcc.CreateInstanceInput, error) {
 	params := &tcc.CreateInstanceInput{
 		FirewallEnabled: config.GetMachineFirewall(),
 	}
@@ -202,7 +428,15 @@ func (c *AgentComputeClient) CreateInstance(templateID string) (*tcc.Instance, e
 		params.Networks = networks
 	}
 
+	spread := config.GetInstanceSpread()
+	if err := ValidateSpread(spread); err != nil {
+		return nil, err
+	}
+
 	affinityRules := config.GetMachineAffinityRules()
+	if rule := spreadAffinityRule(spread, tsgName); rule != "" {
+		affinityRules = append(affinityRules, rule)
+	}
 	if len(affinityRules) > 0 {
 		params.Affinity = affinityRules
 	}
@@ -238,43 +472,28 @@ func (c *AgentComputeClient) CreateInstance(templateID string) (*tcc.Instance, e
 		params.Image = imgID
 	}
 
-	machine, err := c.client.Instances().Create(context.Background(), params)
+	return params, nil
+}
+
+// LaunchInstance creates an instance and waits for it to be running. If the
+// instance is created but does not become ready, it is returned along with
+// the error so that the caller can clean it up.
+func (c *AgentComputeClient) LaunchInstance(ctx context.Context, params *tcc.CreateInstanceInput) (*tcc.Instance, error) {
+	return c.launchIn(ctx, c.primary, params)
+}
+
+func (c *AgentComputeClient) launchIn(ctx context.Context, dc *Datacenter, params *tcc.CreateInstanceInput) (*tcc.Instance, error) {
+	machine, err := c.instancesIn(dc).Create(ctx, params)
 	if err != nil {
 		return nil, err
 	}
 
-	state := make(chan *tcc.Instance, 1)
-	stop := make(chan struct{}, 1)
-
-	go func() {
-		for {
-			select {
-			default:
-				time.Sleep(1 * time.Second)
-				instance, err := c.client.Instances().Get(context.Background(), &tcc.GetInstanceInput{
-					ID: machine.ID,
-				})
-				if err != nil {
-					log.Info().Msgf("error getting instance %q", instance.ID)
-				}
-				if instance.State == "running" {
-					state <- instance
-					return
-				}
-			case <-stop:
-				return
-			}
-		}
-	}()
-
-	select {
-	case <-state:
-	case <-time.After(5 * time.Minute):
-		close(stop)
-		return nil, fmt.Errorf("timed out waiting for %q to become ready", machine.ID)
+	running, err := c.waitReady(ctx, machine.ID)
+	if err != nil {
+		return machine, err
 	}
 
-	return machine, nil
+	return running, nil
 }
 
 type instanceSort []*tcc.Instance
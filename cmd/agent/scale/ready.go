@@ -0,0 +1,228 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	tritonerrors "github.com/joyent/triton-go/errors"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// readyPollCallTimeout bounds each round of CloudAPI calls made by the
+	// readiness poll, which serves every waiter and so can not use any one
+	// waiter's context.
+	readyPollCallTimeout = 30 * time.Second
+
+	// readyPollParallelism bounds how many instances the poll looks up at
+	// once.
+	readyPollParallelism = 10
+)
+
+// ProvisioningError is returned while waiting for an instance to become
+// ready when it instead fails to provision or is deleted.
+type ProvisioningError struct {
+	InstanceID string
+	State      string
+}
+
+func (e *ProvisioningError) Error() string {
+	if e.State == "deleted" {
+		return fmt.Sprintf("instance %q was deleted before it became ready", e.InstanceID)
+	}
+	return fmt.Sprintf("instance %q failed to provision (state %q)", e.InstanceID, e.State)
+}
+
+// waitReady waits for an instance to be running and, if a health check is
+// configured, to pass it, and returns the running instance. Each of the two
+// waits is bounded by the ready timeout.
+func (c *AgentComputeClient) waitReady(ctx context.Context, instanceID string) (*tcc.Instance, error) {
+	instance, err := c.ready.Wait(ctx, instanceID)
+	if err != nil || c.health == nil {
+		return instance, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.GetReadyTimeout())
+	defer cancel()
+
+	if err := c.health.WaitHealthy(ctx, instance.PrimaryIP); err != nil {
+		return nil, errors.Wrapf(err, "instance %q is running but not healthy", instanceID)
+	}
+
+	return instance, nil
+}
+
+// readyResult is what a waiter receives once an instance's state is final.
+type readyResult struct {
+	instance *tcc.Instance
+	err      error
+}
+
+// readyWaiter waits for instances to reach the running state. All the
+// instances waited on at the same time are checked by a single poll, rather
+// than by a poll per waiter.
+type readyWaiter struct {
+	client *AgentComputeClient
+
+	mu      sync.Mutex
+	pending map[string][]chan readyResult
+	polling bool
+}
+
+func newReadyWaiter(c *AgentComputeClient) *readyWaiter {
+	return &readyWaiter{
+		client:  c,
+		pending: make(map[string][]chan readyResult),
+	}
+}
+
+// Wait blocks until instanceID is running, has failed to provision, the
+// configured ready timeout has passed or ctx is done. It returns the running
+// instance.
+func (w *readyWaiter) Wait(ctx context.Context, instanceID string) (*tcc.Instance, error) {
+	ch := w.add(instanceID)
+	defer w.remove(instanceID, ch)
+
+	timer := time.NewTimer(config.GetReadyTimeout())
+	defer timer.Stop()
+
+	select {
+	case r := <-ch:
+		return r.instance, r.err
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "stopped waiting for %q to become ready", instanceID)
+	case <-timer.C:
+		return nil, fmt.Errorf("timed out waiting for %q to become ready", instanceID)
+	}
+}
+
+func (w *readyWaiter) add(instanceID string) chan readyResult {
+	ch := make(chan readyResult, 1)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending[instanceID] = append(w.pending[instanceID], ch)
+	if !w.polling {
+		w.polling = true
+		go w.poll()
+	}
+
+	return ch
+}
+
+func (w *readyWaiter) remove(instanceID string, ch chan readyResult) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	chans := w.pending[instanceID]
+	for i := range chans {
+		if chans[i] == ch {
+			chans = append(chans[:i], chans[i+1:]...)
+			break
+		}
+	}
+	if len(chans) == 0 {
+		delete(w.pending, instanceID)
+	} else {
+		w.pending[instanceID] = chans
+	}
+}
+
+// resolve delivers the outcome to everyone waiting on instanceID.
+func (w *readyWaiter) resolve(instanceID string, instance *tcc.Instance, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, ch := range w.pending[instanceID] {
+		ch <- readyResult{instance: instance, err: err}
+	}
+	delete(w.pending, instanceID)
+}
+
+// waiting returns the instances still waited on, or stops the poll and
+// returns nil if there are none.
+func (w *readyWaiter) waiting() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) == 0 {
+		w.polling = false
+		return nil
+	}
+
+	ids := make([]string, 0, len(w.pending))
+	for id := range w.pending {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (w *readyWaiter) poll() {
+	ticker := time.NewTicker(config.GetReadyPollInterval())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ids := w.waiting()
+		if ids == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), readyPollCallTimeout)
+		w.check(ctx, ids)
+		cancel()
+	}
+}
+
+// check looks up every pending instance and resolves those whose state is
+// final. Only the instances waited on are looked up, so the poll costs the
+// same however large the group is.
+func (w *readyWaiter) check(ctx context.Context, ids []string) {
+	forEachParallel(ctx, len(ids), readyPollParallelism, func(i int) *actionResult {
+		w.checkInstance(ctx, ids[i])
+		return nil
+	})
+}
+
+func (w *readyWaiter) checkInstance(ctx context.Context, id string) {
+	instance, err := w.client.instances().Get(ctx, &tcc.GetInstanceInput{
+		ID: id,
+	})
+	if isGone(err) {
+		w.resolve(id, nil, &ProvisioningError{InstanceID: id, State: "deleted"})
+		return
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("error getting instance %q", id)
+		return
+	}
+
+	switch instance.State {
+	case "running":
+		w.resolve(id, instance, nil)
+	case "failed", "deleted":
+		w.resolve(id, nil, &ProvisioningError{InstanceID: id, State: instance.State})
+	}
+}
+
+// isGone reports whether a Get failed because the instance no longer
+// exists. CloudAPI answers 410 Gone for deleted instances it still knows
+// about and 404 for those it does not.
+func isGone(err error) bool {
+	return err != nil && (classifyError(err) == errorNotFound ||
+		tritonerrors.IsSpecificStatusCode(err, http.StatusGone))
+}
@@ -0,0 +1,101 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+// provisionAfter makes id running once it has been looked up polls times,
+// or failed if fail is set.
+func (f *fakeCloudAPI) provisionAfter(id string, polls int, fail bool) {
+	var seen int
+	f.intercept = func(r *http.Request) int {
+		if r.Method != http.MethodGet || r.URL.Path != "/acct/machines/"+id {
+			return 0
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if seen++; seen >= polls {
+			f.instances[id].State = StateRunning
+			if fail {
+				f.instances[id].State = "failed"
+			}
+		}
+		return 0
+	}
+}
+
+func TestReadyWaiterPollsPendingInstances(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    bool
+		wantErr bool
+	}{
+		{name: "running"},
+		{name: "failed", fail: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			api.addMembers("web", 50)
+			pending := api.addMembers("web", 1)[0]
+			api.instance(pending.ID).State = StateProvisioning
+			api.provisionAfter(pending.ID, 3, tt.fail)
+
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyInstanceReadyPoll, 10*time.Millisecond)
+			viper.Set(iconfig.KeyInstanceReadyTimeout, 5*time.Second)
+			// A saved plan is applied without a group name configured.
+			viper.Set(iconfig.KeyTsgGroupName, "")
+
+			instance, err := c.ready.Wait(context.Background(), pending.ID)
+			if tt.wantErr {
+				if _, ok := err.(*ProvisioningError); !ok {
+					t.Fatalf("Wait = %v, want a ProvisioningError", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if instance.ID != pending.ID || instance.State != StateRunning {
+					t.Errorf("Wait returned %s in state %q", instance.ID, instance.State)
+				}
+			}
+
+			lists := api.requestCount("GET", "/acct/machines") - api.requestCount("GET", "/acct/machines/")
+			if lists != 0 {
+				t.Errorf("listed the group %d times, want only the pending instance looked up", lists)
+			}
+			if got := api.requestCount("GET", "/acct/machines/"+pending.ID); got != 3 {
+				t.Errorf("looked up the pending instance %d times, want 3", got)
+			}
+		})
+	}
+}
+
+func TestReadyWaiterDeleted(t *testing.T) {
+	api := newFakeCloudAPI()
+	c := newTestClient(t, api, "web")
+	viper.Set(iconfig.KeyInstanceReadyPoll, 10*time.Millisecond)
+
+	_, err := c.ready.Wait(context.Background(), "00000000-0000-0000-0000-000000000099")
+	perr, ok := err.(*ProvisioningError)
+	if !ok || perr.State != "deleted" {
+		t.Fatalf("Wait = %v, want the instance reported deleted", err)
+	}
+}
@@ -0,0 +1,283 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/rs/zerolog/log"
+)
+
+// RebalancePlan describes the members moved off compute nodes holding more
+// than MaxPerNode members of the group. ComputeNodes counts the ready and
+// provisioning members on each compute node. Shortfall is how many more
+// members would need to move to reach MaxPerNode but can not, because they
+// are protected or still provisioning.
+type RebalancePlan struct {
+	AccountName  string           `json:"account_name"`
+	TsgName      string           `json:"tsg_name"`
+	TemplateID   string           `json:"template_id"`
+	MaxPerNode   int              `json:"max_per_node"`
+	ComputeNodes map[string]int   `json:"compute_nodes"`
+	Moves        []*RebalanceMove `json:"moves"`
+	Shortfall    int              `json:"shortfall,omitempty"`
+}
+
+// RebalanceMove is a member to be replaced by one on another compute node.
+type RebalanceMove struct {
+	InstanceID  string `json:"instance_id"`
+	ComputeNode string `json:"compute_node"`
+}
+
+// PlanRebalance computes the moves Rebalance would make without making any
+// of them.
+func (c *AgentComputeClient) PlanRebalance(ctx context.Context) (*RebalancePlan, error) {
+	instances, err := c.GetInstanceList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.planRebalance(instances)
+}
+
+func (c *AgentComputeClient) planRebalance(instances []*tcc.Instance) (*RebalancePlan, error) {
+	maxPerNode := config.GetRebalanceMaxPerNode()
+	if err := ValidateMaxPerNode(maxPerNode); err != nil {
+		return nil, err
+	}
+
+	members := classifyMembers(instances)
+	counts := computeNodeCounts(append(members.ready, members.provisioning...))
+
+	plan := &RebalancePlan{
+		AccountName:  c.client.Client.AccountName,
+		TsgName:      config.GetTsgName(),
+		TemplateID:   config.GetTsgTemplateID(),
+		MaxPerNode:   maxPerNode,
+		ComputeNodes: counts,
+	}
+
+	// Only ready, unprotected members are moved. On each crowded node the
+	// termination policies pick which of them go first.
+	movable := make(map[string][]*tcc.Instance)
+	ready, _ := splitProtected(members.ready)
+	for _, instance := range ready {
+		if instance.ComputeNode != "" {
+			movable[instance.ComputeNode] = append(movable[instance.ComputeNode], instance)
+		}
+	}
+
+	for _, node := range crowdedNodes(plan.ComputeNodes, maxPerNode) {
+		excess := plan.ComputeNodes[node] - maxPerNode
+		ordered, err := selectForTermination(movable[node], minInt(excess, len(movable[node])), config.GetTerminationPolicies())
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range ordered {
+			plan.Moves = append(plan.Moves, &RebalanceMove{
+				InstanceID:  instance.ID,
+				ComputeNode: node,
+			})
+		}
+		plan.Shortfall += excess - len(ordered)
+	}
+
+	return plan, nil
+}
+
+// ValidateMaxPerNode returns an error if maxPerNode is not set. It has no
+// default: CloudAPI does not tell an account which compute nodes could run
+// the group, so no share of them can be computed that is known to be
+// achievable, and a move that no compute node can take stops the
+// rebalance.
+func ValidateMaxPerNode(maxPerNode int) error {
+	if maxPerNode < 1 {
+		return fmt.Errorf("max-per-node is required and must be at least 1")
+	}
+	return nil
+}
+
+// crowdedNodes returns the compute nodes holding more than maxPerNode
+// members, most crowded first.
+func crowdedNodes(counts map[string]int, maxPerNode int) []string {
+	var nodes []string
+	for node, n := range counts {
+		if n > maxPerNode {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if counts[nodes[i]] != counts[nodes[j]] {
+			return counts[nodes[i]] > counts[nodes[j]]
+		}
+		return nodes[i] < nodes[j]
+	})
+	return nodes
+}
+
+// Rebalance moves members off compute nodes holding more than max-per-node
+// members of the group, one at a time and surge first: a replacement is
+// launched with affinity rules keeping it off every node that is already
+// full, and the crowded member is only deleted once the replacement is
+// running. The group is listed again after each move, and Rebalance stops
+// at the first move that fails, such as when no compute node can take the
+// replacement.
+func (c *AgentComputeClient) Rebalance(ctx context.Context) error {
+	instances, err := c.GetInstanceList(ctx)
+	if err != nil {
+		return err
+	}
+
+	rebalance, err := c.planRebalance(instances)
+	if err != nil {
+		return err
+	}
+
+	if len(rebalance.Moves) == 0 {
+		log.Info().
+			Str("account_name", rebalance.AccountName).
+			Str("tsg_name", rebalance.TsgName).
+			Str("status", "successful").
+			Str("notification_type", "TSG_INSTANCE_NO_OP").
+			Str("description", fmt.Sprintf("No compute node holds more than %d movable instance(s) of TSG: %q", rebalance.MaxPerNode, rebalance.TsgName)).
+			Msgf("TSG is balanced")
+		return nil
+	}
+
+	input, err := BuildCreateInstanceInput(rebalance.TemplateID)
+	if err != nil {
+		return err
+	}
+
+	// A replacement placed on a full node despite its affinity rules would
+	// leave the plan unchanged, so no more moves are made than were first
+	// planned.
+	for moves := len(rebalance.Moves); moves > 0 && len(rebalance.Moves) > 0; moves-- {
+		move := rebalance.Moves[0]
+		old := lookupMembers(instances, []string{move.InstanceID})[0]
+
+		if err := c.moveMember(ctx, rebalance, input, instances, old); err != nil {
+			return err
+		}
+
+		if instances, err = c.GetInstanceList(ctx); err != nil {
+			return err
+		}
+		if rebalance, err = c.planRebalance(instances); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveMember launches a replacement for old on a compute node that is not
+// full and, once it is running, deletes old.
+func (c *AgentComputeClient) moveMember(ctx context.Context, rebalance *RebalancePlan, input *tcc.CreateInstanceInput, instances []*tcc.Instance, old *tcc.Instance) error {
+	params := *input
+	params.Affinity = append(append([]string(nil), input.Affinity...),
+		c.avoidFullNodes(instances, rebalance.MaxPerNode, c.datacenterName(old.ID))...)
+
+	plan := &Plan{
+		Version:     planFormatVersion,
+		CreatedAt:   time.Now().UTC(),
+		AccountName: rebalance.AccountName,
+		TsgName:     rebalance.TsgName,
+		TemplateID:  rebalance.TemplateID,
+		Create:      1,
+		CreateInput: &params,
+	}
+	if c.multiDC() {
+		// The replacement is launched in the data center of the member it
+		// replaces.
+		plan.CreateIn = c.countByDatacenter([]*tcc.Instance{old})
+	}
+
+	if err := c.scaleOut(ctx, plan); err != nil {
+		return err
+	}
+
+	if err := c.scaleIn(ctx, plan, []*tcc.Instance{old}); err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("account_name", plan.AccountName).
+		Str("tsg_name", plan.TsgName).
+		Str("status", "successful").
+		Str("notification_type", "TSG_INSTANCE_REBALANCE").
+		Str("description", fmt.Sprintf("Replaced instance %s on compute node %s", old.ID, old.ComputeNode)).
+		Msgf("An instance was moved off a crowded compute node")
+
+	return nil
+}
+
+// avoidFullNodes returns affinity rules keeping a new instance off every
+// compute node in the data center named dcName that already holds
+// maxPerNode or more members. Each node is named by one of its members,
+// since CloudAPI affinity rules can not name compute nodes directly.
+func (c *AgentComputeClient) avoidFullNodes(instances []*tcc.Instance, maxPerNode int, dcName string) []string {
+	members := classifyMembers(instances)
+
+	var local []*tcc.Instance
+	for _, instance := range append(members.ready, members.provisioning...) {
+		if c.datacenterName(instance.ID) == dcName {
+			local = append(local, instance)
+		}
+	}
+
+	counts := computeNodeCounts(local)
+	named := make(map[string]bool)
+	var rules []string
+	for _, instance := range local {
+		node := instance.ComputeNode
+		if node == "" || named[node] || counts[node] < maxPerNode {
+			continue
+		}
+		named[node] = true
+		rules = append(rules, fmt.Sprintf("instance!=%s", instance.ID))
+	}
+	sort.Strings(rules)
+
+	return rules
+}
+
+// WriteText writes a human readable description of the rebalance plan to w.
+func (p *RebalancePlan) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "TSG %q (account %q, template %q)\n", p.TsgName, p.AccountName, p.TemplateID)
+	fmt.Fprintf(&b, "  Max per node: %d\n", p.MaxPerNode)
+	writeComputeNodes(&b, p.ComputeNodes)
+
+	if p.Shortfall > 0 {
+		fmt.Fprintf(&b, "\n%d instance(s) can not be moved because they are protected or still provisioning.\n", p.Shortfall)
+	}
+
+	if len(p.Moves) == 0 {
+		fmt.Fprintf(&b, "\nNo changes.\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	fmt.Fprintf(&b, "\nReplace %d instance(s) on crowded compute nodes:\n", len(p.Moves))
+	for _, move := range p.Moves {
+		fmt.Fprintf(&b, "  - %s (on %s)\n", move.InstanceID, move.ComputeNode)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,143 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestPlanRebalanceMaxPerNode(t *testing.T) {
+	tests := []struct {
+		name       string
+		nodes      []string
+		maxPerNode int
+		wantMax    int
+		wantMoves  int
+		wantErr    bool
+	}{
+		{name: "crowded", nodes: []string{"a", "a", "a", "b"}, maxPerNode: 2, wantMax: 2, wantMoves: 1},
+		{name: "balanced", nodes: []string{"a", "b", "a", "b"}, maxPerNode: 2, wantMax: 2},
+		{name: "one per node", nodes: []string{"a", "b", "a", "b"}, maxPerNode: 1, wantMax: 1, wantMoves: 2},
+		{name: "every member on one node", nodes: []string{"a", "a", "a", "a"}, maxPerNode: 1, wantMax: 1, wantMoves: 3},
+		{name: "every member on one node, two per node", nodes: []string{"a", "a", "a", "a"}, maxPerNode: 2, wantMax: 2, wantMoves: 2},
+		{name: "spread", nodes: []string{"a", "b", "c"}, maxPerNode: 1, wantMax: 1},
+		{name: "not set", nodes: []string{"a", "a"}, wantErr: true},
+		{name: "negative", nodes: []string{"a"}, maxPerNode: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			api.addMembers("web", len(tt.nodes), tt.nodes...)
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyRebalanceMaxPerNode, tt.maxPerNode)
+
+			instances, err := c.GetInstanceList(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan, err := c.planRebalance(instances)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if plan.MaxPerNode != tt.wantMax {
+				t.Errorf("max per node = %d, want %d", plan.MaxPerNode, tt.wantMax)
+			}
+			if len(plan.Moves) != tt.wantMoves {
+				t.Errorf("plan moves %d instances, want %d", len(plan.Moves), tt.wantMoves)
+			}
+		})
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	tests := []struct {
+		name       string
+		nodes      []string
+		maxPerNode int
+		createNode string
+		failCreate int
+		wantEvents []string
+		wantErr    bool
+	}{
+		{
+			name:       "surge first",
+			nodes:      []string{"a", "a", "a", "b"},
+			maxPerNode: 2,
+			createNode: "c",
+			wantEvents: []string{"create", "ready", "delete"},
+		},
+		{
+			name:       "every member on one node",
+			nodes:      []string{"a", "a", "a"},
+			maxPerNode: 1,
+			createNode: "",
+			wantEvents: []string{"create", "ready", "delete", "create", "ready", "delete"},
+		},
+		{
+			// Replacements placed on the crowded node despite their
+			// affinity rules leave the plan unchanged.
+			name:       "move cap",
+			nodes:      []string{"a", "a", "a"},
+			maxPerNode: 1,
+			createNode: "a",
+			wantEvents: []string{"create", "ready", "delete", "create", "ready", "delete"},
+		},
+		{
+			name:       "failed move",
+			nodes:      []string{"a", "a", "a", "a"},
+			maxPerNode: 1,
+			failCreate: 2,
+			wantEvents: []string{"create", "ready", "delete"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			api.addMembers("web", len(tt.nodes), tt.nodes...)
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyRebalanceMaxPerNode, tt.maxPerNode)
+			viper.Set(iconfig.KeyInstanceReadyPoll, 10*time.Millisecond)
+			viper.Set(iconfig.KeyInstanceReadyTimeout, 5*time.Second)
+
+			watch := &refreshWatch{createNode: tt.createNode, failCreate: tt.failCreate}
+			api.watchRefresh(watch)
+
+			err := c.Rebalance(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rebalance = %v, want error %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(watch.events, tt.wantEvents) {
+				t.Errorf("events = %v, want %v", watch.events, tt.wantEvents)
+			}
+			if watch.early != 0 {
+				t.Errorf("%d instance(s) deleted before their replacements were running", watch.early)
+			}
+			if got := api.count(); got != len(tt.nodes) {
+				t.Errorf("group has %d instances, want %d", got, len(tt.nodes))
+			}
+		})
+	}
+}
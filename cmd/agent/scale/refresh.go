@@ -0,0 +1,138 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Refresh replaces every member whose tsg.template tag differs from the
+// current template. Members are replaced in batches: up to max-unavailable
+// outdated members are deleted up front, then up to max-surge plus that many
+// replacements are launched and, once they are running, the rest of the
+// batch is deleted.
+func (c *AgentComputeClient) Refresh(ctx context.Context) error {
+	maxSurge := config.GetRefreshMaxSurge()
+	maxUnavailable := config.GetRefreshMaxUnavailable()
+	if maxSurge < 0 || maxUnavailable < 0 {
+		return fmt.Errorf("max-surge and max-unavailable must not be negative")
+	}
+	if maxSurge == 0 && maxUnavailable == 0 {
+		return fmt.Errorf("max-surge and max-unavailable can not both be zero")
+	}
+
+	instances, err := c.GetInstanceList(ctx)
+	if err != nil {
+		return err
+	}
+
+	templateID := config.GetTsgTemplateID()
+	tsgName := config.GetTsgName()
+
+	// Protected members are left on their old template.
+	outdated := c.skipProtected(&Plan{
+		AccountName: c.client.Client.AccountName,
+		TsgName:     tsgName,
+	}, outdatedInstances(instances, templateID))
+	if len(outdated) == 0 {
+		log.Info().
+			Str("account_name", c.client.Client.AccountName).
+			Str("tsg_name", tsgName).
+			Str("status", "successful").
+			Str("notification_type", "TSG_INSTANCE_NO_OP").
+			Str("description", fmt.Sprintf("All unprotected instances in TSG: %q use template %s", tsgName, templateID)).
+			Msgf("TSG is up to date")
+		return nil
+	}
+
+	input, err := BuildCreateInstanceInput(templateID)
+	if err != nil {
+		return err
+	}
+
+	for batch := 1; len(outdated) > 0; batch++ {
+		ordered, err := selectForTermination(outdated, len(outdated), config.GetTerminationPolicies())
+		if err != nil {
+			return err
+		}
+
+		unavailable := minInt(maxUnavailable, len(ordered))
+		replace := minInt(maxSurge+unavailable, len(ordered))
+
+		plan := &Plan{
+			Version:     planFormatVersion,
+			CreatedAt:   time.Now().UTC(),
+			AccountName: c.client.Client.AccountName,
+			TsgName:     tsgName,
+			TemplateID:  templateID,
+			Create:      replace,
+			CreateInput: input,
+		}
+
+		old := ordered[:replace]
+		if c.multiDC() {
+			// Replacements are launched where the members they replace are.
+			plan.CreateIn = c.countByDatacenter(old)
+		}
+		if unavailable > 0 {
+			if err := c.scaleIn(ctx, plan, old[:unavailable]); err != nil {
+				return err
+			}
+		}
+
+		if err := c.scaleOut(ctx, plan); err != nil {
+			return err
+		}
+
+		if replace > unavailable {
+			if err := c.scaleIn(ctx, plan, old[unavailable:]); err != nil {
+				return err
+			}
+		}
+
+		log.Info().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "successful").
+			Str("notification_type", "TSG_INSTANCE_REFRESH").
+			Str("description", fmt.Sprintf("Replaced instances %s with template %s", strings.Join(instanceIDs(old), ", "), templateID)).
+			Msgf("Refresh batch %d complete, %d outdated instance(s) remaining", batch, len(ordered)-replace)
+
+		outdated = ordered[replace:]
+	}
+
+	return nil
+}
+
+// outdatedInstances returns the ready members not launched from templateID.
+// Members that are provisioning, stopping, unhealthy or being deleted are
+// left to the reconcile, which replaces or counts them already.
+func outdatedInstances(instances []*tcc.Instance, templateID string) []*tcc.Instance {
+	var outdated []*tcc.Instance
+	for _, instance := range classifyMembers(instances).ready {
+		if instanceTemplateID(instance) != templateID {
+			outdated = append(outdated, instance)
+		}
+	}
+	return outdated
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,174 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+// refreshWatch records how a refresh or rebalance changes the group.
+// Created instances provision until they are first looked up, and are
+// placed on createNode.
+type refreshWatch struct {
+	createNode string
+
+	// events lists the creates, instances becoming ready and deletes in
+	// order.
+	events []string
+
+	peak       int
+	minRunning int
+
+	// early counts deletes made while a replacement was still provisioning.
+	early int
+
+	// failCreate, if not 0, is the create that fails.
+	failCreate int
+	creates    int
+}
+
+func (f *fakeCloudAPI) watchRefresh(w *refreshWatch) {
+	f.createState = StateProvisioning
+	w.minRunning = f.count()
+
+	f.intercept = func(r *http.Request) int {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case r.Method == http.MethodPost && len(parts) == 2:
+			if w.creates++; w.creates == w.failCreate {
+				return http.StatusInternalServerError
+			}
+			w.events = append(w.events, "create")
+		case r.Method == http.MethodGet && len(parts) == 3:
+			if instance, ok := f.instances[parts[2]]; ok && instance.State == StateProvisioning {
+				instance.State = StateRunning
+				instance.ComputeNode = w.createNode
+				w.events = append(w.events, "ready")
+			}
+		case r.Method == http.MethodDelete && len(parts) == 3:
+			for _, instance := range f.instances {
+				if instance.State == StateProvisioning {
+					w.early++
+				}
+			}
+			w.events = append(w.events, "delete")
+		}
+
+		var running int
+		for _, instance := range f.instances {
+			if instance.State == StateRunning {
+				running++
+			}
+		}
+		if len(f.instances) > w.peak {
+			w.peak = len(f.instances)
+		}
+		if running < w.minRunning {
+			w.minRunning = running
+		}
+		return 0
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxSurge       int
+		maxUnavailable int
+		protected      int
+		failCreate     int
+		wantPeak       int
+		wantMinRunning int
+		wantCreates    int
+		wantOld        int
+		wantErr        bool
+	}{
+		{name: "surge one", maxSurge: 1, wantPeak: 5, wantMinRunning: 4, wantCreates: 4},
+		{name: "surge two", maxSurge: 2, wantPeak: 6, wantMinRunning: 4, wantCreates: 4},
+		{name: "surge above members", maxSurge: 9, wantPeak: 8, wantMinRunning: 4, wantCreates: 4},
+		{name: "unavailable one", maxUnavailable: 1, wantPeak: 4, wantMinRunning: 3, wantCreates: 4},
+		{name: "unavailable two", maxUnavailable: 2, wantPeak: 4, wantMinRunning: 2, wantCreates: 4},
+		{name: "surge and unavailable", maxSurge: 1, maxUnavailable: 1, wantPeak: 5, wantMinRunning: 3, wantCreates: 4},
+		{name: "protected", maxSurge: 1, protected: 1, wantPeak: 5, wantMinRunning: 4, wantCreates: 3, wantOld: 1},
+		{name: "failed batch", maxSurge: 1, failCreate: 2, wantPeak: 5, wantMinRunning: 4, wantCreates: 2, wantOld: 3, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			members := api.addMembers("web", 4)
+			for i, member := range members {
+				member.Tags["tsg.template"] = "old"
+				if i < tt.protected {
+					member.Tags[protectedTag] = "true"
+				}
+			}
+			// Members that are not ready are left to the reconcile.
+			stopping := api.addMembers("web", 1)[0]
+			stopping.State = "stopping"
+			stopping.Tags["tsg.template"] = "old"
+
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyRefreshMaxSurge, tt.maxSurge)
+			viper.Set(iconfig.KeyRefreshMaxUnavailable, tt.maxUnavailable)
+			viper.Set(iconfig.KeyInstanceReadyPoll, 10*time.Millisecond)
+			viper.Set(iconfig.KeyInstanceReadyTimeout, 5*time.Second)
+
+			watch := &refreshWatch{failCreate: tt.failCreate}
+			api.watchRefresh(watch)
+
+			err := c.Refresh(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Refresh = %v, want error %v", err, tt.wantErr)
+			}
+
+			// The stopping member adds one to every count.
+			if watch.peak != tt.wantPeak+1 {
+				t.Errorf("group peaked at %d instances, want %d", watch.peak-1, tt.wantPeak)
+			}
+			if watch.minRunning != tt.wantMinRunning {
+				t.Errorf("group fell to %d running instances, want %d", watch.minRunning, tt.wantMinRunning)
+			}
+			if watch.early != 0 {
+				t.Errorf("%d instance(s) deleted before their replacements were running", watch.early)
+			}
+			if watch.creates != tt.wantCreates {
+				t.Errorf("created %d instances, want %d", watch.creates, tt.wantCreates)
+			}
+
+			var old int
+			for _, member := range members {
+				if api.instance(member.ID) != nil {
+					old++
+				}
+			}
+			if old != tt.wantOld {
+				t.Errorf("%d old members remain, want %d", old, tt.wantOld)
+			}
+			for i := 0; i < tt.protected; i++ {
+				if api.instance(members[i].ID) == nil {
+					t.Errorf("protected member %s was replaced", members[i].ID)
+				}
+			}
+			if api.instance(stopping.ID) == nil {
+				t.Error("stopping member was replaced")
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	tritonerrors "github.com/joyent/triton-go/errors"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// errorClass describes why a CloudAPI call failed and so whether it is
+// worth retrying.
+type errorClass string
+
+const (
+	errorTransient errorClass = "transient"
+	errorThrottled errorClass = "throttled"
+	errorAuth      errorClass = "auth"
+	errorNotFound  errorClass = "not-found"
+	errorCapacity  errorClass = "capacity"
+	errorPermanent errorClass = "permanent"
+)
+
+// classifyError sorts a CloudAPI error into an errorClass.
+func classifyError(err error) errorClass {
+	switch {
+	case tritonerrors.IsRequestThrottled(err),
+		tritonerrors.IsConcurrentRequestError(err),
+		tritonerrors.IsSpecificStatusCode(err, http.StatusTooManyRequests):
+		return errorThrottled
+	case tritonerrors.IsNotAuthorized(err),
+		tritonerrors.IsInvalidCredentials(err),
+		tritonerrors.IsInvalidSignatureError(err),
+		tritonerrors.IsInvalidKeyIdError(err),
+		tritonerrors.IsAuthSchemeError(err),
+		tritonerrors.IsAuthorizationError(err),
+		tritonerrors.IsSpecificStatusCode(err, http.StatusUnauthorized),
+		tritonerrors.IsSpecificStatusCode(err, http.StatusForbidden):
+		return errorAuth
+	case tritonerrors.IsResourceNotFound(err),
+		tritonerrors.IsStatusNotFoundCode(err):
+		return errorNotFound
+	case tritonerrors.IsNotEnoughSpaceError(err),
+		tritonerrors.IsSpecificError(err, "InsufficientCapacity"),
+		tritonerrors.IsSpecificError(err, "QuotaExceeded"):
+		return errorCapacity
+	case tritonerrors.IsServiceUnavailableError(err),
+		tritonerrors.IsInternalError(err),
+		isServerError(err),
+		isNetworkError(err):
+		return errorTransient
+	}
+
+	return errorPermanent
+}
+
+func isServerError(err error) bool {
+	apiErr, ok := errors.Cause(err).(*tritonerrors.APIError)
+	return ok && apiErr.StatusCode >= http.StatusInternalServerError
+}
+
+func isNetworkError(err error) bool {
+	switch errors.Cause(err).(type) {
+	case *url.Error, net.Error:
+		return true
+	}
+	return false
+}
+
+// retriable reports whether a call that failed with err may be repeated.
+func retriable(err error) bool {
+	class := classifyError(err)
+	return class == errorTransient || class == errorThrottled
+}
+
+var (
+	retryJitterMu sync.Mutex
+	retryJitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// retryDelay returns the backoff before retry number attempt (starting at
+// 1): the base delay doubled for each earlier retry, capped at the maximum
+// delay, of which a random half is waited.
+func retryDelay(attempt int) time.Duration {
+	delay := config.GetRetryBaseDelay()
+	maxDelay := config.GetRetryMaxDelay()
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	retryJitterMu.Lock()
+	defer retryJitterMu.Unlock()
+
+	half := delay / 2
+	return half + time.Duration(retryJitter.Int63n(int64(delay-half)+1))
+}
+
+// sleepContext waits for d, returning early with ctx's error if ctx is done
+// first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not
+// retriable, has been called the configured maximum number of times or ctx
+// is done.
+func withRetry(ctx context.Context, op string, fn func() error) error {
+	attempts := config.GetRetryMaxAttempts()
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts || !retriable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := retryDelay(attempt)
+		log.Warn().
+			Str("tsg_name", config.GetTsgName()).
+			Str("operation", op).
+			Str("error_class", string(classifyError(err))).
+			Int("attempt", attempt).
+			Dur("retry_in", delay).
+			Str("description", err.Error()).
+			Msgf("CloudAPI %s failed, retrying", op)
+
+		if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+// retryingInstances wraps the CloudAPI instance calls made while scaling a
+// group so that transient and throttled failures are retried. Listings and
+// creates go to dc; calls about an existing instance go to the data center
+// it is in.
+type retryingInstances struct {
+	c  *AgentComputeClient
+	dc *Datacenter
+}
+
+func (c *AgentComputeClient) instances() *retryingInstances {
+	return c.instancesIn(c.primary)
+}
+
+func (c *AgentComputeClient) instancesIn(dc *Datacenter) *retryingInstances {
+	return &retryingInstances{c: c, dc: dc}
+}
+
+func (r *retryingInstances) client() *tcc.InstancesClient {
+	return r.dc.client.Instances()
+}
+
+func (r *retryingInstances) clientFor(ctx context.Context, instanceID string) *tcc.InstancesClient {
+	return r.c.locate(ctx, instanceID).client.Instances()
+}
+
+func (r *retryingInstances) Count(ctx context.Context, input *tcc.ListInstancesInput) (int, error) {
+	var count int
+	err := withRetry(ctx, "count", func() (err error) {
+		count, err = r.client().Count(ctx, input)
+		return err
+	})
+	return count, err
+}
+
+func (r *retryingInstances) List(ctx context.Context, input *tcc.ListInstancesInput) ([]*tcc.Instance, error) {
+	var instances []*tcc.Instance
+	err := withRetry(ctx, "list", func() (err error) {
+		instances, err = r.client().List(ctx, input)
+		return err
+	})
+	return instances, err
+}
+
+func (r *retryingInstances) Get(ctx context.Context, input *tcc.GetInstanceInput) (*tcc.Instance, error) {
+	var instance *tcc.Instance
+	err := withRetry(ctx, "get", func() (err error) {
+		instance, err = r.clientFor(ctx, input.ID).Get(ctx, input)
+		return err
+	})
+	return instance, err
+}
+
+// Create launches an instance. A failed create may still have launched it,
+// so each instance is given a unique name and a create is only repeated
+// once no instance with that name is found.
+func (r *retryingInstances) Create(ctx context.Context, input *tcc.CreateInstanceInput) (*tcc.Instance, error) {
+	params := *input
+	if params.Name == "" {
+		params.Name = newInstanceName(params.Tags["tsg.template"])
+	}
+
+	var instance *tcc.Instance
+	var attempted bool
+	err := withRetry(ctx, "create", func() (err error) {
+		if attempted {
+			instance, err = r.findByName(ctx, params.Name)
+			if err != nil || instance != nil {
+				return err
+			}
+		}
+		attempted = true
+
+		instance, err = r.client().Create(ctx, &params)
+		return err
+	})
+	if err == nil {
+		r.c.remember(r.dc, instance)
+	}
+	return instance, err
+}
+
+// findByName returns the instance named name, or nil if there is none.
+func (r *retryingInstances) findByName(ctx context.Context, name string) (*tcc.Instance, error) {
+	instances, err := r.client().List(ctx, &tcc.ListInstancesInput{
+		Name: name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, instance := range instances {
+		if instance.Name == name {
+			log.Info().
+				Str("tsg_name", config.GetTsgName()).
+				Str("instance_id", instance.ID).
+				Msgf("Found instance %q launched by a failed create", name)
+			return instance, nil
+		}
+	}
+	return nil, nil
+}
+
+// newInstanceName names an instance like its name tag,
+// tsg-<template>-<suffix>, with a random suffix in place of the instance ID
+// that is not known until the instance is created.
+func newInstanceName(templateID string) string {
+	retryJitterMu.Lock()
+	suffix := retryJitter.Int63n(1 << 48)
+	retryJitterMu.Unlock()
+
+	if len(templateID) > 8 {
+		templateID = templateID[:8]
+	}
+	if templateID == "" {
+		return fmt.Sprintf("tsg-%012x", suffix)
+	}
+	return fmt.Sprintf("tsg-%s-%012x", templateID, suffix)
+}
+
+// Delete deletes an instance. A repeated delete that finds the instance
+// gone succeeds, since an earlier attempt deleted it.
+func (r *retryingInstances) Delete(ctx context.Context, input *tcc.DeleteInstanceInput) error {
+	var attempted bool
+	return withRetry(ctx, "delete", func() error {
+		err := r.clientFor(ctx, input.ID).Delete(ctx, input)
+		if attempted && isGone(err) {
+			return nil
+		}
+		attempted = true
+		return err
+	})
+}
+
+func (r *retryingInstances) AddTags(ctx context.Context, input *tcc.AddTagsInput) error {
+	return withRetry(ctx, "add-tags", func() error {
+		return r.clientFor(ctx, input.ID).AddTags(ctx, input)
+	})
+}
+
+func (r *retryingInstances) DeleteTag(ctx context.Context, input *tcc.DeleteTagInput) error {
+	return withRetry(ctx, "delete-tag", func() error {
+		return r.clientFor(ctx, input.ID).DeleteTag(ctx, input)
+	})
+}
+
+func (r *retryingInstances) Stop(ctx context.Context, input *tcc.StopInstanceInput) error {
+	return withRetry(ctx, "stop", func() error {
+		return r.clientFor(ctx, input.InstanceID).Stop(ctx, input)
+	})
+}
+
+func (r *retryingInstances) Start(ctx context.Context, input *tcc.StartInstanceInput) error {
+	return withRetry(ctx, "start", func() error {
+		return r.clientFor(ctx, input.InstanceID).Start(ctx, input)
+	})
+}
+
+func (r *retryingInstances) EnableDeletionProtection(ctx context.Context, input *tcc.EnableDeletionProtectionInput) error {
+	return withRetry(ctx, "enable-deletion-protection", func() error {
+		return r.clientFor(ctx, input.InstanceID).EnableDeletionProtection(ctx, input)
+	})
+}
+
+func (r *retryingInstances) DisableDeletionProtection(ctx context.Context, input *tcc.DisableDeletionProtectionInput) error {
+	return withRetry(ctx, "disable-deletion-protection", func() error {
+		return r.clientFor(ctx, input.InstanceID).DisableDeletionProtection(ctx, input)
+	})
+}
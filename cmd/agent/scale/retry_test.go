@@ -0,0 +1,129 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	tcc "github.com/joyent/triton-go/compute"
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+// failFirst makes the first request matching method and path fail with
+// status, after serving it if served is set, as when a response is lost
+// after CloudAPI acted on the request.
+func (f *fakeCloudAPI) failFirst(method, path string, status int, served bool) {
+	var once sync.Once
+	f.intercept = func(r *http.Request) int {
+		if r.Method != method || r.URL.Path != path {
+			return 0
+		}
+
+		failed := 0
+		once.Do(func() {
+			if served {
+				f.serve(httptest.NewRecorder(), r)
+			}
+			failed = status
+		})
+		return failed
+	}
+}
+
+func TestCreateRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		served bool
+		posts  int
+	}{
+		{name: "refused", status: http.StatusServiceUnavailable, posts: 2},
+		{name: "created despite error", status: http.StatusServiceUnavailable, served: true, posts: 1},
+		{name: "internal error after create", status: http.StatusInternalServerError, served: true, posts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyRetryMaxAttempts, 3)
+			api.failFirst("POST", "/acct/machines", tt.status, tt.served)
+
+			instance, err := c.instances().Create(context.Background(), &tcc.CreateInstanceInput{
+				Tags: map[string]string{"tsg.name": "web"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := api.count(); got != 1 {
+				t.Errorf("fake has %d instances, want 1", got)
+			}
+			if api.instance(instance.ID) == nil {
+				t.Errorf("returned instance %s does not exist", instance.ID)
+			}
+			if got := api.requestCount("POST", "/acct/machines"); got != tt.posts {
+				t.Errorf("created %d times, want %d", got, tt.posts)
+			}
+		})
+	}
+}
+
+func TestCreateNamesInstances(t *testing.T) {
+	api := newFakeCloudAPI()
+	c := newTestClient(t, api, "web")
+
+	input := &tcc.CreateInstanceInput{
+		Tags: map[string]string{"tsg.template": "0123456789abcdef"},
+	}
+	a, err := c.instances().Create(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.instances().Create(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Name == "" || a.Name == b.Name {
+		t.Errorf("instances named %q and %q, want distinct names", a.Name, b.Name)
+	}
+	if !strings.HasPrefix(a.Name, "tsg-01234567-") {
+		t.Errorf("instance named %q, want the tsg-<template>- prefix of its name tag", a.Name)
+	}
+	if input.Name != "" {
+		t.Errorf("Create modified its input")
+	}
+}
+
+func TestDeleteRetryGone(t *testing.T) {
+	api := newFakeCloudAPI()
+	members := api.addMembers("web", 1)
+	c := newTestClient(t, api, "web")
+	viper.Set(iconfig.KeyRetryMaxAttempts, 3)
+
+	path := "/acct/machines/" + members[0].ID
+	api.failFirst("DELETE", path, http.StatusServiceUnavailable, true)
+
+	if err := c.instances().Delete(context.Background(), &tcc.DeleteInstanceInput{ID: members[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.requestCount("DELETE", path); got != 2 {
+		t.Errorf("deleted %d times, want 2", got)
+	}
+	if api.count() != 0 {
+		t.Errorf("instance was not deleted")
+	}
+}
@@ -0,0 +1,129 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/joyent/tsg-cli/cmd/agent/schedule"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/rs/zerolog/log"
+)
+
+// GetSchedules returns the scheduled scaling actions of the group.
+func (c *AgentComputeClient) GetSchedules() ([]*schedule.Schedule, error) {
+	state, err := loadGroupState(c.client.Client.AccountName, config.GetTsgName())
+	if err != nil {
+		return nil, err
+	}
+
+	return state.Schedules, nil
+}
+
+// AddSchedule adds a scheduled scaling action to the group, replacing any
+// schedule with the same name.
+func (c *AgentComputeClient) AddSchedule(s *schedule.Schedule) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	accountName, tsgName := c.client.Client.AccountName, config.GetTsgName()
+	state, err := loadGroupState(accountName, tsgName)
+	if err != nil {
+		return err
+	}
+
+	replaced := false
+	for i, existing := range state.Schedules {
+		if existing.Name == s.Name {
+			state.Schedules[i] = s
+			replaced = true
+		}
+	}
+	if !replaced {
+		state.Schedules = append(state.Schedules, s)
+	}
+
+	return state.save(accountName, tsgName)
+}
+
+// RemoveSchedule removes the named scheduled scaling action from the group.
+func (c *AgentComputeClient) RemoveSchedule(name string) error {
+	accountName, tsgName := c.client.Client.AccountName, config.GetTsgName()
+	state, err := loadGroupState(accountName, tsgName)
+	if err != nil {
+		return err
+	}
+
+	var kept []*schedule.Schedule
+	for _, s := range state.Schedules {
+		if s.Name != name {
+			kept = append(kept, s)
+		}
+	}
+	if len(kept) == len(state.Schedules) {
+		return fmt.Errorf("TSG %q has no schedule %q", tsgName, name)
+	}
+	state.Schedules = kept
+
+	return state.save(accountName, tsgName)
+}
+
+// GroupHasSchedules reports whether a group has scheduled scaling actions
+// that can supply its expected instance count.
+func GroupHasSchedules(accountName, tsgName string) bool {
+	state, err := loadGroupState(accountName, tsgName)
+	return err == nil && len(state.Schedules) > 0
+}
+
+// expectedCount returns the expected instance count of the group and the
+// name of the schedule it was taken from, if any. The count of the active
+// schedule replaces the configured count, and its bounds limit the count
+// computed by an autoscaling policy.
+func (c *AgentComputeClient) expectedCount(ctx context.Context, current int) (int, string, error) {
+	schedules, err := c.GetSchedules()
+	if err != nil {
+		return 0, "", err
+	}
+
+	active, fired, err := schedule.Active(schedules, time.Now())
+	if err != nil {
+		return 0, "", err
+	}
+
+	var count int
+	switch {
+	case c.desiredCount != nil:
+		if count, err = c.desiredCount(ctx, current); err != nil {
+			return 0, "", err
+		}
+	case active != nil && active.Count != nil:
+		count = *active.Count
+	default:
+		count = config.GetExpectedMachineCount()
+	}
+
+	if active == nil {
+		return count, "", nil
+	}
+
+	count = active.Clamp(count)
+
+	log.Debug().
+		Str("tsg_name", config.GetTsgName()).
+		Str("notification_type", "TSG_SCHEDULE").
+		Str("schedule", active.Name).
+		Time("fired_at", fired).
+		Int("count", count).
+		Msgf("Schedule %q is active", active.Name)
+
+	return count, active.Name, nil
+}
@@ -0,0 +1,128 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joyent/tsg-cli/cmd/agent/schedule"
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestExpectedCount(t *testing.T) {
+	intPtr := func(n int) *int { return &n }
+	desired := func(n int) DesiredCountFunc {
+		return func(ctx context.Context, current int) (int, error) { return n, nil }
+	}
+
+	// "* * * * *" has always fired within the last minute, and "0 0 30 2 *"
+	// never fires.
+	tests := []struct {
+		name      string
+		schedules []*schedule.Schedule
+		desired   DesiredCountFunc
+		want      int
+		wantName  string
+	}{
+		{name: "no schedules", want: 3},
+		{name: "not fired", schedules: []*schedule.Schedule{{Name: "never", Cron: "0 0 30 2 *", Count: intPtr(7)}}, want: 3},
+		{name: "count", schedules: []*schedule.Schedule{{Name: "now", Cron: "* * * * *", Count: intPtr(7)}}, want: 7, wantName: "now"},
+		{name: "zero count", schedules: []*schedule.Schedule{{Name: "now", Cron: "* * * * *", Count: intPtr(0)}}, want: 0, wantName: "now"},
+		{name: "minimum raises the flag count", schedules: []*schedule.Schedule{{Name: "now", Cron: "* * * * *", MinCount: 5}}, want: 5, wantName: "now"},
+		{name: "maximum lowers the flag count", schedules: []*schedule.Schedule{{Name: "now", Cron: "* * * * *", MaxCount: 2}}, want: 2, wantName: "now"},
+		{name: "policy", desired: desired(6), want: 6},
+		{name: "policy over count", schedules: []*schedule.Schedule{{Name: "now", Cron: "* * * * *", Count: intPtr(7)}}, desired: desired(6), want: 6, wantName: "now"},
+		{name: "policy below minimum", schedules: []*schedule.Schedule{{Name: "now", Cron: "* * * * *", MinCount: 4, MaxCount: 8}}, desired: desired(1), want: 4, wantName: "now"},
+		{name: "policy above maximum", schedules: []*schedule.Schedule{{Name: "now", Cron: "* * * * *", MinCount: 4, MaxCount: 8}}, desired: desired(20), want: 8, wantName: "now"},
+		{name: "policy, not fired", schedules: []*schedule.Schedule{{Name: "never", Cron: "0 0 30 2 *", MaxCount: 2}}, desired: desired(20), want: 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, newFakeCloudAPI(), "group")
+			viper.Set(iconfig.KeyInstanceCount, 3)
+			for _, s := range tt.schedules {
+				if err := c.AddSchedule(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c.SetDesiredCountFunc(tt.desired)
+
+			got, name, err := c.expectedCount(context.Background(), 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || name != tt.wantName {
+				t.Errorf("expectedCount = %d, %q, want %d, %q", got, name, tt.want, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	c := newTestClient(t, newFakeCloudAPI(), "group")
+	names := func() []string {
+		schedules, err := c.GetSchedules()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, s := range schedules {
+			names = append(names, s.Name)
+		}
+		return names
+	}
+
+	if got := names(); len(got) != 0 {
+		t.Errorf("schedules = %v, want none", got)
+	}
+	if GroupHasSchedules(testAccount, "group") {
+		t.Error("GroupHasSchedules = true before any schedule was added")
+	}
+
+	if err := c.AddSchedule(&schedule.Schedule{Name: "bad", Cron: "0 8 * *", MinCount: 1}); err == nil {
+		t.Error("AddSchedule accepted an invalid schedule")
+	}
+
+	for _, s := range []*schedule.Schedule{
+		{Name: "morning", Cron: "0 8 * * *", MinCount: 5},
+		{Name: "evening", Cron: "0 20 * * *", MaxCount: 2},
+		{Name: "morning", Cron: "0 7 * * *", MinCount: 6},
+	} {
+		if err := c.AddSchedule(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := names(); len(got) != 2 || got[0] != "morning" || got[1] != "evening" {
+		t.Errorf("schedules = %v, want [morning evening]", got)
+	}
+	schedules, err := c.GetSchedules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := schedules[0]; s.Cron != "0 7 * * *" || s.MinCount != 6 {
+		t.Errorf("morning = %+v, want the replacement", s)
+	}
+	if !GroupHasSchedules(testAccount, "group") {
+		t.Error("GroupHasSchedules = false after schedules were added")
+	}
+
+	if err := c.RemoveSchedule("noon"); err == nil {
+		t.Error("RemoveSchedule of an unknown schedule succeeded")
+	}
+	if err := c.RemoveSchedule("morning"); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(); len(got) != 1 || got[0] != "evening" {
+		t.Errorf("schedules = %v, want [evening]", got)
+	}
+}
@@ -0,0 +1,94 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/config"
+)
+
+const (
+	StateProvisioning = "provisioning"
+	StateRunning      = "running"
+	StateStopping     = "stopping"
+	StateDeleted      = "deleted"
+)
+
+// unhealthyStates are the states a member does not leave without being
+// acted on, so members in them are replaced. Members in any other state
+// that is neither healthy nor deleted, such as stopping or a state CloudAPI
+// adds later, are still changing and are left alone until they settle.
+var unhealthyStates = map[string]bool{
+	"stopped":    true,
+	"failed":     true,
+	"offline":    true,
+	"incomplete": true,
+}
+
+// groupMembers splits a group's instances by where they are in their
+// lifecycle. Ready and provisioning members count toward the group's
+// expected size, provisioning members being any that are still changing
+// state; terminating members are already going away, unhealthy
+// members need to be replaced, warm members are stopped in the warm pool and
+// failed members were kept after a failed launch and are left alone.
+type groupMembers struct {
+	ready        []*tcc.Instance
+	provisioning []*tcc.Instance
+	terminating  []*tcc.Instance
+	unhealthy    []*tcc.Instance
+	warm         []*tcc.Instance
+	failed       []*tcc.Instance
+}
+
+// classifyMembers sorts instances by state. Members in one of the configured
+// healthy states are ready. Provisioning members are pending capacity until
+// they are older than the provisioning timeout, at which point they are
+// considered failed. Members tagged into the warm pool are warm, and members
+// tagged as failed launches are failed, whatever their state. Members in one
+// of the unhealthy states are unhealthy, and members in any other state are
+// in flight and counted with the provisioning members, without an age limit
+// since their creation time says nothing about when they changed state.
+func classifyMembers(instances []*tcc.Instance) *groupMembers {
+	healthyStates := make(map[string]bool)
+	for _, state := range config.GetHealthyInstanceStates() {
+		healthyStates[state] = true
+	}
+
+	timeout := config.GetProvisioningTimeout()
+	now := time.Now()
+
+	members := &groupMembers{}
+	for _, instance := range instances {
+		switch {
+		case isFailed(instance):
+			members.failed = append(members.failed, instance)
+		case isWarm(instance):
+			members.warm = append(members.warm, instance)
+		case healthyStates[instance.State]:
+			members.ready = append(members.ready, instance)
+		case instance.State == StateProvisioning && (timeout <= 0 || now.Sub(instance.Created) < timeout):
+			members.provisioning = append(members.provisioning, instance)
+		case instance.State == StateDeleted:
+			members.terminating = append(members.terminating, instance)
+		case instance.State == StateProvisioning || unhealthyStates[instance.State]:
+			members.unhealthy = append(members.unhealthy, instance)
+		default:
+			members.provisioning = append(members.provisioning, instance)
+		}
+	}
+
+	return members
+}
+
+// capacity returns the number of members counted toward the expected size.
+func (m *groupMembers) capacity() int {
+	return len(m.ready) + len(m.provisioning)
+}
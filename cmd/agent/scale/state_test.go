@@ -0,0 +1,89 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"testing"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestClassifyMembers(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   string
+		age     time.Duration
+		tags    map[string]interface{}
+		timeout time.Duration
+		healthy []string
+		want    string
+	}{
+		{name: "running", state: StateRunning, want: "ready"},
+		{name: "configured healthy state", state: "stopped", healthy: []string{StateRunning, "stopped"}, want: "ready"},
+		{name: "provisioning", state: StateProvisioning, age: time.Minute, want: "provisioning"},
+		{name: "provisioning too long", state: StateProvisioning, age: time.Hour, want: "unhealthy"},
+		{name: "provisioning without timeout", state: StateProvisioning, age: time.Hour, timeout: -1, want: "provisioning"},
+		{name: "stopping", state: StateStopping, age: time.Hour, want: "provisioning"},
+		{name: "unknown state", state: "migrating", age: time.Hour, want: "provisioning"},
+		{name: "deleted", state: StateDeleted, want: "terminating"},
+		{name: "stopped", state: "stopped", want: "unhealthy"},
+		{name: "failed", state: "failed", want: "unhealthy"},
+		{name: "offline", state: "offline", want: "unhealthy"},
+		{name: "incomplete", state: "incomplete", want: "unhealthy"},
+		{name: "warm", state: "stopped", tags: map[string]interface{}{poolTag: poolWarm}, want: "warm"},
+		{name: "warm while stopping", state: StateStopping, tags: map[string]interface{}{poolTag: poolWarm}, want: "warm"},
+		{name: "failed launch", state: StateRunning, tags: map[string]interface{}{statusTag: statusFailed}, want: "failed"},
+		{name: "failed launch in warm pool", state: "stopped", tags: map[string]interface{}{statusTag: statusFailed, poolTag: poolWarm}, want: "failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer viper.Reset()
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = 15 * time.Minute
+			}
+			viper.Set(iconfig.KeyInstanceProvisioning, timeout)
+			viper.Set(iconfig.KeyInstanceState, tt.healthy)
+
+			instance := &tcc.Instance{
+				ID:      "member",
+				State:   tt.state,
+				Created: time.Now().Add(-tt.age),
+				Tags:    tt.tags,
+			}
+			members := classifyMembers([]*tcc.Instance{instance})
+
+			got := map[string][]*tcc.Instance{
+				"ready":        members.ready,
+				"provisioning": members.provisioning,
+				"terminating":  members.terminating,
+				"unhealthy":    members.unhealthy,
+				"warm":         members.warm,
+				"failed":       members.failed,
+			}
+			for class, instances := range got {
+				if want := class == tt.want; (len(instances) == 1) != want {
+					t.Errorf("%s members = %v, want the member classified %s", class, instanceIDs(instances), tt.want)
+				}
+			}
+
+			wantCapacity := 0
+			if tt.want == "ready" || tt.want == "provisioning" {
+				wantCapacity = 1
+			}
+			if got := members.capacity(); got != wantCapacity {
+				t.Errorf("capacity = %d, want %d", got, wantCapacity)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	poolTag      = "tsg.pool"
+	poolSinceTag = "tsg.pool-since"
+	poolWarm     = "warm"
+)
+
+// isWarm reports whether an instance has been stopped into the warm pool.
+func isWarm(instance *tcc.Instance) bool {
+	v, ok := instance.Tags[poolTag].(string)
+	return ok && v == poolWarm
+}
+
+// pooledSince returns when an instance was stopped into the warm pool,
+// falling back to its last update time if the tag is missing or invalid.
+func pooledSince(instance *tcc.Instance) time.Time {
+	var raw string
+	switch v := instance.Tags[poolSinceTag].(type) {
+	case string:
+		raw = v
+	case float64:
+		return time.Unix(int64(v), 0)
+	}
+
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(secs, 0)
+	}
+
+	return instance.Updated
+}
+
+// planWarmPool decides which warm members are started to satisfy scaleCount
+// and which are deleted because they are older than the configured max age
+// or no longer fit in the pool. It returns the members to start, the members
+// to expire and the members left in the pool.
+func planWarmPool(warm []*tcc.Instance, scaleCount int) (start, expire, kept []*tcc.Instance) {
+	maxAge := config.GetWarmPoolMaxAge()
+	now := time.Now()
+
+	// Most recently pooled members are started first, since they are the
+	// least likely to be close to their max age.
+	ordered := make([]*tcc.Instance, len(warm))
+	copy(ordered, warm)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return pooledSince(ordered[i]).After(pooledSince(ordered[j]))
+	})
+
+	var fresh []*tcc.Instance
+	for _, instance := range ordered {
+		if maxAge > 0 && now.Sub(pooledSince(instance)) > maxAge {
+			expire = append(expire, instance)
+			continue
+		}
+		fresh = append(fresh, instance)
+	}
+
+	if scaleCount > 0 {
+		n := minInt(scaleCount, len(fresh))
+		start, fresh = fresh[:n], fresh[n:]
+	}
+
+	if excess := len(fresh) - config.GetWarmPoolSize(); excess > 0 {
+		kept = fresh[:len(fresh)-excess]
+		expire = append(expire, fresh[len(fresh)-excess:]...)
+	} else {
+		kept = fresh
+	}
+
+	return start, expire, kept
+}
+
+// stopToPool stops instances and tags them as warm instead of deleting them.
+// An instance is only tagged once CloudAPI reports it stopped, so that an
+// instance that failed to stop is never counted as warm while it is still
+// running. An instance that was stopped but could not be tagged is started
+// again, since the next run would otherwise find it stopped and untagged and
+// replace it as unhealthy.
+func (c *AgentComputeClient) stopToPool(ctx context.Context, plan *Plan, instances []*tcc.Instance) error {
+	results := forEachParallel(ctx, len(instances), config.GetScaleParallelism(), func(i int) *actionResult {
+		instance := instances[i]
+
+		err := runHook(ctx, plan, HookPreTerminate, "stop", instance)
+		if err != nil {
+			return c.stopFailed(plan, instance, err)
+		}
+
+		err = c.instances().Stop(ctx, &tcc.StopInstanceInput{
+			InstanceID: instance.ID,
+		})
+		if err != nil {
+			return c.stopFailed(plan, instance, err)
+		}
+
+		err = c.waitStopped(ctx, instance.ID)
+		if err == nil {
+			err = c.instances().AddTags(ctx, &tcc.AddTagsInput{
+				ID: instance.ID,
+				Tags: map[string]string{
+					poolTag:      poolWarm,
+					poolSinceTag: strconv.FormatInt(time.Now().Unix(), 10),
+				},
+			})
+		}
+		if err != nil {
+			if serr := c.restartUnpooled(instance); serr != nil {
+				log.Error().
+					Str("account_name", plan.AccountName).
+					Str("tsg_name", plan.TsgName).
+					Str("status", "failed").
+					Str("notification_type", "TSG_INSTANCE_WARM_POOL_STOP_ERROR").
+					Str("description", fmt.Sprintf("Error starting instance %s again after it could not be stopped into the warm pool", instance.ID)).
+					Err(serr).
+					Msg("An instance that could not be added to the warm pool is left stopped")
+			}
+			return c.stopFailed(plan, instance, err)
+		}
+
+		log.Info().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "successful").
+			Str("notification_type", "TSG_INSTANCE_WARM_POOL_STOP").
+			Str("description", fmt.Sprintf("Stopping instance %s into the warm pool", instance.ID)).
+			Msgf("An instance was stopped into the warm pool due to a difference between the expected and actual instance count")
+
+		if err := runHook(ctx, plan, HookPostTerminate, "stop", instance); err != nil {
+			return &actionResult{Instance: instance, Err: err}
+		}
+
+		return &actionResult{Instance: instance}
+	})
+
+	return summarizeResults(ctx, plan, "stop", results)
+}
+
+// stopFailed logs that an instance could not be stopped into the warm pool.
+func (c *AgentComputeClient) stopFailed(plan *Plan, instance *tcc.Instance, err error) *actionResult {
+	log.Error().
+		Str("account_name", plan.AccountName).
+		Str("tsg_name", plan.TsgName).
+		Str("status", "failed").
+		Str("notification_type", "TSG_INSTANCE_WARM_POOL_STOP_ERROR").
+		Str("description", fmt.Sprintf("Error stopping instance %s into the warm pool", instance.ID)).
+		Err(err).
+		Msg("An instance could not be stopped into the warm pool")
+	return &actionResult{Instance: instance, Err: err}
+}
+
+// waitStopped waits for an instance to be stopped, since a stop only asks
+// CloudAPI to stop it. It polls like the readiness poll and gives up after
+// the ready timeout.
+func (c *AgentComputeClient) waitStopped(ctx context.Context, instanceID string) error {
+	ctx, cancel := context.WithTimeout(ctx, config.GetReadyTimeout())
+	defer cancel()
+
+	ticker := time.NewTicker(config.GetReadyPollInterval())
+	defer ticker.Stop()
+
+	for {
+		instance, err := c.instances().Get(ctx, &tcc.GetInstanceInput{
+			ID: instanceID,
+		})
+		if isGone(err) {
+			return fmt.Errorf("instance %q was deleted while it was stopping", instanceID)
+		}
+		if err != nil {
+			return err
+		}
+
+		switch instance.State {
+		case "stopped":
+			return nil
+		case "failed", "deleted":
+			return fmt.Errorf("instance %q is %s instead of stopped", instanceID, instance.State)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "stopped waiting for %q to stop", instanceID)
+		case <-ticker.C:
+		}
+	}
+}
+
+// restartUnpooled starts an instance that was stopped but not added to the
+// warm pool. Like leavePool, it runs with its own deadline so that the
+// instance is still started when a run is cancelled while it stops.
+func (c *AgentComputeClient) restartUnpooled(instance *tcc.Instance) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	return c.instances().Start(ctx, &tcc.StartInstanceInput{
+		InstanceID: instance.ID,
+	})
+}
+
+// startFromPool starts warm instances, waits for them to be running and
+// removes them from the pool. An instance that was started but did not
+// become ready is removed from the pool as well, so that the next run sees
+// its real state and replaces it if it is not running rather than counting
+// it as warm.
+func (c *AgentComputeClient) startFromPool(ctx context.Context, plan *Plan, instances []*tcc.Instance) error {
+	results := forEachParallel(ctx, len(instances), config.GetScaleParallelism(), func(i int) *actionResult {
+		instance := instances[i]
+
+		err := runHook(ctx, plan, HookPreLaunch, "start", instance)
+		if err != nil {
+			return c.startFailed(plan, instance, err)
+		}
+
+		err = c.instances().Start(ctx, &tcc.StartInstanceInput{
+			InstanceID: instance.ID,
+		})
+		if err == nil {
+			_, err = c.waitReady(ctx, instance.ID)
+		}
+		if err != nil {
+			if terr := c.leavePool(instance); terr != nil {
+				log.Error().
+					Str("account_name", plan.AccountName).
+					Str("tsg_name", plan.TsgName).
+					Str("status", "failed").
+					Str("notification_type", "TSG_INSTANCE_WARM_POOL_START_ERROR").
+					Str("description", fmt.Sprintf("Error removing instance %s from the warm pool", instance.ID)).
+					Err(terr).
+					Msg("A warm instance that could not be started is still tagged as warm")
+			}
+			return c.startFailed(plan, instance, err)
+		}
+
+		if err := c.leavePool(instance); err != nil {
+			return c.startFailed(plan, instance, err)
+		}
+
+		if err := runHook(ctx, plan, HookPostLaunch, "start", instance); err != nil {
+			return c.startFailed(plan, instance, err)
+		}
+
+		log.Info().
+			Str("account_name", plan.AccountName).
+			Str("tsg_name", plan.TsgName).
+			Str("status", "successful").
+			Str("notification_type", "TSG_INSTANCE_WARM_POOL_START").
+			Str("description", fmt.Sprintf("Starting warm instance %s", instance.ID)).
+			Msgf("A warm instance was started due to a difference between the expected and actual instance count")
+
+		return &actionResult{Instance: instance}
+	})
+
+	return summarizeResults(ctx, plan, "start", results)
+}
+
+// startFailed logs that a warm instance could not be started.
+func (c *AgentComputeClient) startFailed(plan *Plan, instance *tcc.Instance, err error) *actionResult {
+	log.Error().
+		Str("account_name", plan.AccountName).
+		Str("tsg_name", plan.TsgName).
+		Str("status", "failed").
+		Str("notification_type", "TSG_INSTANCE_WARM_POOL_START_ERROR").
+		Str("description", fmt.Sprintf("Error starting warm instance %s", instance.ID)).
+		Err(err).
+		Msg("A warm instance could not be started")
+	return &actionResult{Instance: instance, Err: err}
+}
+
+// leavePool removes the warm pool tags from an instance. Like cleaning up a
+// failed launch, it runs with its own deadline so that the tags are still
+// removed when a run is cancelled while the instance starts.
+func (c *AgentComputeClient) leavePool(instance *tcc.Instance) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	for _, key := range []string{poolTag, poolSinceTag} {
+		err := c.instances().DeleteTag(ctx, &tcc.DeleteTagInput{
+			ID:  instance.ID,
+			Key: key,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error removing instance %s from the warm pool", instance.ID)
+		}
+	}
+
+	return nil
+}
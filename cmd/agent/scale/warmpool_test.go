@@ -0,0 +1,211 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package scale
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	tcc "github.com/joyent/triton-go/compute"
+	iconfig "github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/viper"
+)
+
+func TestPlanWarmPool(t *testing.T) {
+	// Each member was pooled the given number of minutes ago.
+	warm := func(ages ...int) []*tcc.Instance {
+		var instances []*tcc.Instance
+		for i, age := range ages {
+			instances = append(instances, &tcc.Instance{
+				ID: strconv.Itoa(i),
+				Tags: map[string]interface{}{
+					poolTag:      poolWarm,
+					poolSinceTag: strconv.FormatInt(time.Now().Add(-time.Duration(age)*time.Minute).Unix(), 10),
+				},
+			})
+		}
+		return instances
+	}
+
+	tests := []struct {
+		name       string
+		warm       []*tcc.Instance
+		scaleCount int
+		size       int
+		maxAge     time.Duration
+		wantStart  []string
+		wantExpire []string
+		wantKept   []string
+	}{
+		{name: "kept", warm: warm(10, 20), size: 2, wantKept: []string{"0", "1"}},
+		{name: "most recently pooled kept", warm: warm(30, 10, 20), size: 2, wantExpire: []string{"0"}, wantKept: []string{"1", "2"}},
+		{name: "most recently pooled started", warm: warm(30, 10, 20), scaleCount: 2, size: 3, wantStart: []string{"1", "2"}, wantKept: []string{"0"}},
+		{name: "start all", warm: warm(10, 20), scaleCount: 5, size: 2, wantStart: []string{"0", "1"}},
+		{name: "scale-in", warm: warm(10), scaleCount: -2, size: 1, wantKept: []string{"0"}},
+		{name: "max age", warm: warm(10, 90, 20), size: 3, maxAge: time.Hour, wantExpire: []string{"1"}, wantKept: []string{"0", "2"}},
+		{name: "expired not started", warm: warm(90, 10), scaleCount: 2, size: 2, maxAge: time.Hour, wantStart: []string{"1"}, wantExpire: []string{"0"}},
+		{name: "no pool", warm: warm(10), wantExpire: []string{"0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer viper.Reset()
+			viper.Set(iconfig.KeyWarmPoolSize, tt.size)
+			viper.Set(iconfig.KeyWarmPoolMaxAge, tt.maxAge)
+
+			start, expire, kept := planWarmPool(tt.warm, tt.scaleCount)
+
+			for _, got := range []struct {
+				name string
+				ids  []string
+				want []string
+			}{
+				{"start", instanceIDs(start), tt.wantStart},
+				{"expire", instanceIDs(expire), tt.wantExpire},
+				{"kept", instanceIDs(kept), tt.wantKept},
+			} {
+				if len(got.ids) == 0 && len(got.want) == 0 {
+					continue
+				}
+				if !reflect.DeepEqual(got.ids, got.want) {
+					t.Errorf("%s = %v, want %v", got.name, got.ids, got.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStopToPool(t *testing.T) {
+	tests := []struct {
+		name      string
+		stopFail  bool
+		polls     int
+		tagFail   bool
+		wantErr   bool
+		wantWarm  bool
+		wantState string
+	}{
+		{name: "stopped", wantWarm: true, wantState: "stopped"},
+		{name: "slow stop", polls: 3, wantWarm: true, wantState: "stopped"},
+		{name: "stop fails", stopFail: true, wantErr: true, wantState: StateRunning},
+		{name: "tag fails", tagFail: true, wantErr: true, wantState: StateRunning},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			member := api.addMembers("web", 1)[0]
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyInstanceReadyPoll, 10*time.Millisecond)
+			viper.Set(iconfig.KeyInstanceReadyTimeout, 5*time.Second)
+
+			// The instance is stopping until it has been looked up polls
+			// times, and records the state it was tagged in.
+			var stopFailed bool
+			var polls int
+			var taggedIn string
+			api.stopState = "stopping"
+			api.intercept = func(r *http.Request) int {
+				api.mu.Lock()
+				defer api.mu.Unlock()
+
+				instance := api.instances[member.ID]
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/acct/machines/"+member.ID:
+					if tt.stopFail && !stopFailed {
+						stopFailed = true
+						return http.StatusConflict
+					}
+				case r.Method == http.MethodGet && r.URL.Path == "/acct/machines/"+member.ID:
+					if polls++; instance.State == "stopping" && polls > tt.polls {
+						instance.State = "stopped"
+					}
+				case r.URL.Path == "/acct/machines/"+member.ID+"/tags":
+					taggedIn = instance.State
+					if tt.tagFail {
+						return http.StatusInternalServerError
+					}
+				}
+				return 0
+			}
+
+			plan := &Plan{AccountName: testAccount, TsgName: "web"}
+			err := c.stopToPool(context.Background(), plan, []*tcc.Instance{member})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stopToPool = %v, want error %v", err, tt.wantErr)
+			}
+
+			instance := api.instance(member.ID)
+			if isWarm(instance) != tt.wantWarm {
+				t.Errorf("instance in state %q has tags %v, want warm %v", instance.State, instance.Tags, tt.wantWarm)
+			}
+			if instance.State != tt.wantState {
+				t.Errorf("instance is %q, want %q", instance.State, tt.wantState)
+			}
+			if taggedIn != "" && taggedIn != "stopped" {
+				t.Errorf("instance was tagged while %q, want it stopped first", taggedIn)
+			}
+		})
+	}
+}
+
+func TestStartFromPool(t *testing.T) {
+	tests := []struct {
+		name      string
+		startFail bool
+		readyFail bool
+		wantState string
+	}{
+		{name: "started", wantState: StateRunning},
+		{name: "start fails", startFail: true, wantState: "stopped"},
+		{name: "never ready", readyFail: true, wantState: "failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeCloudAPI()
+			member := api.addMembers("web", 1)[0]
+			member.State = "stopped"
+			member.Tags[poolTag] = poolWarm
+			member.Tags[poolSinceTag] = strconv.FormatInt(time.Now().Unix(), 10)
+
+			c := newTestClient(t, api, "web")
+			viper.Set(iconfig.KeyInstanceReadyPoll, 10*time.Millisecond)
+			switch {
+			case tt.startFail:
+				api.failFirst("POST", "/acct/machines/"+member.ID, http.StatusConflict, false)
+			case tt.readyFail:
+				api.provisionAfter(member.ID, 1, true)
+			}
+
+			plan := &Plan{AccountName: testAccount, TsgName: "web"}
+			err := c.startFromPool(context.Background(), plan, []*tcc.Instance{member})
+			if wantErr := tt.startFail || tt.readyFail; (err != nil) != wantErr {
+				t.Fatalf("startFromPool = %v, want error %v", err, wantErr)
+			}
+
+			// Whether or not it started, the instance has left the pool and
+			// is accounted for by its state.
+			instance := api.instance(member.ID)
+			if _, ok := instance.Tags[poolTag]; ok {
+				t.Errorf("instance is still tagged %s", poolTag)
+			}
+			if _, ok := instance.Tags[poolSinceTag]; ok {
+				t.Errorf("instance is still tagged %s", poolSinceTag)
+			}
+			if instance.State != tt.wantState {
+				t.Errorf("instance is %q, want %q", instance.State, tt.wantState)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchLimit bounds how far Next and Prev look for a matching time. An
+// expression such as "0 0 29 2 *" only matches every four years.
+const searchLimit = 5 * 366 * 24 * time.Hour
+
+// Cron is a parsed five-field cron expression: minute, hour, day of month,
+// month and day of week.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar record a "*" day field. As in cron(8), when both
+	// day fields are restricted a time matches if either of them does.
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	domField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression such as "0 8 * * mon-fri" or one of
+// the macros "@hourly", "@daily", "@weekly", "@monthly" and "@yearly".
+func ParseCron(expr string) (*Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	c := &Cron{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&c.minute, minuteField},
+		{&c.hour, hourField},
+		{&c.dom, domField},
+		{&c.month, monthField},
+		{&c.dow, dowField},
+	} {
+		if *f.bits, err = f.field.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+	}
+
+	// Both 0 and 7 mean Sunday.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+// parse returns the set of values a field matches as a bit mask.
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(s, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %s field %q", f.name, part)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		var lo, hi int
+		switch {
+		case rangePart == "*":
+			lo, hi = f.min, f.max
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range in %s field %q", f.name, part)
+			}
+		default:
+			var err error
+			if lo, err = f.value(rangePart); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if step > 1 {
+				// "5/15" means every 15 starting at 5.
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", f.name, s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s %d out of range %d-%d", f.name, v, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time after t that matches, in t's location, or
+// the zero time if there is none within a few years. Times are matched
+// against the wall clock of t's location: a time skipped when clocks go
+// forward matches as the clocks change, and a time repeated when they go
+// back only matches the first time round.
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	w := wallClock(t)
+	limit := w.Add(searchLimit)
+
+	for {
+		if w = c.nextWall(w, limit); w.IsZero() {
+			return time.Time{}
+		}
+		if at := resolve(w, loc); at.After(t) {
+			return at
+		}
+	}
+}
+
+// Prev returns the last time at or before t that matches, in t's location,
+// or the zero time if there is none within a few years. Times are matched
+// as they are by Next.
+func (c *Cron) Prev(t time.Time) time.Time {
+	loc := t.Location()
+	limit := wallClock(t).Add(-searchLimit)
+
+	// When t is in an hour repeated by clocks going back, a later wall
+	// clock time may have matched the first time round, before t.
+	w := wallClock(t).Add(maxClockChange)
+	for {
+		if w = c.prevWall(w, limit); w.IsZero() {
+			return time.Time{}
+		}
+		if at := resolve(w, loc); !at.After(t) {
+			return at
+		}
+		w = w.Add(-time.Minute)
+	}
+}
+
+// nextWall returns the first wall clock time after w that matches. Wall
+// clock times are held in UTC, which has no daylight saving time.
+func (c *Cron) nextWall(w, limit time.Time) time.Time {
+	w = w.Add(time.Minute)
+	for w.Before(limit) {
+		y, m, d := w.Date()
+
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			w = time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(w):
+			w = time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(w.Hour())) == 0:
+			w = time.Date(y, m, d, w.Hour()+1, 0, 0, 0, time.UTC)
+		case c.minute&(1<<uint(w.Minute())) == 0:
+			w = w.Add(time.Minute)
+		default:
+			return w
+		}
+	}
+
+	return time.Time{}
+}
+
+// prevWall returns the last wall clock time at or before w that matches.
+func (c *Cron) prevWall(w, limit time.Time) time.Time {
+	for w.After(limit) {
+		y, m, d := w.Date()
+
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			w = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+		case !c.dayMatches(w):
+			w = time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+		case c.hour&(1<<uint(w.Hour())) == 0:
+			w = time.Date(y, m, d, w.Hour(), 0, 0, 0, time.UTC).Add(-time.Minute)
+		case c.minute&(1<<uint(w.Minute())) == 0:
+			w = w.Add(-time.Minute)
+		default:
+			return w
+		}
+	}
+
+	return time.Time{}
+}
+
+// maxClockChange is the most clocks are moved by a daylight saving time
+// change.
+const maxClockChange = 2 * time.Hour
+
+// wallClock returns the wall clock time of t, to the minute, in UTC.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// resolve returns the earliest time in loc whose wall clock is at or after
+// the wall clock time w: the first of the two times it happens when clocks
+// go back, or the moment clocks change when they skip it going forward.
+// time.Date does not say which of these it picks, so the neighbouring
+// times are checked.
+func resolve(w time.Time, loc *time.Location) time.Time {
+	at := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), 0, 0, loc)
+
+	if wallClock(at).Equal(w) {
+		for d := 30 * time.Minute; d <= maxClockChange; d += 30 * time.Minute {
+			if earlier := at.Add(-d); wallClock(earlier).Equal(w) {
+				return earlier
+			}
+		}
+		return at
+	}
+
+	// w was skipped. The clocks changed at the first minute, starting
+	// from before the change, whose wall clock is past w.
+	at = at.Add(-2 * maxClockChange)
+	for wallClock(at).Before(w) {
+		at = at.Add(time.Minute)
+	}
+	return at
+}
@@ -0,0 +1,185 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func utc(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"10-5 * * * *",
+		"* * * foo *",
+		"* * * * mon-",
+		"@every",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{name: "every minute", expr: "* * * * *", from: utc(2018, 1, 1, 10, 7), want: utc(2018, 1, 1, 10, 8)},
+		{name: "seconds are dropped", expr: "* * * * *", from: utc(2018, 1, 1, 10, 7).Add(30 * time.Second), want: utc(2018, 1, 1, 10, 8)},
+		{name: "step", expr: "*/15 * * * *", from: utc(2018, 1, 1, 10, 7), want: utc(2018, 1, 1, 10, 15)},
+		{name: "step from value", expr: "5/20 * * * *", from: utc(2018, 1, 1, 10, 26), want: utc(2018, 1, 1, 10, 45)},
+		{name: "step over range", expr: "0 8-18/4 * * *", from: utc(2018, 1, 1, 12, 1), want: utc(2018, 1, 1, 16, 0)},
+		{name: "range", expr: "0 8-10 * * *", from: utc(2018, 1, 1, 10, 30), want: utc(2018, 1, 2, 8, 0)},
+		{name: "list", expr: "0 0 1,15 * *", from: utc(2018, 1, 2, 0, 0), want: utc(2018, 1, 15, 0, 0)},
+		{name: "list of ranges", expr: "0 0 * * mon-tue,fri", from: utc(2018, 1, 3, 0, 0), want: utc(2018, 1, 5, 0, 0)},
+		{name: "month names", expr: "0 0 1 jan,JUL *", from: utc(2018, 2, 1, 0, 0), want: utc(2018, 7, 1, 0, 0)},
+		{name: "day names", expr: "0 9 * * Mon-Fri", from: utc(2018, 1, 6, 12, 0), want: utc(2018, 1, 8, 9, 0)},
+		{name: "0 is Sunday", expr: "0 0 * * 0", from: utc(2018, 1, 1, 0, 0), want: utc(2018, 1, 7, 0, 0)},
+		{name: "7 is Sunday", expr: "0 0 * * 7", from: utc(2018, 1, 1, 0, 0), want: utc(2018, 1, 7, 0, 0)},
+		{name: "range to 7", expr: "0 0 * * 6-7", from: utc(2018, 1, 1, 0, 0), want: utc(2018, 1, 6, 0, 0)},
+		{name: "day of month only", expr: "0 0 13 * *", from: utc(2018, 1, 1, 0, 0), want: utc(2018, 1, 13, 0, 0)},
+		{name: "day of week only", expr: "0 0 * * fri", from: utc(2018, 1, 1, 0, 0), want: utc(2018, 1, 5, 0, 0)},
+		{name: "both days, day of week matches", expr: "0 0 13 * fri", from: utc(2018, 1, 1, 0, 0), want: utc(2018, 1, 5, 0, 0)},
+		{name: "both days, day of month matches", expr: "0 0 13 * fri", from: utc(2018, 1, 12, 1, 0), want: utc(2018, 1, 13, 0, 0)},
+		{name: "starred day of month", expr: "0 0 */2 * fri", from: utc(2018, 1, 6, 0, 0), want: utc(2018, 1, 19, 0, 0)},
+		{name: "hourly", expr: "@hourly", from: utc(2018, 1, 1, 10, 7), want: utc(2018, 1, 1, 11, 0)},
+		{name: "daily", expr: "@daily", from: utc(2018, 1, 1, 10, 7), want: utc(2018, 1, 2, 0, 0)},
+		{name: "weekly", expr: "@weekly", from: utc(2018, 1, 1, 10, 7), want: utc(2018, 1, 7, 0, 0)},
+		{name: "monthly", expr: "@monthly", from: utc(2018, 1, 1, 10, 7), want: utc(2018, 2, 1, 0, 0)},
+		{name: "yearly", expr: "@yearly", from: utc(2018, 1, 1, 10, 7), want: utc(2019, 1, 1, 0, 0)},
+		{name: "leap day", expr: "0 0 29 2 *", from: utc(2018, 3, 1, 0, 0), want: utc(2020, 2, 29, 0, 0)},
+		{name: "leap day within the limit", expr: "0 0 29 2 *", from: utc(2101, 3, 1, 0, 0), want: utc(2104, 2, 29, 0, 0)},
+		{name: "leap day beyond the limit", expr: "0 0 29 2 *", from: utc(2196, 3, 1, 0, 0)},
+		{name: "never", expr: "0 0 30 2 *", from: utc(2018, 1, 1, 0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronPrev(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{name: "at", expr: "0 8 * * *", from: utc(2018, 1, 2, 8, 0), want: utc(2018, 1, 2, 8, 0)},
+		{name: "seconds are dropped", expr: "0 8 * * *", from: utc(2018, 1, 2, 8, 0).Add(30 * time.Second), want: utc(2018, 1, 2, 8, 0)},
+		{name: "earlier today", expr: "0 8 * * *", from: utc(2018, 1, 2, 12, 0), want: utc(2018, 1, 2, 8, 0)},
+		{name: "yesterday", expr: "0 8 * * *", from: utc(2018, 1, 2, 7, 59), want: utc(2018, 1, 1, 8, 0)},
+		{name: "step", expr: "*/15 * * * *", from: utc(2018, 1, 1, 10, 7), want: utc(2018, 1, 1, 10, 0)},
+		{name: "day names", expr: "0 9 * * mon-fri", from: utc(2018, 1, 7, 12, 0), want: utc(2018, 1, 5, 9, 0)},
+		{name: "7 is Sunday", expr: "0 0 * * 7", from: utc(2018, 1, 10, 0, 0), want: utc(2018, 1, 7, 0, 0)},
+		{name: "both days", expr: "0 0 13 * fri", from: utc(2018, 1, 4, 0, 0), want: utc(2017, 12, 29, 0, 0)},
+		{name: "previous year", expr: "0 0 1 jan *", from: utc(2018, 6, 1, 0, 0), want: utc(2018, 1, 1, 0, 0)},
+		{name: "leap day", expr: "0 0 29 2 *", from: utc(2018, 3, 1, 0, 0), want: utc(2016, 2, 29, 0, 0)},
+		{name: "leap day beyond the limit", expr: "0 0 29 2 *", from: utc(2203, 3, 1, 0, 0)},
+		{name: "never", expr: "0 0 30 2 *", from: utc(2018, 1, 1, 0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Prev(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Prev(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronDaylightSavingTime(t *testing.T) {
+	// New York skipped from 02:00 to 03:00 on 11 March 2018 and repeated
+	// 01:00 to 02:00 on 4 November 2018. London did the same from 01:00 on
+	// 25 March and 28 October 2018. Times are given in UTC.
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		loc  *time.Location
+		prev bool
+		from time.Time
+		want time.Time
+	}{
+		{name: "after a skipped hour", expr: "0 4 * * *", loc: newYork, from: utc(2018, 3, 11, 5, 0), want: utc(2018, 3, 11, 8, 0)},
+		{name: "skipped time fires as clocks change", expr: "30 2 * * *", loc: newYork, from: utc(2018, 3, 11, 5, 0), want: utc(2018, 3, 11, 7, 0)},
+		{name: "skipped time fires once", expr: "*/20 2 * * *", loc: newYork, from: utc(2018, 3, 11, 7, 0), want: utc(2018, 3, 12, 6, 0)},
+		{name: "after skipped time", expr: "30 2 * * *", loc: newYork, from: utc(2018, 3, 11, 7, 0), want: utc(2018, 3, 12, 6, 30)},
+		{name: "skipped time fired", expr: "30 2 * * *", loc: newYork, prev: true, from: utc(2018, 3, 11, 13, 0), want: utc(2018, 3, 11, 7, 0)},
+		{name: "skipped time, London", expr: "30 1 * * *", loc: london, from: utc(2018, 3, 25, 0, 0), want: utc(2018, 3, 25, 1, 0)},
+		{name: "repeated time fires the first time", expr: "30 1 * * *", loc: newYork, from: utc(2018, 11, 4, 4, 0), want: utc(2018, 11, 4, 5, 30)},
+		{name: "repeated time fires once", expr: "30 1 * * *", loc: newYork, from: utc(2018, 11, 4, 5, 30), want: utc(2018, 11, 5, 6, 30)},
+		{name: "repeated time fired", expr: "30 1 * * *", loc: newYork, prev: true, from: utc(2018, 11, 4, 6, 15), want: utc(2018, 11, 4, 5, 30)},
+		{name: "repeated time not yet fired", expr: "30 1 * * *", loc: newYork, prev: true, from: utc(2018, 11, 4, 5, 15), want: utc(2018, 11, 3, 5, 30)},
+		{name: "after a repeated hour", expr: "0 2 * * *", loc: newYork, from: utc(2018, 11, 4, 4, 0), want: utc(2018, 11, 4, 7, 0)},
+		{name: "repeated time, London", expr: "30 1 * * *", loc: london, from: utc(2018, 10, 27, 23, 0), want: utc(2018, 10, 28, 0, 30)},
+		{name: "repeated time fires once, London", expr: "30 1 * * *", loc: london, from: utc(2018, 10, 28, 0, 30), want: utc(2018, 10, 29, 1, 30)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			from := tt.from.In(tt.loc)
+			var got time.Time
+			if tt.prev {
+				got = c.Prev(from)
+			} else {
+				got = c.Next(from)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("from %s got %s, want %s", from, got, tt.want.In(tt.loc))
+			}
+			if got.Location() != tt.loc {
+				t.Errorf("got a time in %s, want %s", got.Location(), tt.loc)
+			}
+		})
+	}
+}
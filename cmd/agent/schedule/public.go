@@ -0,0 +1,142 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+// Package schedule evaluates the scheduled scaling actions of a group.
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// Schedule sets the size of a group from the time its cron expression fires
+// until another schedule of the group fires.
+type Schedule struct {
+	Name     string `json:"name"`
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone,omitempty"`
+
+	// Count, when set, replaces the expected instance count. MinCount and
+	// MaxCount, when not 0, bound the count computed by an autoscaling
+	// policy.
+	Count    *int `json:"count,omitempty"`
+	MinCount int  `json:"min_count,omitempty"`
+	MaxCount int  `json:"max_count,omitempty"`
+}
+
+// Validate checks the cron expression, timezone and counts of s.
+func (s *Schedule) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("schedule name is required")
+	}
+	if _, err := ParseCron(s.Cron); err != nil {
+		return err
+	}
+	if _, err := s.location(); err != nil {
+		return err
+	}
+
+	switch {
+	case s.Count == nil && s.MinCount == 0 && s.MaxCount == 0:
+		return fmt.Errorf("schedule %q sets no count, minimum or maximum", s.Name)
+	case s.Count != nil && *s.Count < 0:
+		return fmt.Errorf("schedule %q count must not be negative", s.Name)
+	case s.MinCount < 0 || s.MaxCount < 0:
+		return fmt.Errorf("schedule %q minimum and maximum must not be negative", s.Name)
+	case s.MaxCount > 0 && s.MaxCount < s.MinCount:
+		return fmt.Errorf("schedule %q maximum %d is less than its minimum %d", s.Name, s.MaxCount, s.MinCount)
+	}
+
+	return nil
+}
+
+func (s *Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %q has an unknown timezone %q", s.Name, s.Timezone)
+	}
+
+	return loc, nil
+}
+
+// Next returns the next n times after t at which s fires, in its timezone.
+func (s *Schedule) Next(t time.Time, n int) ([]time.Time, error) {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := s.location()
+	if err != nil {
+		return nil, err
+	}
+
+	var times []time.Time
+	for t = t.In(loc); len(times) < n; {
+		if t = cron.Next(t); t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+
+	return times, nil
+}
+
+// Prev returns the last time at or before t at which s fired, in its
+// timezone, or the zero time if it has not fired in years.
+func (s *Schedule) Prev(t time.Time) (time.Time, error) {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc, err := s.location()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cron.Prev(t.In(loc)), nil
+}
+
+// Clamp bounds count by the minimum and maximum of s.
+func (s *Schedule) Clamp(count int) int {
+	if count < s.MinCount {
+		count = s.MinCount
+	}
+	if s.MaxCount > 0 && count > s.MaxCount {
+		count = s.MaxCount
+	}
+
+	return count
+}
+
+// Active returns the schedule that fired most recently at or before t, and
+// when it fired, or nil if none of them has fired.
+func Active(schedules []*Schedule, t time.Time) (*Schedule, time.Time, error) {
+	var (
+		active *Schedule
+		fired  time.Time
+	)
+
+	for _, s := range schedules {
+		prev, err := s.Prev(t)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if !prev.IsZero() && prev.After(fired) {
+			active, fired = s, prev
+		}
+	}
+
+	return active, fired, nil
+}
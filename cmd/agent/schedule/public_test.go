@@ -0,0 +1,117 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func count(n int) *int {
+	return &n
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		wantErr  bool
+	}{
+		{name: "count", schedule: Schedule{Name: "s", Cron: "0 8 * * *", Count: count(3)}},
+		{name: "zero count", schedule: Schedule{Name: "s", Cron: "0 8 * * *", Count: count(0)}},
+		{name: "bounds", schedule: Schedule{Name: "s", Cron: "0 8 * * *", MinCount: 2, MaxCount: 5}},
+		{name: "timezone", schedule: Schedule{Name: "s", Cron: "0 8 * * *", Timezone: "Europe/London", Count: count(3)}},
+		{name: "no name", schedule: Schedule{Cron: "0 8 * * *", Count: count(3)}, wantErr: true},
+		{name: "bad cron", schedule: Schedule{Name: "s", Cron: "0 8 * *", Count: count(3)}, wantErr: true},
+		{name: "bad timezone", schedule: Schedule{Name: "s", Cron: "0 8 * * *", Timezone: "Nowhere/Town", Count: count(3)}, wantErr: true},
+		{name: "no counts", schedule: Schedule{Name: "s", Cron: "0 8 * * *"}, wantErr: true},
+		{name: "negative count", schedule: Schedule{Name: "s", Cron: "0 8 * * *", Count: count(-1)}, wantErr: true},
+		{name: "negative minimum", schedule: Schedule{Name: "s", Cron: "0 8 * * *", MinCount: -1}, wantErr: true},
+		{name: "maximum below minimum", schedule: Schedule{Name: "s", Cron: "0 8 * * *", MinCount: 5, MaxCount: 2}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.schedule.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClamp(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		count    int
+		want     int
+	}{
+		{name: "no bounds", count: 7, want: 7},
+		{name: "below minimum", schedule: Schedule{MinCount: 3}, count: 1, want: 3},
+		{name: "no maximum", schedule: Schedule{MinCount: 3}, count: 9, want: 9},
+		{name: "above maximum", schedule: Schedule{MinCount: 3, MaxCount: 5}, count: 9, want: 5},
+		{name: "within bounds", schedule: Schedule{MinCount: 3, MaxCount: 5}, count: 4, want: 4},
+		{name: "only maximum", schedule: Schedule{MaxCount: 5}, count: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Clamp(tt.count); got != tt.want {
+				t.Errorf("Clamp(%d) = %d, want %d", tt.count, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActive(t *testing.T) {
+	morning := &Schedule{Name: "morning", Cron: "0 8 * * mon-fri", Count: count(10)}
+	evening := &Schedule{Name: "evening", Cron: "0 20 * * *", Count: count(2)}
+	london := &Schedule{Name: "london", Cron: "0 9 * * *", Timezone: "Europe/London", Count: count(5)}
+	never := &Schedule{Name: "never", Cron: "0 0 30 2 *", Count: count(1)}
+
+	tests := []struct {
+		name      string
+		schedules []*Schedule
+		at        time.Time
+		want      *Schedule
+		wantFired time.Time
+	}{
+		{name: "none", at: utc(2018, 1, 2, 12, 0)},
+		{name: "never fired", schedules: []*Schedule{never}, at: utc(2018, 1, 2, 12, 0)},
+		{name: "morning", schedules: []*Schedule{morning, evening}, at: utc(2018, 1, 2, 12, 0), want: morning, wantFired: utc(2018, 1, 2, 8, 0)},
+		{name: "as morning fires", schedules: []*Schedule{morning, evening}, at: utc(2018, 1, 2, 8, 0), want: morning, wantFired: utc(2018, 1, 2, 8, 0)},
+		{name: "before morning", schedules: []*Schedule{morning, evening}, at: utc(2018, 1, 2, 7, 59), want: evening, wantFired: utc(2018, 1, 1, 20, 0)},
+		{name: "evening", schedules: []*Schedule{morning, evening}, at: utc(2018, 1, 2, 21, 0), want: evening, wantFired: utc(2018, 1, 2, 20, 0)},
+		{name: "weekend", schedules: []*Schedule{morning, evening}, at: utc(2018, 1, 6, 12, 0), want: evening, wantFired: utc(2018, 1, 5, 20, 0)},
+		{name: "order does not matter", schedules: []*Schedule{evening, never, morning}, at: utc(2018, 1, 2, 12, 0), want: morning, wantFired: utc(2018, 1, 2, 8, 0)},
+		{name: "same time, first wins", schedules: []*Schedule{morning, london}, at: utc(2018, 7, 2, 8, 30), want: morning, wantFired: utc(2018, 7, 2, 8, 0)},
+		{name: "timezone in summer time", schedules: []*Schedule{morning, london}, at: utc(2018, 7, 7, 8, 30), want: london, wantFired: utc(2018, 7, 7, 8, 0)},
+		{name: "timezone in winter", schedules: []*Schedule{morning, london}, at: utc(2018, 1, 6, 8, 30), want: london, wantFired: utc(2018, 1, 5, 9, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fired, err := Active(tt.schedules, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Active() = %v, want %v", got, tt.want)
+			}
+			if !fired.Equal(tt.wantFired) {
+				t.Errorf("Active() fired at %s, want %s", fired, tt.wantFired)
+			}
+		})
+	}
+
+	bad := &Schedule{Name: "bad", Cron: "0 8 * *", Count: count(1)}
+	if _, _, err := Active([]*Schedule{morning, bad}, utc(2018, 1, 2, 12, 0)); err == nil {
+		t.Error("expected an error for an invalid schedule")
+	}
+}
@@ -0,0 +1,321 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+)
+
+// node is a value read from a spec file together with the line it is on.
+// Its value is a string, int64, float64, bool, []*node, map[string]*node or
+// nil.
+type node struct {
+	line  int
+	value interface{}
+}
+
+// parseYAML reads a YAML spec. yaml.v2 does not report where values are, so
+// their lines are found by scanning the document for the keys leading to
+// them. A key repeated in a mapping is an error.
+func parseYAML(data []byte) (*node, error) {
+	var doc interface{}
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return fromYAML(doc, nil, yamlLines(data), 1), nil
+}
+
+func fromYAML(v interface{}, path []string, lines map[string]int, line int) *node {
+	if l, ok := lines[strings.Join(path, pathSeparator)]; ok {
+		line = l
+	}
+
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]*node, len(v))
+		for k, elem := range v {
+			key := fmt.Sprint(k)
+			m[key] = fromYAML(elem, append(path[:len(path):len(path)], key), lines, line)
+		}
+		return &node{line: line, value: m}
+	case []interface{}:
+		list := make([]*node, 0, len(v))
+		for i, elem := range v {
+			list = append(list, fromYAML(elem, append(path[:len(path):len(path)], strconv.Itoa(i)), lines, line))
+		}
+		return &node{line: line, value: list}
+	case int:
+		return &node{line: line, value: int64(v)}
+	case uint64:
+		return &node{line: line, value: float64(v)}
+	case string, int64, float64, bool, nil:
+		return &node{line: line, value: v}
+	}
+
+	return &node{line: line, value: fmt.Sprint(v)}
+}
+
+// pathSeparator joins the keys and list indexes leading to a YAML value.
+// It can not appear in a key.
+const pathSeparator = "\x00"
+
+var yamlKey = regexp.MustCompile(`^("(?:[^"\\]|\\.)*"|'[^']*'|[^\s#'"{\[][^:#]*?)\s*:(?:\s+(.*))?$`)
+
+// yamlLines maps the path of each key and list item in a block style YAML
+// document to the line it is on. Values inside flow style collections are
+// not found, and take the line of the collection.
+func yamlLines(data []byte) map[string]int {
+	type level struct {
+		indent int
+		path   []string
+		open   bool // a key whose value is the block that follows
+		items  int  // list items seen so far, for a key opening a list
+	}
+
+	lines := make(map[string]int)
+	var stack []*level
+	blockIndent := -1
+
+	for n, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(text, " \t\r")
+		content := strings.TrimLeft(text, " ")
+		indent := len(text) - len(content)
+
+		// The lines of a block scalar are all indented past its key.
+		if blockIndent >= 0 {
+			if content == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if content == "" || strings.HasPrefix(content, "#") || content == "---" || content == "..." {
+			continue
+		}
+
+		item := strings.HasPrefix(content, "- ") || content == "-"
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			// A list may be indented as far as the key it belongs to.
+			if top.indent < indent || (item && top.indent == indent && top.open) {
+				break
+			}
+			stack = stack[:len(stack)-1]
+		}
+
+		var parent []string
+		var owner *level
+		if len(stack) > 0 {
+			owner = stack[len(stack)-1]
+			parent = owner.path
+		}
+
+		if item {
+			index := 0
+			if owner != nil {
+				index = owner.items
+				owner.items++
+			}
+			path := append(parent[:len(parent):len(parent)], strconv.Itoa(index))
+			lines[strings.Join(path, pathSeparator)] = n + 1
+			stack = append(stack, &level{indent: indent, path: path})
+
+			content = strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+			indent = len(text) - len(content)
+			parent = path
+		}
+
+		m := yamlKey.FindStringSubmatch(content)
+		if m == nil {
+			continue
+		}
+
+		key := m[1]
+		if unquoted, err := strconv.Unquote(key); err == nil && strings.HasPrefix(key, `"`) {
+			key = unquoted
+		} else if strings.HasPrefix(key, "'") {
+			key = strings.Trim(key, "'")
+		}
+
+		path := append(parent[:len(parent):len(parent)], key)
+		lines[strings.Join(path, pathSeparator)] = n + 1
+
+		value := m[2]
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+			continue
+		}
+		stack = append(stack, &level{indent: indent, path: path, open: value == "" || strings.HasPrefix(value, "#")})
+	}
+
+	return lines
+}
+
+// parseTOML reads a TOML spec.
+func parseTOML(data []byte) (*node, error) {
+	tree, err := toml.LoadBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromTOMLTree(tree, 1), nil
+}
+
+func fromTOMLTree(tree *toml.Tree, line int) *node {
+	if pos := tree.Position(); !pos.Invalid() {
+		line = pos.Line
+	}
+
+	m := make(map[string]*node)
+	for _, key := range tree.Keys() {
+		keyLine := line
+		if pos := tree.GetPositionPath([]string{key}); !pos.Invalid() {
+			keyLine = pos.Line
+		}
+		m[key] = fromTOML(tree.GetPath([]string{key}), keyLine)
+	}
+
+	return &node{line: line, value: m}
+}
+
+func fromTOML(v interface{}, line int) *node {
+	switch v := v.(type) {
+	case *toml.Tree:
+		return fromTOMLTree(v, line)
+	case []*toml.Tree:
+		list := make([]*node, 0, len(v))
+		for _, elem := range v {
+			list = append(list, fromTOMLTree(elem, line))
+		}
+		return &node{line: line, value: list}
+	case []interface{}:
+		list := make([]*node, 0, len(v))
+		for _, elem := range v {
+			list = append(list, fromTOML(elem, line))
+		}
+		return &node{line: line, value: list}
+	case string, int64, float64, bool:
+		return &node{line: line, value: v}
+	}
+
+	return &node{line: line, value: fmt.Sprint(v)}
+}
+
+// parseHCL reads an HCL spec. Each group is a block labelled with its name,
+// as in `group "web" { ... }`, and becomes an entry of groups. A key
+// repeated in an object, other than a labelled block, is an error.
+func parseHCL(data []byte) (*node, error) {
+	file, err := hcl.ParseBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, fmt.Errorf("expected a list of groups")
+	}
+
+	root, err := fromHCLObject(list, 1)
+	if err != nil {
+		return nil, err
+	}
+	m := root.value.(map[string]*node)
+	if groups, ok := m["group"]; ok {
+		delete(m, "group")
+		m["groups"] = groups
+	}
+
+	return root, nil
+}
+
+func fromHCLObject(list *ast.ObjectList, line int) (*node, error) {
+	m := make(map[string]*node)
+	// blocks are the keys whose labelled blocks are gathered in a list.
+	blocks := make(map[string]bool)
+	for _, item := range list.Items {
+		key := hclKey(item.Keys[0])
+		keyLine := item.Keys[0].Pos().Line
+		if len(item.Keys) > 2 {
+			return nil, fmt.Errorf("line %d: block %q has more than one label", keyLine, key)
+		}
+		labelled := len(item.Keys) > 1
+
+		value, err := fromHCL(item.Val)
+		if err != nil {
+			return nil, err
+		}
+		value.line = keyLine
+
+		// A labelled block such as `group "web" { ... }` is a list entry
+		// named by its label.
+		if labelled {
+			obj, ok := value.value.(map[string]*node)
+			if !ok {
+				return nil, fmt.Errorf("line %d: labelled %q must be a block", keyLine, key)
+			}
+			if name, ok := obj["name"]; ok {
+				return nil, fmt.Errorf("line %d: block %q is named by its label and must not set name", name.line, key)
+			}
+			obj["name"] = &node{line: item.Keys[1].Pos().Line, value: hclKey(item.Keys[1])}
+		}
+
+		if existing, ok := m[key]; ok {
+			if !labelled || !blocks[key] {
+				return nil, fmt.Errorf("line %d: %q is already set on line %d", keyLine, key, existing.line)
+			}
+			existing.value = append(existing.value.([]*node), value)
+			continue
+		}
+		if labelled {
+			value = &node{line: value.line, value: []*node{value}}
+			blocks[key] = true
+		}
+		m[key] = value
+	}
+
+	return &node{line: line, value: m}, nil
+}
+
+func fromHCL(n ast.Node) (*node, error) {
+	line := n.Pos().Line
+
+	switch n := n.(type) {
+	case *ast.ObjectType:
+		return fromHCLObject(n.List, line)
+	case *ast.ListType:
+		list := make([]*node, 0, len(n.List))
+		for _, elem := range n.List {
+			item, err := fromHCL(elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return &node{line: line, value: list}, nil
+	case *ast.LiteralType:
+		return &node{line: line, value: n.Token.Value()}, nil
+	}
+
+	return &node{line: line}, nil
+}
+
+func hclKey(key *ast.ObjectKey) string {
+	if s, ok := key.Token.Value().(string); ok {
+		return s
+	}
+	return key.Token.Text
+}
@@ -0,0 +1,180 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package spec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestYAMLLines(t *testing.T) {
+	doc := `# groups
+groups:
+  - name: web
+    count: 3
+    tags:
+      role: "web: front"
+      'quoted': x
+    userdata: |
+      name: not a key
+      count: 9
+    networks:
+    - public
+    - private
+  -
+    name: api
+    metadata: {a: b}
+
+  - name: worker
+`
+
+	lines := yamlLines([]byte(doc))
+	tests := []struct {
+		path []string
+		line int
+	}{
+		{path: []string{"groups"}, line: 2},
+		{path: []string{"groups", "0"}, line: 3},
+		{path: []string{"groups", "0", "name"}, line: 3},
+		{path: []string{"groups", "0", "count"}, line: 4},
+		{path: []string{"groups", "0", "tags", "role"}, line: 6},
+		{path: []string{"groups", "0", "tags", "quoted"}, line: 7},
+		{path: []string{"groups", "0", "userdata"}, line: 8},
+		{path: []string{"groups", "0", "networks"}, line: 11},
+		{path: []string{"groups", "0", "networks", "1"}, line: 13},
+		{path: []string{"groups", "1"}, line: 14},
+		{path: []string{"groups", "1", "name"}, line: 15},
+		{path: []string{"groups", "1", "metadata"}, line: 16},
+		{path: []string{"groups", "2", "name"}, line: 18},
+	}
+	for _, tt := range tests {
+		path := strings.Join(tt.path, pathSeparator)
+		if got, ok := lines[path]; !ok || got != tt.line {
+			t.Errorf("%s is on line %d, want %d", strings.Join(tt.path, "."), got, tt.line)
+		}
+	}
+
+	// The lines of a block scalar are not keys.
+	if _, ok := lines[strings.Join([]string{"groups", "0", "userdata", "count"}, pathSeparator)]; ok {
+		t.Error("found a key inside a block scalar")
+	}
+	// Values of a flow style mapping take the mapping's line.
+	if _, ok := lines[strings.Join([]string{"groups", "1", "metadata", "a"}, pathSeparator)]; ok {
+		t.Error("found a key inside a flow style mapping")
+	}
+}
+
+// field returns the value of key in the group at index of root's groups.
+func field(t *testing.T, root *node, index int, key string) *node {
+	t.Helper()
+
+	groups := root.value.(map[string]*node)["groups"].value.([]*node)
+	n, ok := groups[index].value.(map[string]*node)[key]
+	if !ok {
+		t.Fatalf("group %d has no %s", index, key)
+	}
+	return n
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte) (*node, error)
+		doc   string
+		// lines are where name, count and tags.role are.
+		lines [3]int
+	}{
+		{
+			name:  "yaml",
+			lines: [3]int{2, 3, 5},
+			parse: parseYAML,
+			doc: `groups:
+  - name: web
+    count: 3
+    tags:
+      role: a=b
+`,
+		},
+		{
+			name:  "toml",
+			lines: [3]int{2, 3, 5},
+			parse: parseTOML,
+			doc: `[[groups]]
+name = "web"
+count = 3
+  [groups.tags]
+  role = "a=b"
+`,
+		},
+		{
+			name:  "hcl",
+			lines: [3]int{1, 2, 4},
+			parse: parseHCL,
+			doc: `group "web" {
+  count = 3
+  tags {
+    role = "a=b"
+  }
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := tt.parse([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n := field(t, root, 0, "name"); n.value != "web" || n.line != tt.lines[0] {
+				t.Errorf("name is %v on line %d, want web on line %d", n.value, n.line, tt.lines[0])
+			}
+			if n := field(t, root, 0, "count"); n.value != int64(3) || n.line != tt.lines[1] {
+				t.Errorf("count is %v on line %d, want 3 on line %d", n.value, n.line, tt.lines[1])
+			}
+			tags := field(t, root, 0, "tags").value.(map[string]*node)
+			if n := tags["role"]; n == nil || n.value != "a=b" || n.line != tt.lines[2] {
+				t.Errorf("tags.role is %+v, want a=b on line %d", n, tt.lines[2])
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte) (*node, error)
+		doc   string
+		want  string
+	}{
+		{name: "yaml syntax", parse: parseYAML, doc: "groups:\n  - name: [web\n", want: "line"},
+		{name: "yaml duplicate key", parse: parseYAML, doc: "groups:\n  - name: web\n    name: api\n", want: "already set"},
+		{name: "toml syntax", parse: parseTOML, doc: "[[groups]]\nname = \n", want: "(3, 1): expecting a value"},
+		{name: "toml duplicate key", parse: parseTOML, doc: "[[groups]]\nname = \"web\"\nname = \"api\"\n", want: "defined twice"},
+		{name: "hcl syntax", parse: parseHCL, doc: "group \"web\" {\n  count = \n}\n", want: "At 4:1"},
+		{name: "hcl duplicate key", parse: parseHCL, doc: "group \"web\" {\n  count = 1\n  count = 2\n}\n", want: "line 3: \"count\" is already set on line 2"},
+		{name: "hcl duplicate block", parse: parseHCL, doc: "group \"web\" {\n  tags { a = 1 }\n  tags { b = 2 }\n}\n", want: "line 3: \"tags\" is already set on line 2"},
+		{name: "hcl label and name", parse: parseHCL, doc: "group \"web\" {\n  name = \"api\"\n}\n", want: "line 2: block \"group\" is named by its label"},
+		{name: "hcl labelled and unlabelled", parse: parseHCL, doc: "group = []\ngroup \"web\" {}\n", want: "line 2: \"group\" is already set on line 1"},
+		{name: "hcl two labels", parse: parseHCL, doc: "group \"web\" \"api\" {}\n", want: "more than one label"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.parse([]byte(tt.doc))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
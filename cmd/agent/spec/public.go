@@ -0,0 +1,409 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package spec
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/joyent/tsg-cli/cmd/agent/scale"
+	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// Group is the declared state of one group in a spec file. In YAML and TOML
+// a spec is a list of groups under `groups`; in HCL each group is a
+// `group "<name>" { ... }` block.
+type Group struct {
+	Name        string
+	TemplateID  string
+	Package     string
+	Image       string
+	Count       int
+	Networks    []string
+	Tags        map[string]string
+	Metadata    map[string]string
+	Affinity    []string
+	Userdata    string
+	Firewall    bool
+	Datacenters []string
+	Spread      string
+
+	// Line is where the group is declared in its spec file.
+	Line int
+}
+
+// Load reads and validates the groups declared in a YAML, TOML or HCL spec
+// file, which is picked by the file's extension. Every problem found is
+// reported, each with the line it is on.
+func Load(path string) ([]*Group, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading group spec from %s", path)
+	}
+
+	var root *node
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		root, err = parseYAML(data)
+	case ".toml":
+		root, err = parseTOML(data)
+	case ".hcl":
+		root, err = parseHCL(data)
+	default:
+		return nil, fmt.Errorf("unknown group spec format %q (valid extensions: .yaml, .yml, .toml, .hcl)", ext)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing group spec %s", path)
+	}
+
+	d := &decoder{path: path}
+	groups := d.groups(root)
+	if err := d.err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// Configure makes the group's settings the ones every config getter
+// returns, overriding any flags, so that the group can be reconciled like
+// one given on the command line.
+func (g *Group) Configure() {
+	tags := make([]string, 0, len(g.Tags))
+	for _, k := range sortedStringKeys(g.Tags) {
+		tags = append(tags, k+"="+g.Tags[k])
+	}
+
+	// Metadata and user-data are read back base64 encoded, as given to
+	// --metadata and --userdata.
+	metadata := make([]string, 0, len(g.Metadata))
+	for _, k := range sortedStringKeys(g.Metadata) {
+		metadata = append(metadata, base64.StdEncoding.EncodeToString([]byte(k+"="+g.Metadata[k])))
+	}
+
+	viper.Set(config.KeyTsgGroupName, g.Name)
+	viper.Set(config.KeyTsgTemplateID, g.TemplateID)
+	viper.Set(config.KeyPackageId, g.Package)
+	viper.Set(config.KeyImageId, g.Image)
+	viper.Set(config.KeyInstanceCount, g.Count)
+	viper.Set(config.KeyInstanceNetwork, nonNil(g.Networks))
+	viper.Set(config.KeyInstanceTag, tags)
+	viper.Set(config.KeyInstanceMetadata, metadata)
+	viper.Set(config.KeyInstanceAffinityRule, nonNil(g.Affinity))
+	viper.Set(config.KeyInstanceUserdata, base64.StdEncoding.EncodeToString([]byte(g.Userdata)))
+	viper.Set(config.KeyInstanceFirewall, g.Firewall)
+	viper.Set(config.KeyTsgDatacenters, nonNil(g.Datacenters))
+	viper.Set(config.KeyInstanceSpread, g.Spread)
+}
+
+// Reconcile configures each group in turn and calls fn to reconcile it. A
+// group that can not be reconciled is reported and the rest are still
+// reconciled, unless ctx is done.
+func Reconcile(ctx context.Context, groups []*Group, fn func(context.Context, *Group) error) error {
+	var failed []string
+	for _, g := range groups {
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "stopped before reconciling TSG %q", g.Name)
+		}
+
+		g.Configure()
+		if err := fn(ctx, g); err != nil {
+			log.Error().
+				Str("tsg_name", g.Name).
+				Str("status", "failed").
+				Str("notification_type", "TSG_SPEC_APPLY_ERROR").
+				Str("description", fmt.Sprintf("Unable to reconcile TSG %q: %s", g.Name, err)).
+				Msgf("A TSG could not be reconciled with its spec")
+			failed = append(failed, g.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d TSG(s) could not be reconciled: %s", len(failed), len(groups), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// decoder turns the nodes of a spec file into groups, collecting an error
+// for each problem rather than stopping at the first.
+type decoder struct {
+	path string
+	errs []specError
+}
+
+type specError struct {
+	line int
+	msg  string
+}
+
+func (d *decoder) errorf(line int, format string, args ...interface{}) {
+	d.errs = append(d.errs, specError{line: line, msg: fmt.Sprintf(format, args...)})
+}
+
+// err returns the problems found in file order, or nil if there were none.
+func (d *decoder) err() error {
+	if len(d.errs) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(d.errs, func(i, j int) bool {
+		return d.errs[i].line < d.errs[j].line
+	})
+
+	lines := make([]string, 0, len(d.errs))
+	for _, e := range d.errs {
+		lines = append(lines, fmt.Sprintf("%s:%d: %s", d.path, e.line, e.msg))
+	}
+	return fmt.Errorf("invalid group spec %s:\n  %s", d.path, strings.Join(lines, "\n  "))
+}
+
+func (d *decoder) groups(root *node) []*Group {
+	top, ok := root.value.(map[string]*node)
+	if !ok {
+		d.errorf(root.line, "expected a mapping with a list of groups")
+		return nil
+	}
+
+	for _, key := range sortedKeys(top) {
+		if key != "groups" {
+			d.errorf(top[key].line, "unknown key %q", key)
+		}
+	}
+
+	list, ok := top["groups"]
+	if !ok {
+		d.errorf(root.line, "no groups declared")
+		return nil
+	}
+	items, ok := list.value.([]*node)
+	if !ok {
+		d.errorf(list.line, "groups must be a list")
+		return nil
+	}
+	if len(items) == 0 {
+		d.errorf(list.line, "no groups declared")
+	}
+
+	var groups []*Group
+	declared := make(map[string]int)
+	for _, item := range items {
+		g := d.group(item)
+		if g == nil {
+			continue
+		}
+
+		if line, ok := declared[g.Name]; ok {
+			d.errorf(g.Line, "group %q is already declared on line %d", g.Name, line)
+			continue
+		}
+		if g.Name != "" {
+			declared[g.Name] = g.Line
+		}
+		groups = append(groups, g)
+	}
+
+	return groups
+}
+
+// groupFields decodes each key of a group.
+var groupFields = map[string]func(d *decoder, g *Group, n *node){
+	"name":        func(d *decoder, g *Group, n *node) { g.Name = d.str(n, "name") },
+	"template_id": func(d *decoder, g *Group, n *node) { g.TemplateID = d.str(n, "template_id") },
+	"package":     func(d *decoder, g *Group, n *node) { g.Package = d.str(n, "package") },
+	"image":       func(d *decoder, g *Group, n *node) { g.Image = d.str(n, "image") },
+	"count":       func(d *decoder, g *Group, n *node) { g.Count = d.count(n) },
+	"networks":    func(d *decoder, g *Group, n *node) { g.Networks = d.strs(n, "networks") },
+	"tags":        func(d *decoder, g *Group, n *node) { g.Tags = d.strMap(n, "tags") },
+	"metadata":    func(d *decoder, g *Group, n *node) { g.Metadata = d.strMap(n, "metadata") },
+	"affinity":    func(d *decoder, g *Group, n *node) { g.Affinity = d.strs(n, "affinity") },
+	"userdata":    func(d *decoder, g *Group, n *node) { g.Userdata = d.str(n, "userdata") },
+	"firewall":    func(d *decoder, g *Group, n *node) { g.Firewall = d.boolean(n, "firewall") },
+	"datacenters": func(d *decoder, g *Group, n *node) { g.Datacenters = d.strs(n, "datacenters") },
+	"spread":      func(d *decoder, g *Group, n *node) { g.Spread = d.str(n, "spread") },
+}
+
+func fieldNames() []string {
+	names := make([]string, 0, len(groupFields))
+	for name := range groupFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// requiredFields are the keys every group must set.
+var requiredFields = []string{"name", "template_id", "package", "image", "count"}
+
+func (d *decoder) group(n *node) *Group {
+	fields, ok := n.value.(map[string]*node)
+	if !ok {
+		d.errorf(n.line, "expected a group, found %s", describe(n))
+		return nil
+	}
+
+	g := &Group{Line: n.line}
+	for _, key := range sortedKeys(fields) {
+		decode, ok := groupFields[key]
+		if !ok {
+			d.errorf(fields[key].line, "unknown key %q in group (valid keys: %s)", key, strings.Join(fieldNames(), ", "))
+			continue
+		}
+		decode(d, g, fields[key])
+	}
+
+	for _, key := range requiredFields {
+		if _, ok := fields[key]; !ok {
+			d.errorf(n.line, "group %q: %s is required", g.Name, key)
+		}
+	}
+
+	// The first 8 characters of the template ID name each instance.
+	if _, ok := fields["template_id"]; ok && len(g.TemplateID) < 8 {
+		d.errorf(fields["template_id"].line, "template_id must be at least 8 characters long")
+	}
+	if f, ok := fields["spread"]; ok {
+		if err := scale.ValidateSpread(g.Spread); err != nil {
+			d.errorf(f.line, "%s", err)
+		}
+	}
+	if f, ok := fields["datacenters"]; ok {
+		for _, dc := range g.Datacenters {
+			if dc == "*" {
+				continue
+			}
+			if _, err := scale.ParseDatacenter(dc); err != nil {
+				d.errorf(f.line, "%s", err)
+			}
+		}
+	}
+	if f, ok := fields["tags"]; ok {
+		for k := range g.Tags {
+			if strings.Contains(k, "=") {
+				d.errorf(f.line, "tag name %q must not contain =", k)
+			}
+		}
+	}
+	if f, ok := fields["metadata"]; ok {
+		for k := range g.Metadata {
+			if strings.Contains(k, "=") {
+				d.errorf(f.line, "metadata key %q must not contain =", k)
+			}
+		}
+	}
+
+	return g
+}
+
+func (d *decoder) str(n *node, field string) string {
+	s, ok := n.value.(string)
+	if !ok {
+		d.errorf(n.line, "%s must be a string, found %s", field, describe(n))
+	}
+	return s
+}
+
+func (d *decoder) boolean(n *node, field string) bool {
+	b, ok := n.value.(bool)
+	if !ok {
+		d.errorf(n.line, "%s must be true or false, found %s", field, describe(n))
+	}
+	return b
+}
+
+func (d *decoder) count(n *node) int {
+	i, ok := n.value.(int64)
+	if !ok || i < 0 {
+		d.errorf(n.line, "count must be a non-negative integer, found %s", describe(n))
+		return 0
+	}
+	return int(i)
+}
+
+func (d *decoder) strs(n *node, field string) []string {
+	items, ok := n.value.([]*node)
+	if !ok {
+		d.errorf(n.line, "%s must be a list of strings, found %s", field, describe(n))
+		return nil
+	}
+
+	s := make([]string, 0, len(items))
+	for _, item := range items {
+		s = append(s, d.str(item, field))
+	}
+	return s
+}
+
+// strMap decodes a mapping of names to scalar values, which are converted
+// to strings.
+func (d *decoder) strMap(n *node, field string) map[string]string {
+	entries, ok := n.value.(map[string]*node)
+	if !ok {
+		d.errorf(n.line, "%s must be a mapping, found %s", field, describe(n))
+		return nil
+	}
+
+	m := make(map[string]string, len(entries))
+	for _, k := range sortedKeys(entries) {
+		switch v := entries[k].value.(type) {
+		case string, int64, float64, bool:
+			m[k] = fmt.Sprint(v)
+		default:
+			d.errorf(entries[k].line, "%s %q must be a string, number or boolean, found %s", field, k, describe(entries[k]))
+		}
+	}
+	return m
+}
+
+func describe(n *node) string {
+	switch v := n.value.(type) {
+	case nil:
+		return "nothing"
+	case map[string]*node:
+		return "a mapping"
+	case []*node:
+		return "a list"
+	case string:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func sortedKeys(m map[string]*node) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
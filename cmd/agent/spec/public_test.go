@@ -0,0 +1,182 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package spec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/joyent/tsg-cli/cmd/config"
+	"github.com/spf13/viper"
+)
+
+// writeSpec writes doc to a spec file named name and returns its path.
+func writeSpec(t *testing.T, name, doc string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "tsg-spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		file string
+		doc  string
+	}{
+		{file: "spec.yaml", doc: `groups:
+  - name: web
+    template_id: "0123456789"
+    package: g4-highcpu-1G
+    image: base-64
+    count: 3
+    tags:
+      role: a=b
+    metadata:
+      conf: x=1
+`},
+		{file: "spec.toml", doc: `[[groups]]
+name = "web"
+template_id = "0123456789"
+package = "g4-highcpu-1G"
+image = "base-64"
+count = 3
+  [groups.tags]
+  role = "a=b"
+  [groups.metadata]
+  conf = "x=1"
+`},
+		{file: "spec.hcl", doc: `group "web" {
+  template_id = "0123456789"
+  package     = "g4-highcpu-1G"
+  image       = "base-64"
+  count       = 3
+  tags {
+    role = "a=b"
+  }
+  metadata {
+    conf = "x=1"
+  }
+}
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			defer viper.Reset()
+
+			groups, err := Load(writeSpec(t, tt.file, tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(groups) != 1 {
+				t.Fatalf("loaded %d groups, want 1", len(groups))
+			}
+			g := groups[0]
+			if g.Name != "web" || g.TemplateID != "0123456789" || g.Count != 3 {
+				t.Errorf("loaded %+v", g)
+			}
+
+			// Values containing = are read back whole.
+			g.Configure()
+			if got := config.GetMachineTags()["role"]; got != "a=b" {
+				t.Errorf("tag role = %q, want a=b", got)
+			}
+			metadata, err := config.GetMachineMetadata()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := metadata["conf"]; got != "x=1" {
+				t.Errorf("metadata conf = %q, want x=1", got)
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		file string
+		doc  string
+		want []string
+	}{
+		{file: "spec.yaml", doc: `groups:
+  - name: web
+    template_id: short
+    count: -1
+    colour: red
+`, want: []string{
+			"spec.yaml:2: group \"web\": package is required",
+			"spec.yaml:3: template_id must be at least 8 characters long",
+			"spec.yaml:4: count must be a non-negative integer",
+			"spec.yaml:5: unknown key \"colour\"",
+		}},
+		{file: "spec.toml", doc: `[[groups]]
+name = "web"
+template_id = "0123456789"
+package = "p"
+image = "i"
+count = "three"
+spread = "wide"
+`, want: []string{
+			"spec.toml:6: count must be a non-negative integer, found \"three\"",
+			"spec.toml:7: unknown spread mode \"wide\"",
+		}},
+		{file: "spec.hcl", doc: `group "web" {
+  template_id = "0123456789"
+  package     = "p"
+  image       = "i"
+  count       = 1
+  metadata {
+    "a=b" = "c"
+  }
+}
+
+group "web" {
+  template_id = "0123456789"
+  package     = "p"
+  image       = "i"
+  count       = 1
+}
+`, want: []string{
+			"spec.hcl:6: metadata key \"a=b\" must not contain =",
+			"spec.hcl:11: group \"web\" is already declared on line 1",
+		}},
+		{file: "spec.hcl", doc: "group \"web\" {\n  name = \"api\"\n}\n", want: []string{
+			"line 2: block \"group\" is named by its label",
+		}},
+		{file: "spec.json", doc: "{}", want: []string{
+			"unknown group spec format \".json\"",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			_, err := Load(writeSpec(t, tt.file, tt.doc))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
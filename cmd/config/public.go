@@ -14,7 +14,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/joyent/triton-go"
 	"github.com/joyent/triton-go/authentication"
@@ -34,12 +36,12 @@ func New() (*TritonClientConfig, error) {
 	var signer authentication.Signer
 	var err error
 
-	keyMaterial, err := GetTritonKeyMaterial()
+	keyBytes, err := GetTritonPrivateKey()
 	if err != nil {
-		return nil, errors.Wrap(err, "error decoding key material from a Base64 encoded value")
+		return nil, err
 	}
 
-	if keyMaterial == "" {
+	if keyBytes == nil {
 		signer, err = authentication.NewSSHAgentSigner(authentication.SSHAgentSignerInput{
 			KeyID:       GetTritonKeyID(),
 			AccountName: GetTritonAccount(),
@@ -49,29 +51,6 @@ func New() (*TritonClientConfig, error) {
 			return nil, err
 		}
 	} else {
-		var keyBytes []byte
-		if _, err = os.Stat(keyMaterial); err == nil {
-			keyBytes, err = ioutil.ReadFile(keyMaterial)
-			if err != nil {
-				return nil, fmt.Errorf("error reading key material from %s: %s",
-					keyMaterial, err)
-			}
-			block, _ := pem.Decode(keyBytes)
-			if block == nil {
-				return nil, fmt.Errorf(
-					"failed to read key material '%s': no key found", keyMaterial)
-			}
-
-			if block.Headers["Proc-Type"] == "4,ENCRYPTED" {
-				return nil, fmt.Errorf(
-					"failed to read key '%s': password protected keys are\n"+
-						"not currently supported. Please decrypt the key prior to use.", keyMaterial)
-			}
-
-		} else {
-			keyBytes = []byte(keyMaterial)
-		}
-
 		signer, err = authentication.NewPrivateKeySigner(authentication.PrivateKeySignerInput{
 			KeyID:              GetTritonKeyID(),
 			PrivateKeyMaterial: keyBytes,
@@ -108,6 +87,43 @@ func GetTritonKeyMaterial() (string, error) {
 	return data, nil
 }
 
+// GetTritonPrivateKey returns the PEM encoded private key given as key
+// material, either inline or as the path of a file holding it, or nil if
+// the key is to be used from an SSH agent.
+func GetTritonPrivateKey() ([]byte, error) {
+	keyMaterial, err := GetTritonKeyMaterial()
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding key material from a Base64 encoded value")
+	}
+
+	if keyMaterial == "" {
+		return nil, nil
+	}
+
+	if _, err := os.Stat(keyMaterial); err != nil {
+		return []byte(keyMaterial), nil
+	}
+
+	keyBytes, err := ioutil.ReadFile(keyMaterial)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key material from %s: %s",
+			keyMaterial, err)
+	}
+	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return nil, fmt.Errorf(
+			"failed to read key material '%s': no key found", keyMaterial)
+	}
+
+	if block.Headers["Proc-Type"] == "4,ENCRYPTED" {
+		return nil, fmt.Errorf(
+			"failed to read key '%s': password protected keys are\n"+
+				"not currently supported. Please decrypt the key prior to use.", keyMaterial)
+	}
+
+	return keyBytes, nil
+}
+
 func GetTritonAccount() string {
 	return viper.GetString(config.KeyAccount)
 }
@@ -136,6 +152,314 @@ func GetTsgTemplateID() string {
 	return viper.GetString(config.KeyTsgTemplateID)
 }
 
+func GetDatacenters() []string {
+	return viper.GetStringSlice(config.KeyTsgDatacenters)
+}
+
+func GetScaleParallelism() int {
+	parallelism := viper.GetInt(config.KeyScaleParallelism)
+	if parallelism < 1 {
+		return 1
+	}
+
+	return parallelism
+}
+
+func GetHealthyInstanceStates() []string {
+	states := viper.GetStringSlice(config.KeyInstanceState)
+	if len(states) == 0 {
+		return []string{"running"}
+	}
+
+	return states
+}
+
+func GetProvisioningTimeout() time.Duration {
+	return viper.GetDuration(config.KeyInstanceProvisioning)
+}
+
+func GetHealthCheckType() string {
+	return viper.GetString(config.KeyHealthCheckType)
+}
+
+func GetHealthCheckPort() int {
+	return viper.GetInt(config.KeyHealthCheckPort)
+}
+
+func GetHealthCheckPath() string {
+	path := viper.GetString(config.KeyHealthCheckPath)
+	if path == "" {
+		return "/"
+	}
+
+	return path
+}
+
+func GetHealthCheckStatus() int {
+	return viper.GetInt(config.KeyHealthCheckStatus)
+}
+
+func GetHealthCheckInterval() time.Duration {
+	return viper.GetDuration(config.KeyHealthCheckInterval)
+}
+
+func GetHealthCheckTimeout() time.Duration {
+	return viper.GetDuration(config.KeyHealthCheckTimeout)
+}
+
+func GetHealthyThreshold() int {
+	return viper.GetInt(config.KeyHealthCheckHealthy)
+}
+
+func GetUnhealthyThreshold() int {
+	return viper.GetInt(config.KeyHealthCheckUnhealthy)
+}
+
+func GetHealthCheckGracePeriod() time.Duration {
+	return viper.GetDuration(config.KeyHealthCheckGracePeriod)
+}
+
+// Hook is the configuration of a lifecycle hook: a command or webhook URL,
+// how long it may run and what happens when it fails.
+type Hook struct {
+	Run       string
+	Timeout   time.Duration
+	OnFailure string
+}
+
+func GetPreLaunchHook() Hook {
+	return getHook(config.KeyHookPreLaunch, config.KeyHookPreLaunchTimeout, config.KeyHookPreLaunchOnFailure)
+}
+
+func GetPostLaunchHook() Hook {
+	return getHook(config.KeyHookPostLaunch, config.KeyHookPostLaunchTimeout, config.KeyHookPostLaunchOnFailure)
+}
+
+func GetPreTerminateHook() Hook {
+	return getHook(config.KeyHookPreTerminate, config.KeyHookPreTerminateTimeout, config.KeyHookPreTerminateOnFailure)
+}
+
+func GetPostTerminateHook() Hook {
+	return getHook(config.KeyHookPostTerminate, config.KeyHookPostTerminateTimeout, config.KeyHookPostTerminateOnFailure)
+}
+
+func getHook(runKey, timeoutKey, onFailureKey string) Hook {
+	timeout := viper.GetDuration(timeoutKey)
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	return Hook{
+		Run:       viper.GetString(runKey),
+		Timeout:   timeout,
+		OnFailure: viper.GetString(onFailureKey),
+	}
+}
+
+func GetReadyTimeout() time.Duration {
+	timeout := viper.GetDuration(config.KeyInstanceReadyTimeout)
+	if timeout <= 0 {
+		return 5 * time.Minute
+	}
+
+	return timeout
+}
+
+func GetReadyPollInterval() time.Duration {
+	interval := viper.GetDuration(config.KeyInstanceReadyPoll)
+	if interval <= 0 {
+		return time.Second
+	}
+
+	return interval
+}
+
+func GetTerminationPolicies() []string {
+	return viper.GetStringSlice(config.KeyScaleTerminationPolicy)
+}
+
+func GetWarmPoolSize() int {
+	return viper.GetInt(config.KeyWarmPoolSize)
+}
+
+func GetWarmPoolMaxAge() time.Duration {
+	return viper.GetDuration(config.KeyWarmPoolMaxAge)
+}
+
+func GetRefreshMaxSurge() int {
+	return viper.GetInt(config.KeyRefreshMaxSurge)
+}
+
+func GetRefreshMaxUnavailable() int {
+	return viper.GetInt(config.KeyRefreshMaxUnavailable)
+}
+
+func GetRebalanceMaxPerNode() int {
+	return viper.GetInt(config.KeyRebalanceMaxPerNode)
+}
+
+func GetRebalanceDryRun() bool {
+	return viper.GetBool(config.KeyRebalanceDryRun)
+}
+
+func GetLockMode() string {
+	return viper.GetString(config.KeyLockMode)
+}
+
+func GetLockTimeout() time.Duration {
+	return viper.GetDuration(config.KeyLockTimeout)
+}
+
+func GetAgentInterval() time.Duration {
+	interval := viper.GetDuration(config.KeyAgentInterval)
+	if interval <= 0 {
+		return time.Minute
+	}
+
+	return interval
+}
+
+func GetAgentJitter() time.Duration {
+	return viper.GetDuration(config.KeyAgentJitter)
+}
+
+func GetAgentMaxBackoff() time.Duration {
+	return viper.GetDuration(config.KeyAgentMaxBackoff)
+}
+
+func GetPlanOutputFile() string {
+	return viper.GetString(config.KeyPlanOutputFile)
+}
+
+func GetPlanJSON() bool {
+	return viper.GetBool(config.KeyPlanJSON)
+}
+
+func GetApplySpecFile() string {
+	return viper.GetString(config.KeyApplySpecFile)
+}
+
+func GetAutoscalePrometheusURL() string {
+	return viper.GetString(config.KeyAutoscalePrometheusURL)
+}
+
+func GetAutoscaleQuery() string {
+	return viper.GetString(config.KeyAutoscaleQuery)
+}
+
+func GetAutoscaleTarget() float64 {
+	return viper.GetFloat64(config.KeyAutoscaleTarget)
+}
+
+func GetAutoscaleMetricType() string {
+	return viper.GetString(config.KeyAutoscaleMetricType)
+}
+
+func GetAutoscaleMinCount() int {
+	return viper.GetInt(config.KeyAutoscaleMinCount)
+}
+
+func GetAutoscaleMaxCount() int {
+	return viper.GetInt(config.KeyAutoscaleMaxCount)
+}
+
+func GetAutoscaleScaleInDampening() float64 {
+	return viper.GetFloat64(config.KeyAutoscaleDampening)
+}
+
+func GetAutoscaleTolerance() float64 {
+	return viper.GetFloat64(config.KeyAutoscaleTolerance)
+}
+
+func GetAutoscaleCMONURL() string {
+	return viper.GetString(config.KeyAutoscaleCMONURL)
+}
+
+func GetAutoscaleCMONMetric() string {
+	return viper.GetString(config.KeyAutoscaleCMONMetric)
+}
+
+func GetAutoscaleCMONAggregation() string {
+	return viper.GetString(config.KeyAutoscaleCMONAggregate)
+}
+
+func GetAutoscaleCMONRateWindow() time.Duration {
+	return viper.GetDuration(config.KeyAutoscaleCMONRate)
+}
+
+func GetScheduleName() string {
+	return viper.GetString(config.KeyScheduleName)
+}
+
+func GetScheduleCron() string {
+	return viper.GetString(config.KeyScheduleCron)
+}
+
+func GetScheduleTimezone() string {
+	return viper.GetString(config.KeyScheduleTimezone)
+}
+
+func GetScheduleCount() int {
+	return viper.GetInt(config.KeyScheduleCount)
+}
+
+func GetScheduleMinCount() int {
+	return viper.GetInt(config.KeyScheduleMinCount)
+}
+
+func GetScheduleMaxCount() int {
+	return viper.GetInt(config.KeyScheduleMaxCount)
+}
+
+func GetScheduleNext() int {
+	return viper.GetInt(config.KeyScheduleNext)
+}
+
+func GetTimeout() time.Duration {
+	return viper.GetDuration(config.KeyTimeout)
+}
+
+func GetRetryMaxAttempts() int {
+	attempts := viper.GetInt(config.KeyRetryMaxAttempts)
+	if attempts < 1 {
+		return 1
+	}
+
+	return attempts
+}
+
+func GetRetryBaseDelay() time.Duration {
+	return viper.GetDuration(config.KeyRetryBaseDelay)
+}
+
+func GetRetryMaxDelay() time.Duration {
+	return viper.GetDuration(config.KeyRetryMaxDelay)
+}
+
+func GetScaleOutCooldown() time.Duration {
+	return viper.GetDuration(config.KeyScaleOutCooldown)
+}
+
+func GetScaleInCooldown() time.Duration {
+	return viper.GetDuration(config.KeyScaleInCooldown)
+}
+
+func GetOnFailure() string {
+	return viper.GetString(config.KeyScaleOnFailure)
+}
+
+func GetScaleForce() bool {
+	return viper.GetBool(config.KeyScaleForce)
+}
+
+func GetStateDir() string {
+	if dir := viper.GetString(config.KeyStateDir); dir != "" {
+		return dir
+	}
+
+	return filepath.Join(os.Getenv("HOME"), ".tsg", "state")
+}
+
 func GetMachineFirewall() bool {
 	return viper.GetBool(config.KeyInstanceFirewall)
 }
@@ -166,12 +490,16 @@ func GetMachineAffinityRules() []string {
 	return nil
 }
 
+func GetInstanceSpread() string {
+	return viper.GetString(config.KeyInstanceSpread)
+}
+
 func GetMachineTags() map[string]string {
 	if viper.IsSet(config.KeyInstanceTag) {
 		tags := make(map[string]string, 0)
 		cfg := viper.GetStringSlice(config.KeyInstanceTag)
 		for _, i := range cfg {
-			m := strings.Split(i, "=")
+			m := strings.SplitN(i, "=", 2)
 			tags[m[0]] = m[1]
 		}
 
@@ -190,7 +518,7 @@ func GetMachineMetadata() (map[string]string, error) {
 			if err != nil {
 				return nil, err
 			}
-			m := strings.Split(data, "=")
+			m := strings.SplitN(data, "=", 2)
 			metadata[m[0]] = m[1]
 		}
 
@@ -0,0 +1,74 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package command
+
+import (
+	"context"
+
+	"github.com/joyent/tsg-cli/cmd/agent/autoscale"
+	"github.com/joyent/tsg-cli/cmd/agent/health"
+	"github.com/joyent/tsg-cli/cmd/agent/scale"
+	tsgc "github.com/joyent/tsg-cli/cmd/config"
+)
+
+// NewGroupClient creates the compute client of the commands that reconcile
+// the configured group, with the group's data centers, health check and
+// autoscaling policy set from their flags.
+func NewGroupClient(ctx context.Context) (*scale.AgentComputeClient, error) {
+	c, err := tsgc.New()
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newMemberClient(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := autoscale.NewFromConfig(c, a.GetReadyInstanceIDs)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		a.SetDesiredCountFunc(policy.Desired)
+	}
+
+	return a, nil
+}
+
+// NewReplaceClient creates the compute client of the commands that replace
+// members of the configured group without changing its size, with the
+// group's data centers and health check set from their flags.
+func NewReplaceClient(ctx context.Context) (*scale.AgentComputeClient, error) {
+	c, err := tsgc.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return newMemberClient(ctx, c)
+}
+
+func newMemberClient(ctx context.Context, c *tsgc.TritonClientConfig) (*scale.AgentComputeClient, error) {
+	a, err := scale.NewComputeClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.SetDatacenters(ctx, tsgc.GetDatacenters()); err != nil {
+		return nil, err
+	}
+
+	check, err := health.NewFromConfig()
+	if err != nil {
+		return nil, err
+	}
+	a.SetHealthCheck(check)
+
+	return a, nil
+}
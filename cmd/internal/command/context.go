@@ -0,0 +1,118 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package command
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	mu          sync.Mutex
+	rootCtx     = context.Background()
+	stopTimeout context.CancelFunc
+	onInterrupt func()
+)
+
+// NewRootContext creates the context of the command being run. The context
+// is cancelled when the process receives SIGINT or SIGTERM; a further signal
+// after that exits immediately. The returned function cancels the context
+// and stops handling signals.
+func NewRootContext() context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	mu.Lock()
+	rootCtx = ctx
+	mu.Unlock()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				mu.Lock()
+				fn := onInterrupt
+				onInterrupt = nil
+				mu.Unlock()
+
+				switch {
+				case fn != nil:
+					log.Info().
+						Str("signal", sig.String()).
+						Msg("finishing the work in progress, signal again to cancel it")
+					fn()
+				case ctx.Err() == nil:
+					log.Warn().
+						Str("signal", sig.String()).
+						Msg("cancelling, signal again to exit immediately")
+					cancel()
+				default:
+					log.Warn().
+						Str("signal", sig.String()).
+						Msg("exiting without waiting for cancelled work")
+					os.Exit(1)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+
+		mu.Lock()
+		if stopTimeout != nil {
+			stopTimeout()
+		}
+		mu.Unlock()
+	}
+}
+
+// SetTimeout bounds the context of the command being run to timeout. It has
+// no effect when timeout is 0.
+func SetTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	rootCtx, stopTimeout = context.WithTimeout(rootCtx, timeout)
+}
+
+// Context returns the context of the command being run.
+func Context() context.Context {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return rootCtx
+}
+
+// OnInterrupt makes the first SIGINT or SIGTERM call fn instead of
+// cancelling the context, so that a long-running command can stop once its
+// current work is done. A second signal cancels the context.
+func OnInterrupt(fn func()) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	onInterrupt = fn
+}
@@ -8,7 +8,13 @@
 
 package command
 
-import "github.com/spf13/cobra"
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const viperKeyAnnotation = "tsg_viper_key"
 
 type SetupFunc func(parent *Command) error
 
@@ -16,3 +22,24 @@ type Command struct {
 	Cobra *cobra.Command
 	Setup SetupFunc
 }
+
+// BindFlag binds the named flag to a viper key and records the key on the
+// flag so the binding can be re-applied by Rebind.
+func BindFlag(flags *pflag.FlagSet, key string, name string) {
+	flags.SetAnnotation(name, viperKeyAnnotation, []string{key})
+	viper.BindPFlag(key, flags.Lookup(name))
+}
+
+// Rebind re-applies the viper bindings recorded on the flags of cmd.
+// Several commands share configuration keys and viper only keeps the most
+// recent binding for a key, so the command being executed must re-bind its
+// own flags before it runs.
+func Rebind(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if keys, ok := f.Annotations[viperKeyAnnotation]; ok {
+			for _, key := range keys {
+				viper.BindPFlag(key, f)
+			}
+		}
+	})
+}
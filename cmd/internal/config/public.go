@@ -14,17 +14,101 @@ const (
 	KeySshKeyMaterial = "general.key-material"
 	KeySshKeyID       = "general.key-id"
 
-	KeyTsgGroupName  = "compute.tsg.name"
-	KeyTsgTemplateID = "compute.tsg.template-id"
+	KeyTsgGroupName   = "compute.tsg.name"
+	KeyTsgTemplateID  = "compute.tsg.template-id"
+	KeyTsgDatacenters = "compute.tsg.datacenters"
+
+	KeyScaleParallelism       = "compute.scale.parallelism"
+	KeyScaleTerminationPolicy = "compute.scale.termination-policy"
+	KeyScaleOutCooldown       = "compute.scale.scale-out-cooldown"
+	KeyScaleInCooldown        = "compute.scale.scale-in-cooldown"
+	KeyScaleOnFailure         = "compute.scale.on-failure"
+	KeyScaleForce             = "compute.scale.force"
 
 	KeyInstanceCount        = "compute.instance.count"
 	KeyInstanceFirewall     = "compute.instance.firewall"
 	KeyInstanceState        = "compute.instance.state"
+	KeyInstanceProvisioning = "compute.instance.provisioning-timeout"
+	KeyInstanceReadyTimeout = "compute.instance.ready-timeout"
+	KeyInstanceReadyPoll    = "compute.instance.ready-poll-interval"
 	KeyInstanceNetwork      = "compute.instance.networks"
 	KeyInstanceTag          = "compute.instance.tag"
 	KeyInstanceMetadata     = "compute.instance.metadata"
 	KeyInstanceAffinityRule = "compute.instance.affinity"
 	KeyInstanceUserdata     = "compute.instance.userdata"
+	KeyInstanceSpread       = "compute.instance.spread"
+
+	KeyHealthCheckType        = "compute.health-check.type"
+	KeyHealthCheckPort        = "compute.health-check.port"
+	KeyHealthCheckPath        = "compute.health-check.path"
+	KeyHealthCheckStatus      = "compute.health-check.status"
+	KeyHealthCheckInterval    = "compute.health-check.interval"
+	KeyHealthCheckTimeout     = "compute.health-check.timeout"
+	KeyHealthCheckHealthy     = "compute.health-check.healthy-threshold"
+	KeyHealthCheckUnhealthy   = "compute.health-check.unhealthy-threshold"
+	KeyHealthCheckGracePeriod = "compute.health-check.grace-period"
+
+	KeyHookPreLaunch              = "compute.hooks.pre-launch.run"
+	KeyHookPreLaunchTimeout       = "compute.hooks.pre-launch.timeout"
+	KeyHookPreLaunchOnFailure     = "compute.hooks.pre-launch.on-failure"
+	KeyHookPostLaunch             = "compute.hooks.post-launch.run"
+	KeyHookPostLaunchTimeout      = "compute.hooks.post-launch.timeout"
+	KeyHookPostLaunchOnFailure    = "compute.hooks.post-launch.on-failure"
+	KeyHookPreTerminate           = "compute.hooks.pre-terminate.run"
+	KeyHookPreTerminateTimeout    = "compute.hooks.pre-terminate.timeout"
+	KeyHookPreTerminateOnFailure  = "compute.hooks.pre-terminate.on-failure"
+	KeyHookPostTerminate          = "compute.hooks.post-terminate.run"
+	KeyHookPostTerminateTimeout   = "compute.hooks.post-terminate.timeout"
+	KeyHookPostTerminateOnFailure = "compute.hooks.post-terminate.on-failure"
+
+	KeyWarmPoolSize   = "compute.warm-pool.size"
+	KeyWarmPoolMaxAge = "compute.warm-pool.max-age"
+
+	KeyRefreshMaxSurge       = "compute.refresh.max-surge"
+	KeyRefreshMaxUnavailable = "compute.refresh.max-unavailable"
+
+	KeyRebalanceMaxPerNode = "compute.rebalance.max-per-node"
+	KeyRebalanceDryRun     = "compute.rebalance.dry-run"
+
+	KeyStateDir = "general.state-dir"
+	KeyTimeout  = "general.timeout"
+
+	KeyRetryMaxAttempts = "general.retry.max-attempts"
+	KeyRetryBaseDelay   = "general.retry.base-delay"
+	KeyRetryMaxDelay    = "general.retry.max-delay"
+
+	KeyLockMode    = "lock.mode"
+	KeyLockTimeout = "lock.timeout"
+
+	KeyAgentInterval   = "agent.interval"
+	KeyAgentJitter     = "agent.jitter"
+	KeyAgentMaxBackoff = "agent.max-backoff"
+
+	KeyAutoscalePrometheusURL = "autoscale.prometheus-url"
+	KeyAutoscaleQuery         = "autoscale.query"
+	KeyAutoscaleTarget        = "autoscale.target-value"
+	KeyAutoscaleMetricType    = "autoscale.metric-type"
+	KeyAutoscaleMinCount      = "autoscale.min-count"
+	KeyAutoscaleMaxCount      = "autoscale.max-count"
+	KeyAutoscaleDampening     = "autoscale.scale-in-dampening"
+	KeyAutoscaleTolerance     = "autoscale.target-tolerance"
+	KeyAutoscaleCMONURL       = "autoscale.cmon-url"
+	KeyAutoscaleCMONMetric    = "autoscale.cmon-metric"
+	KeyAutoscaleCMONAggregate = "autoscale.cmon-aggregation"
+	KeyAutoscaleCMONRate      = "autoscale.cmon-rate-window"
+
+	KeyScheduleName     = "schedule.name"
+	KeyScheduleCron     = "schedule.cron"
+	KeyScheduleTimezone = "schedule.timezone"
+	KeyScheduleCount    = "schedule.count"
+	KeyScheduleMinCount = "schedule.min-count"
+	KeyScheduleMaxCount = "schedule.max-count"
+	KeyScheduleNext     = "schedule.next"
+
+	KeyPlanOutputFile = "plan.out"
+	KeyPlanJSON       = "plan.json"
+
+	KeyApplySpecFile = "apply.filename"
 
 	KeyPackageId = "compute.package.id"
 
@@ -0,0 +1,921 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+// Package flags holds the command line flags shared by the commands that
+// operate on a Triton Service Group.
+package flags
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joyent/tsg-cli/cmd/agent/autoscale"
+	"github.com/joyent/tsg-cli/cmd/agent/cmon"
+	"github.com/joyent/tsg-cli/cmd/agent/scale"
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// SetupTsgNameFlag adds the flag naming a group.
+func SetupTsgNameFlag(parent *command.Command) {
+	{
+		const (
+			key          = config.KeyTsgGroupName
+			longName     = "tsg-name"
+			defaultValue = ""
+			description  = "TSG Name"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		parent.Cobra.MarkFlagRequired(longName)
+	}
+}
+
+// SetupGroupFlags adds the flags identifying a group and its template.
+func SetupGroupFlags(parent *command.Command) {
+	SetupTsgNameFlag(parent)
+
+	{
+		const (
+			key          = config.KeyTsgTemplateID
+			longName     = "template-id"
+			defaultValue = ""
+			description  = "TSG Template ID"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		parent.Cobra.MarkFlagRequired(longName)
+	}
+
+	SetupInstanceStateFlags(parent)
+}
+
+// SetupInstanceStateFlags adds the flags deciding which members count
+// toward a group's size.
+func SetupInstanceStateFlags(parent *command.Command) {
+	{
+		const (
+			key         = config.KeyInstanceState
+			longName    = "state"
+			description = `Instance state counted toward the expected instance count (e.g.
+running). Instances that are stopped, failed, offline or incomplete
+are replaced, while instances that are provisioning or changing state
+(e.g. stopping) are counted as pending. This option can be used
+multiple times.`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.StringSlice(longName, []string{scale.StateRunning}, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key          = config.KeyInstanceProvisioning
+			longName     = "provisioning-timeout"
+			defaultValue = 15 * time.Minute
+			description  = "Age after which an instance that is still provisioning is considered failed and replaced"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+}
+
+// SetupDatacenterFlag adds the flag spreading a group across data centers.
+func SetupDatacenterFlag(parent *command.Command) {
+	{
+		const (
+			key         = config.KeyTsgDatacenters
+			longName    = "datacenter"
+			description = `Data center to spread the TSG's instances across, as name or
+name=weight. Instances are divided between data centers in proportion
+to their weights (default 1). Use * for every data center CloudAPI
+knows about. This option can be used multiple times. Without it the
+TSG stays in the data center of the configured CloudAPI URL.`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.StringSlice(longName, nil, description)
+		command.BindFlag(flags, key, longName)
+	}
+}
+
+// SetupCountFlag adds the flag holding the desired size of a group.
+func SetupCountFlag(parent *command.Command) {
+	{
+		const (
+			key          = config.KeyInstanceCount
+			longName     = "count"
+			shortName    = "c"
+			defaultValue = ""
+			description  = "Expected Instance Count. Required unless an autoscaling metric or a schedule is set"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.StringP(longName, shortName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+	}
+}
+
+// ValidateCount checks that the desired size of a group is either given
+// with --count, computed by an autoscaling policy or set by a schedule.
+func ValidateCount() error {
+	if viper.GetString(config.KeyInstanceCount) != "" {
+		return nil
+	}
+
+	if viper.GetString(config.KeyAutoscaleQuery) == "" && viper.GetString(config.KeyAutoscaleCMONMetric) == "" &&
+		!scale.GroupHasSchedules(viper.GetString(config.KeyAccount), viper.GetString(config.KeyTsgGroupName)) {
+		return fmt.Errorf(`required flag "count" not set`)
+	}
+
+	return nil
+}
+
+// ValidateGroupFlags checks the flags shared by the commands that plan or
+// reconcile a group's instance count.
+func ValidateGroupFlags() error {
+	if err := ValidateCount(); err != nil {
+		return err
+	}
+	if err := scale.ValidateSpread(viper.GetString(config.KeyInstanceSpread)); err != nil {
+		return err
+	}
+	if viper.GetString(config.KeyAutoscaleCMONMetric) != "" && viper.GetString(config.KeyAutoscaleQuery) == "" {
+		if err := cmon.ValidateCredentials(); err != nil {
+			return err
+		}
+	}
+	return scale.ValidateTerminationPolicies(viper.GetStringSlice(config.KeyScaleTerminationPolicy))
+}
+
+// SetupAutoscaleFlags adds the flags configuring target-tracking
+// autoscaling, which computes the desired size of a group from a metric
+// instead of taking it from --count.
+func SetupAutoscaleFlags(parent *command.Command) {
+	{
+		const (
+			key          = config.KeyAutoscalePrometheusURL
+			longName     = "prometheus-url"
+			defaultValue = ""
+			description  = "Base URL of the Prometheus-compatible HTTP API queried for the autoscaling metric"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleQuery
+			longName     = "query"
+			defaultValue = ""
+			description  = `PromQL query returning the autoscaling metric. A query returning
+several series is averaged. When set, the desired instance count
+is computed from the metric and --count is ignored.`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleCMONMetric
+			longName     = "cmon-metric"
+			defaultValue = ""
+			description  = `Triton Container Monitor metric read from each running instance
+and used as the autoscaling metric, e.g. "load_average". Divide
+by a second metric to track a utilization, e.g.
+"mem_agg_usage/mem_limit". Ignored when --query is set. CMON
+needs the SSH private key given with --key-material, a key held
+by an SSH agent can not be used.`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleCMONURL
+			longName     = "cmon-url"
+			defaultValue = ""
+			description  = "Triton Container Monitor endpoint, e.g. https://cmon.us-east-1.triton.zone:9163. Derived from the CloudAPI URL when not set"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleCMONAggregate
+			longName     = "cmon-aggregation"
+			defaultValue = cmon.AggregateAverage
+			description  = `How the CMON metric of each instance is combined. One of
+"average", "sum", "min" or "max". A sum fails unless the metric
+of every running instance is read.`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleCMONRate
+			longName     = "cmon-rate-window"
+			defaultValue = time.Duration(0)
+			description  = "Treat the CMON metric as a counter and use its per-second rate over this window, e.g. for \"cpu_user_usage\". Disabled when 0"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleTarget
+			longName     = "target-value"
+			defaultValue = 0.0
+			description  = "Value of the autoscaling metric to hold the TSG at"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Float64(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleMetricType
+			longName     = "metric-type"
+			defaultValue = autoscale.MetricAverage
+			description  = `How the autoscaling metric relates to the instance count. One of
+"average" (a per-instance value such as CPU utilization) or
+"total" (a value for the whole TSG such as requests per second).`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleMinCount
+			longName     = "min-count"
+			defaultValue = 1
+			description  = "Minimum instance count when autoscaling"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Int(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleMaxCount
+			longName     = "max-count"
+			defaultValue = 0
+			description  = "Maximum instance count when autoscaling. At most 1000 when 0"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Int(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleDampening
+			longName     = "scale-in-dampening"
+			defaultValue = 0.5
+			description  = "Fraction of a computed scale-in applied at once, so that capacity is released gradually"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Float64(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyAutoscaleTolerance
+			longName     = "target-tolerance"
+			defaultValue = 0.1
+			description  = "Fraction of the target value within which the metric is considered on target and the instance count is left alone"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Float64(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+}
+
+// SetupTerminationPolicyFlag adds the flag choosing which members are
+// deleted first.
+func SetupTerminationPolicyFlag(parent *command.Command) {
+	{
+		const (
+			key         = config.KeyScaleTerminationPolicy
+			longName    = "termination-policy"
+			description = `Policy used to choose which instances are deleted when scaling in.
+One of "oldest-first", "newest-first", "non-running-first",
+"most-crowded-node-first" or "oldest-template-first". This option
+can be used multiple times; later policies break ties left by
+earlier ones.`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.StringSlice(longName, []string{scale.DefaultTerminationPolicy}, description)
+		command.BindFlag(flags, key, longName)
+	}
+}
+
+// SetupWarmPoolFlags adds the flags configuring the warm pool of stopped
+// instances kept for fast scale-out.
+func SetupWarmPoolFlags(parent *command.Command) {
+	{
+		const (
+			key          = config.KeyWarmPoolSize
+			longName     = "warm-pool-size"
+			defaultValue = 0
+			description  = "Number of instances to stop instead of delete on scale-in and start again on scale-out. The warm pool is disabled when 0"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Int(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyWarmPoolMaxAge
+			longName     = "warm-pool-max-age"
+			defaultValue = 24 * time.Hour
+			description  = "Time after which an instance in the warm pool is deleted. Instances never expire when 0"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+}
+
+// SetupCooldownFlags adds the flags controlling how soon a group may scale
+// again after scaling out or in.
+func SetupCooldownFlags(parent *command.Command) {
+	{
+		const (
+			key          = config.KeyScaleOutCooldown
+			longName     = "scale-out-cooldown"
+			defaultValue = time.Duration(0)
+			description  = "Minimum time between scale-outs of the TSG. Disabled when 0"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyScaleInCooldown
+			longName     = "scale-in-cooldown"
+			defaultValue = time.Duration(0)
+			description  = "Minimum time between scale-ins of the TSG. Disabled when 0"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyScaleForce
+			longName     = "force"
+			defaultValue = false
+			description  = "Scale even if a cooldown is in effect (defaults to false)"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Bool(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+}
+
+// SetupLockFlags adds the flags controlling the lock held while a group is
+// reconciled.
+func SetupLockFlags(parent *command.Command) {
+	{
+		const (
+			key          = config.KeyLockMode
+			longName     = "lock"
+			defaultValue = scale.LockLocal
+			description  = `How to stop two scalers reconciling the same TSG at once. One of
+"local" (a lock file, for scalers on the same host), "cloudapi" (a
+lease stored in a tag on the TSG's instances, for scalers on
+different hosts, which needs at least one instance) or "none".
+CloudAPI can not update a tag atomically, so "cloudapi" is best
+effort: two scalers that try to take a free lease at the same moment
+can, rarely, both hold it.`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyLockTimeout
+			longName     = "lock-timeout"
+			defaultValue = time.Minute
+			description  = "Time to wait for another scaler to release the TSG lock"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+}
+
+// SetupOnFailureFlag adds the flag choosing what happens to instances that
+// are created but fail to launch.
+func SetupOnFailureFlag(parent *command.Command) {
+	{
+		const (
+			key          = config.KeyScaleOnFailure
+			longName     = "on-failure"
+			defaultValue = scale.DefaultOnFailure
+			description  = `What to do with an instance that is created but does not become
+ready or cannot be tagged. One of "rollback" (delete it) or "keep"
+(tag it tsg.status=failed and leave it out of the TSG until it is
+deleted by hand).`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+}
+
+// SetupReadyFlags adds the flags controlling how long launched and started
+// instances are waited on to become ready.
+func SetupReadyFlags(parent *command.Command) {
+	{
+		const (
+			key          = config.KeyInstanceReadyTimeout
+			longName     = "ready-timeout"
+			defaultValue = 5 * time.Minute
+			description  = "Maximum time to wait for a launched or started instance to be running"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyInstanceReadyPoll
+			longName     = "ready-poll-interval"
+			defaultValue = time.Second
+			description  = "How often to check whether launched or started instances are running"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+}
+
+// SetupHealthCheckFlags adds the flags describing the application health
+// check run against members of a group.
+func SetupHealthCheckFlags(parent *command.Command) {
+	{
+		const (
+			key          = config.KeyHealthCheckType
+			longName     = "health-check"
+			defaultValue = ""
+			description  = `Health check run against each instance's primary IP, "http" or
+"tcp". Launched instances must pass it to be ready, and members that
+fail it are replaced. Disabled by default.`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key          = config.KeyHealthCheckPort
+			longName     = "health-check-port"
+			defaultValue = 0
+			description  = "Port the health check connects to"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Int(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key          = config.KeyHealthCheckPath
+			longName     = "health-check-path"
+			defaultValue = "/"
+			description  = `Path requested by an HTTP health check, "/" is prepended if missing`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyHealthCheckStatus
+			longName     = "health-check-status"
+			defaultValue = 200
+			description  = "Status an HTTP health check expects"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Int(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyHealthCheckInterval
+			longName     = "health-check-interval"
+			defaultValue = 5 * time.Second
+			description  = "Time between consecutive health check probes of an instance"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyHealthCheckTimeout
+			longName     = "health-check-timeout"
+			defaultValue = 2 * time.Second
+			description  = "Time after which a health check probe fails"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyHealthCheckHealthy
+			longName     = "healthy-threshold"
+			defaultValue = 2
+			description  = "Consecutive passed health checks a launched instance needs to be ready"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Int(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyHealthCheckUnhealthy
+			longName     = "unhealthy-threshold"
+			defaultValue = 3
+			description  = "Consecutive failed health checks after which a member is replaced"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Int(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyHealthCheckGracePeriod
+			longName     = "health-check-grace-period"
+			defaultValue = 5 * time.Minute
+			description  = "Time after an instance is created before failed health checks make it unhealthy"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+}
+
+// SetupHookFlags adds the flags configuring the lifecycle hooks run around
+// launching and terminating instances.
+func SetupHookFlags(parent *command.Command) {
+	setupHookFlags(parent, "pre-launch", "before an instance is launched",
+		config.KeyHookPreLaunch, config.KeyHookPreLaunchTimeout, config.KeyHookPreLaunchOnFailure)
+	setupHookFlags(parent, "post-launch", "once a launched instance is ready",
+		config.KeyHookPostLaunch, config.KeyHookPostLaunchTimeout, config.KeyHookPostLaunchOnFailure)
+	setupHookFlags(parent, "pre-terminate", "before an instance is deleted",
+		config.KeyHookPreTerminate, config.KeyHookPreTerminateTimeout, config.KeyHookPreTerminateOnFailure)
+	setupHookFlags(parent, "post-terminate", "after an instance is deleted",
+		config.KeyHookPostTerminate, config.KeyHookPostTerminateTimeout, config.KeyHookPostTerminateOnFailure)
+}
+
+func setupHookFlags(parent *command.Command, event, when, runKey, timeoutKey, onFailureKey string) {
+	flags := parent.Cobra.Flags()
+
+	{
+		longName := event + "-hook"
+		description := fmt.Sprintf(`Command or http(s) URL run %s. A command is run
+with /bin/sh -c and gets the event and instance as JSON on stdin; a URL is
+POSTed the same JSON.`, when)
+
+		flags.String(longName, "", description)
+		command.BindFlag(flags, runKey, longName)
+	}
+
+	{
+		const defaultValue = 30 * time.Second
+
+		longName := event + "-hook-timeout"
+		description := fmt.Sprintf("Maximum time the %s hook may run", event)
+
+		flags.Duration(longName, defaultValue, description)
+		command.BindFlag(flags, timeoutKey, longName)
+
+		viper.SetDefault(timeoutKey, defaultValue)
+	}
+
+	{
+		const defaultValue = scale.HookAbort
+
+		longName := event + "-hook-on-failure"
+		description := fmt.Sprintf(`What happens when the %s hook fails. One of
+"abort" (fail the action) or "continue".`, event)
+		if strings.HasPrefix(event, "post-") {
+			description += ` The action has already happened, so "abort" reports
+it as failed; a launched instance is then cleaned up as set by --on-failure.`
+		}
+
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, onFailureKey, longName)
+
+		viper.SetDefault(onFailureKey, defaultValue)
+	}
+}
+
+// SetupParallelismFlag adds the flag bounding concurrent creates and deletes.
+func SetupParallelismFlag(parent *command.Command) {
+	{
+		const (
+			key          = config.KeyScaleParallelism
+			longName     = "parallelism"
+			shortName    = "p"
+			defaultValue = 1
+			description  = "Maximum number of instances to create or delete concurrently"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.IntP(longName, shortName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+}
+
+// SetupInstanceFlags adds the flags describing instances launched into a
+// group.
+func SetupInstanceFlags(parent *command.Command) {
+	{
+		const (
+			key         = config.KeyInstanceTag
+			longName    = "tag"
+			shortName   = "t"
+			description = "Instance Tags. This flag can be used multiple times"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.StringSliceP(longName, shortName, nil, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		flags := parent.Cobra.PersistentFlags()
+		flags.SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+			switch name {
+			case "tag":
+				name = "tags"
+				break
+			}
+
+			return pflag.NormalizedName(name)
+		})
+	}
+
+	{
+		const (
+			key          = config.KeyPackageId
+			longName     = "pkg-id"
+			defaultValue = ""
+			description  = "Package id (defaults to ''). This takes precedence over 'pkg-name'"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key          = config.KeyImageId
+			longName     = "img-id"
+			defaultValue = ""
+			description  = "Image id (defaults to ''). This takes precedence over 'img-name'"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key          = config.KeyInstanceFirewall
+			longName     = "firewall"
+			defaultValue = false
+			description  = "Enable Cloud Firewall on this instance (defaults to false)"
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.Bool(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key         = config.KeyInstanceNetwork
+			longName    = "networks"
+			shortName   = "N"
+			description = "One or more comma-separated networks IDs. This option can be used multiple times."
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.StringSliceP(longName, shortName, nil, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key         = config.KeyInstanceMetadata
+			longName    = "metadata"
+			shortName   = "m"
+			description = `Add metadata when creating the instance. Metadata are key/value
+			       pairs available on the instance API object as the "metadata"
+			       field, and inside the instance via the "mdata-*" commands. DATA
+			       is one of: a "key=value" string (bool and numeric "value" are
+				   converted to that type). This option can be used multiple times.`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.StringSliceP(longName, shortName, nil, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key         = config.KeyInstanceAffinityRule
+			longName    = "affinity"
+			description = `Affinity rules for selecting a server for this instance. Rules
+have one of the following forms: "instance==INST" (the new
+instance must be on the same server as INST), "instance!=INST"
+(new inst must *not* be on the same server as INST),
+"instance==~INST"" (*attempt* to place on the same server as
+INST), or "instance!=~INST" (*attempt* to place on a server
+other than INST's). "INST" is an existing instance name or id.
+There are two shortcuts: "inst" may be used instead of
+"instance" and "instance==INST" can be shortened to just "INST".
+This option can be used multiple times.`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.StringSlice(longName, nil, description)
+		command.BindFlag(flags, key, longName)
+	}
+
+	{
+		const (
+			key          = config.KeyInstanceSpread
+			longName     = "spread"
+			defaultValue = scale.SpreadNone
+			description  = `How new instances are spread across compute nodes, in addition to
+any --affinity rules. One of "none", "soft" (*attempt* to place each
+instance on a server without another TSG member) or "hard" (each
+instance must be on a server without another TSG member, so launches
+fail once every server has one).`
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+
+		viper.SetDefault(key, defaultValue)
+	}
+
+	{
+		const (
+			key          = config.KeyInstanceUserdata
+			longName     = "userdata"
+			defaultValue = ""
+			description  = "A custom script which will be executed by the instance right after creation, and on every instance reboot."
+		)
+
+		flags := parent.Cobra.Flags()
+		flags.String(longName, defaultValue, description)
+		command.BindFlag(flags, key, longName)
+	}
+}
@@ -0,0 +1,34 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package agent
+
+import (
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/agent/run"
+	"github.com/spf13/cobra"
+)
+
+var subCommands = []*command.Command{
+	run.Cmd,
+}
+
+var Cmd = &command.Command{
+	Cobra: &cobra.Command{
+		Use:   "agent",
+		Short: "long-running triton service group agent",
+	},
+	Setup: func(parent *command.Command) error {
+		for _, cmd := range subCommands {
+			parent.Cobra.AddCommand(cmd.Cobra)
+			cmd.Setup(cmd)
+		}
+
+		return nil
+	},
+}
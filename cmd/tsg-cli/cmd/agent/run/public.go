@@ -0,0 +1,128 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package run
+
+import (
+	"context"
+	"time"
+
+	"github.com/joyent/tsg-cli/cmd/agent/daemon"
+	"github.com/joyent/tsg-cli/cmd/agent/scale"
+	tsgc "github.com/joyent/tsg-cli/cmd/config"
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/joyent/tsg-cli/cmd/internal/flags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var Cmd = &command.Command{
+	Cobra: &cobra.Command{
+		Args:         cobra.NoArgs,
+		Use:          "run",
+		Short:        "continuously scale a triton service group",
+		SilenceUsage: true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := scale.ValidateLockMode(tsgc.GetLockMode()); err != nil {
+				return err
+			}
+			if err := scale.ValidateOnFailure(tsgc.GetOnFailure()); err != nil {
+				return err
+			}
+			if err := scale.ValidateHooks(); err != nil {
+				return err
+			}
+			return flags.ValidateGroupFlags()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := command.Context()
+
+			a, err := command.NewGroupClient(ctx)
+			if err != nil {
+				return err
+			}
+
+			// The first signal lets the current reconciliation finish, the
+			// second cancels it.
+			stop := make(chan struct{})
+			command.OnInterrupt(func() {
+				close(stop)
+			})
+
+			daemon.Run(ctx, func(ctx context.Context) error {
+				return a.WithGroupLock(ctx, tsgc.GetTsgName(), a.MaintainInstanceCount)
+			}, stop)
+
+			return nil
+		},
+	},
+	Setup: func(parent *command.Command) error {
+		flags.SetupGroupFlags(parent)
+		flags.SetupDatacenterFlag(parent)
+		flags.SetupCountFlag(parent)
+		flags.SetupAutoscaleFlags(parent)
+		flags.SetupTerminationPolicyFlag(parent)
+		flags.SetupWarmPoolFlags(parent)
+		flags.SetupCooldownFlags(parent)
+		flags.SetupParallelismFlag(parent)
+		flags.SetupOnFailureFlag(parent)
+		flags.SetupReadyFlags(parent)
+		flags.SetupHealthCheckFlags(parent)
+		flags.SetupHookFlags(parent)
+		flags.SetupLockFlags(parent)
+		flags.SetupInstanceFlags(parent)
+
+		{
+			const (
+				key          = config.KeyAgentInterval
+				longName     = "interval"
+				defaultValue = time.Minute
+				description  = "Time between reconciliations"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.Duration(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			viper.SetDefault(key, defaultValue)
+		}
+
+		{
+			const (
+				key          = config.KeyAgentJitter
+				longName     = "jitter"
+				defaultValue = 10 * time.Second
+				description  = "Maximum random delay added to each interval so that agents do not reconcile in lockstep"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.Duration(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			viper.SetDefault(key, defaultValue)
+		}
+
+		{
+			const (
+				key          = config.KeyAgentMaxBackoff
+				longName     = "max-backoff"
+				defaultValue = 15 * time.Minute
+				description  = "Maximum time between reconciliations after repeated failures"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.Duration(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			viper.SetDefault(key, defaultValue)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,143 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package apply
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joyent/tsg-cli/cmd/agent/health"
+	"github.com/joyent/tsg-cli/cmd/agent/scale"
+	"github.com/joyent/tsg-cli/cmd/agent/spec"
+	tsgc "github.com/joyent/tsg-cli/cmd/config"
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/joyent/tsg-cli/cmd/internal/flags"
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &command.Command{
+	Cobra: &cobra.Command{
+		Args:         cobra.MaximumNArgs(1),
+		Use:          "apply <planfile> | -f <specfile>",
+		Short:        "execute a plan created by 'tsg plan' or reconcile the groups in a spec file",
+		SilenceUsage: true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			switch specFile := tsgc.GetApplySpecFile(); {
+			case specFile != "" && len(args) > 0:
+				return fmt.Errorf("a plan file and a spec file can not both be applied")
+			case specFile == "" && len(args) == 0:
+				return fmt.Errorf("a plan file or a spec file (-f) is required")
+			}
+
+			if err := scale.ValidateLockMode(tsgc.GetLockMode()); err != nil {
+				return err
+			}
+			if err := scale.ValidateOnFailure(tsgc.GetOnFailure()); err != nil {
+				return err
+			}
+			if err := scale.ValidateHooks(); err != nil {
+				return err
+			}
+			return scale.ValidateTerminationPolicies(tsgc.GetTerminationPolicies())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if specFile := tsgc.GetApplySpecFile(); specFile != "" {
+				return applySpec(specFile)
+			}
+
+			p, err := scale.ReadPlanFile(args[0])
+			if err != nil {
+				return err
+			}
+
+			c, err := tsgc.New()
+			if err != nil {
+				return err
+			}
+
+			a, err := scale.NewComputeClient(c)
+			if err != nil {
+				return err
+			}
+
+			check, err := health.NewFromConfig()
+			if err != nil {
+				return err
+			}
+			a.SetHealthCheck(check)
+
+			return a.WithGroupLock(command.Context(), p.TsgName, func(ctx context.Context) error {
+				return a.Apply(ctx, p)
+			})
+		},
+	},
+	Setup: func(parent *command.Command) error {
+		flags.SetupInstanceStateFlags(parent)
+		flags.SetupTerminationPolicyFlag(parent)
+		flags.SetupCooldownFlags(parent)
+		flags.SetupParallelismFlag(parent)
+		flags.SetupOnFailureFlag(parent)
+		flags.SetupReadyFlags(parent)
+		flags.SetupHealthCheckFlags(parent)
+		flags.SetupHookFlags(parent)
+		flags.SetupLockFlags(parent)
+
+		{
+			const (
+				key          = config.KeyApplySpecFile
+				longName     = "filename"
+				shortName    = "f"
+				defaultValue = ""
+				description  = `Reconcile every group declared in this spec file, one after the
+other, as 'tsg scale' would, instead of executing a plan. The format is
+picked by the file's extension: .yaml, .yml, .toml or .hcl.`
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.StringP(longName, shortName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+		}
+
+		return nil
+	},
+}
+
+// applySpec reconciles each group declared in a spec file with its
+// declared count and instance settings.
+func applySpec(path string) error {
+	groups, err := spec.Load(path)
+	if err != nil {
+		return err
+	}
+
+	c, err := tsgc.New()
+	if err != nil {
+		return err
+	}
+
+	check, err := health.NewFromConfig()
+	if err != nil {
+		return err
+	}
+
+	return spec.Reconcile(command.Context(), groups, func(ctx context.Context, g *spec.Group) error {
+		a, err := scale.NewComputeClient(c)
+		if err != nil {
+			return err
+		}
+
+		if err := a.SetDatacenters(ctx, g.Datacenters); err != nil {
+			return err
+		}
+		a.SetHealthCheck(check)
+
+		return a.WithGroupLock(ctx, g.Name, a.MaintainInstanceCount)
+	})
+}
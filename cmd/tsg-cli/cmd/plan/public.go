@@ -0,0 +1,103 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package plan
+
+import (
+	"encoding/json"
+
+	tsgc "github.com/joyent/tsg-cli/cmd/config"
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/joyent/tsg-cli/cmd/internal/flags"
+	"github.com/sean-/conswriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var Cmd = &command.Command{
+	Cobra: &cobra.Command{
+		Args:         cobra.NoArgs,
+		Use:          "plan",
+		Short:        "show the actions scale would take on a triton service group",
+		SilenceUsage: true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return flags.ValidateGroupFlags()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := command.Context()
+
+			a, err := command.NewGroupClient(ctx)
+			if err != nil {
+				return err
+			}
+
+			p, err := a.Plan(ctx)
+			if err != nil {
+				return err
+			}
+
+			if out := tsgc.GetPlanOutputFile(); out != "" {
+				if err := p.WriteFile(out); err != nil {
+					return err
+				}
+			}
+
+			w := conswriter.GetTerminal()
+			if tsgc.GetPlanJSON() {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(p.Redacted())
+			}
+
+			return p.WriteText(w)
+		},
+	},
+	Setup: func(parent *command.Command) error {
+		flags.SetupGroupFlags(parent)
+		flags.SetupDatacenterFlag(parent)
+		flags.SetupCountFlag(parent)
+		flags.SetupAutoscaleFlags(parent)
+		flags.SetupTerminationPolicyFlag(parent)
+		flags.SetupWarmPoolFlags(parent)
+		flags.SetupCooldownFlags(parent)
+		flags.SetupHealthCheckFlags(parent)
+		flags.SetupInstanceFlags(parent)
+
+		{
+			const (
+				key          = config.KeyPlanOutputFile
+				longName     = "out"
+				shortName    = "o"
+				defaultValue = ""
+				description  = "Write the plan to this file so it can be executed with 'tsg apply'"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.StringP(longName, shortName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+		}
+
+		{
+			const (
+				key          = config.KeyPlanJSON
+				longName     = "json"
+				defaultValue = false
+				description  = "Print the plan as JSON (defaults to false)"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.Bool(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			viper.SetDefault(key, defaultValue)
+		}
+
+		return nil
+	},
+}
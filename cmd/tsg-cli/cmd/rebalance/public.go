@@ -0,0 +1,105 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package rebalance
+
+import (
+	"github.com/joyent/tsg-cli/cmd/agent/scale"
+	tsgc "github.com/joyent/tsg-cli/cmd/config"
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/joyent/tsg-cli/cmd/internal/flags"
+	"github.com/sean-/conswriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var Cmd = &command.Command{
+	Cobra: &cobra.Command{
+		Args:         cobra.NoArgs,
+		Use:          "rebalance",
+		Short:        "replace instances on crowded compute nodes",
+		SilenceUsage: true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := scale.ValidateLockMode(tsgc.GetLockMode()); err != nil {
+				return err
+			}
+			if err := scale.ValidateOnFailure(tsgc.GetOnFailure()); err != nil {
+				return err
+			}
+			if err := scale.ValidateHooks(); err != nil {
+				return err
+			}
+			if err := scale.ValidateSpread(tsgc.GetInstanceSpread()); err != nil {
+				return err
+			}
+			if err := scale.ValidateMaxPerNode(tsgc.GetRebalanceMaxPerNode()); err != nil {
+				return err
+			}
+			return scale.ValidateTerminationPolicies(tsgc.GetTerminationPolicies())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := command.NewReplaceClient(command.Context())
+			if err != nil {
+				return err
+			}
+
+			if tsgc.GetRebalanceDryRun() {
+				p, err := a.PlanRebalance(command.Context())
+				if err != nil {
+					return err
+				}
+
+				return p.WriteText(conswriter.GetTerminal())
+			}
+
+			return a.WithGroupLock(command.Context(), tsgc.GetTsgName(), a.Rebalance)
+		},
+	},
+	Setup: func(parent *command.Command) error {
+		flags.SetupGroupFlags(parent)
+		flags.SetupDatacenterFlag(parent)
+		flags.SetupTerminationPolicyFlag(parent)
+		flags.SetupOnFailureFlag(parent)
+		flags.SetupReadyFlags(parent)
+		flags.SetupHealthCheckFlags(parent)
+		flags.SetupHookFlags(parent)
+		flags.SetupLockFlags(parent)
+		flags.SetupInstanceFlags(parent)
+
+		{
+			const (
+				key          = config.KeyRebalanceMaxPerNode
+				longName     = "max-per-node"
+				defaultValue = 0
+				description  = "Number of instances a compute node may hold before instances on it are replaced elsewhere (required)"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.Int(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+		}
+
+		{
+			const (
+				key          = config.KeyRebalanceDryRun
+				longName     = "dry-run"
+				defaultValue = false
+				description  = "Show the instances that would be replaced without replacing them (defaults to false)"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.Bool(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			viper.SetDefault(key, defaultValue)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,95 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package refresh
+
+import (
+	"github.com/joyent/tsg-cli/cmd/agent/scale"
+	tsgc "github.com/joyent/tsg-cli/cmd/config"
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/joyent/tsg-cli/cmd/internal/flags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var Cmd = &command.Command{
+	Cobra: &cobra.Command{
+		Args:         cobra.NoArgs,
+		Use:          "refresh",
+		Short:        "replace instances launched from an outdated template",
+		SilenceUsage: true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := scale.ValidateLockMode(tsgc.GetLockMode()); err != nil {
+				return err
+			}
+			if err := scale.ValidateOnFailure(tsgc.GetOnFailure()); err != nil {
+				return err
+			}
+			if err := scale.ValidateHooks(); err != nil {
+				return err
+			}
+			if err := scale.ValidateSpread(tsgc.GetInstanceSpread()); err != nil {
+				return err
+			}
+			return scale.ValidateTerminationPolicies(tsgc.GetTerminationPolicies())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := command.NewReplaceClient(command.Context())
+			if err != nil {
+				return err
+			}
+
+			return a.WithGroupLock(command.Context(), tsgc.GetTsgName(), a.Refresh)
+		},
+	},
+	Setup: func(parent *command.Command) error {
+		flags.SetupGroupFlags(parent)
+		flags.SetupDatacenterFlag(parent)
+		flags.SetupTerminationPolicyFlag(parent)
+		flags.SetupParallelismFlag(parent)
+		flags.SetupOnFailureFlag(parent)
+		flags.SetupReadyFlags(parent)
+		flags.SetupHealthCheckFlags(parent)
+		flags.SetupHookFlags(parent)
+		flags.SetupLockFlags(parent)
+		flags.SetupInstanceFlags(parent)
+
+		{
+			const (
+				key          = config.KeyRefreshMaxSurge
+				longName     = "max-surge"
+				defaultValue = 1
+				description  = "Number of replacement instances that may be launched above the group's current size"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.Int(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			viper.SetDefault(key, defaultValue)
+		}
+
+		{
+			const (
+				key          = config.KeyRefreshMaxUnavailable
+				longName     = "max-unavailable"
+				defaultValue = 0
+				description  = "Number of outdated instances that may be deleted before their replacements are running"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.Int(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			viper.SetDefault(key, defaultValue)
+		}
+
+		return nil
+	},
+}
@@ -9,9 +9,20 @@
 package cmd
 
 import (
+	"time"
+
+	tsgc "github.com/joyent/tsg-cli/cmd/config"
 	"github.com/joyent/tsg-cli/cmd/internal/command"
 	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/agent"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/apply"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/plan"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/protect"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/rebalance"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/refresh"
 	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/scale"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/schedule"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/unprotect"
 	"github.com/sean-/conswriter"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -19,12 +30,24 @@ import (
 
 var subCommands = []*command.Command{
 	scale.Cmd,
+	plan.Cmd,
+	apply.Cmd,
+	refresh.Cmd,
+	rebalance.Cmd,
+	protect.Cmd,
+	unprotect.Cmd,
+	agent.Cmd,
+	schedule.Cmd,
 }
 
 var rootCmd = &command.Command{
 	Cobra: &cobra.Command{
 		Use:   "tsg",
 		Short: "Joyent Triton Service Groups CLI",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			command.Rebind(cmd)
+			command.SetTimeout(tsgc.GetTimeout())
+		},
 	},
 	Setup: func(parent *command.Command) error {
 		{
@@ -74,7 +97,20 @@ var rootCmd = &command.Command{
 				key          = config.KeySshKeyMaterial
 				longName     = "key-material"
 				defaultValue = ""
-				description  = "This is the private key of an SSH key associated with the Triton account to be used. If this is not set, the private key corresponding to the fingerprint in key_id must be available via an SSH Agent. It can be provided via the SDC_KEY_MATERIAL or TRITON_KEY_MATERIAL environment variables."
+				description  = "This is the private key of an SSH key associated with the Triton account to be used. If this is not set, the private key corresponding to the fingerprint in key_id must be available via an SSH Agent, which --cmon-metric can not use. It can be provided via the SDC_KEY_MATERIAL or TRITON_KEY_MATERIAL environment variables."
+			)
+
+			flags := parent.Cobra.PersistentFlags()
+			flags.String(longName, defaultValue, description)
+			viper.BindPFlag(key, flags.Lookup(longName))
+		}
+
+		{
+			const (
+				key          = config.KeyStateDir
+				longName     = "state-dir"
+				defaultValue = ""
+				description  = "Directory in which tsg keeps state between runs, such as when each TSG last scaled (defaults to ~/.tsg/state)"
 			)
 
 			flags := parent.Cobra.PersistentFlags()
@@ -82,6 +118,62 @@ var rootCmd = &command.Command{
 			viper.BindPFlag(key, flags.Lookup(longName))
 		}
 
+		{
+			const (
+				key          = config.KeyTimeout
+				longName     = "timeout"
+				defaultValue = time.Duration(0)
+				description  = "Maximum time the command may run before its work in progress is cancelled. Unbounded when 0"
+			)
+
+			flags := parent.Cobra.PersistentFlags()
+			flags.Duration(longName, defaultValue, description)
+			viper.BindPFlag(key, flags.Lookup(longName))
+			viper.SetDefault(key, defaultValue)
+		}
+
+		{
+			const (
+				key          = config.KeyRetryMaxAttempts
+				longName     = "retry-max-attempts"
+				defaultValue = 5
+				description  = "Maximum number of attempts of a CloudAPI call that fails with a transient or throttling error. Calls are not retried when 1"
+			)
+
+			flags := parent.Cobra.PersistentFlags()
+			flags.Int(longName, defaultValue, description)
+			viper.BindPFlag(key, flags.Lookup(longName))
+			viper.SetDefault(key, defaultValue)
+		}
+
+		{
+			const (
+				key          = config.KeyRetryBaseDelay
+				longName     = "retry-base-delay"
+				defaultValue = time.Second
+				description  = "Delay before the first retry of a failed CloudAPI call, doubled for each further retry"
+			)
+
+			flags := parent.Cobra.PersistentFlags()
+			flags.Duration(longName, defaultValue, description)
+			viper.BindPFlag(key, flags.Lookup(longName))
+			viper.SetDefault(key, defaultValue)
+		}
+
+		{
+			const (
+				key          = config.KeyRetryMaxDelay
+				longName     = "retry-max-delay"
+				defaultValue = 30 * time.Second
+				description  = "Maximum delay between retries of a failed CloudAPI call"
+			)
+
+			flags := parent.Cobra.PersistentFlags()
+			flags.Duration(longName, defaultValue, description)
+			viper.BindPFlag(key, flags.Lookup(longName))
+			viper.SetDefault(key, defaultValue)
+		}
+
 		return nil
 	},
 }
@@ -90,6 +182,9 @@ func Execute() error {
 
 	rootCmd.Setup(rootCmd)
 
+	cancel := command.NewRootContext()
+	defer cancel()
+
 	conswriter.UsePager(false)
 	//
 	//if err := logger.Setup(); err != nil {
@@ -0,0 +1,147 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package add
+
+import (
+	"github.com/joyent/tsg-cli/cmd/agent/scale"
+	"github.com/joyent/tsg-cli/cmd/agent/schedule"
+	tsgc "github.com/joyent/tsg-cli/cmd/config"
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/joyent/tsg-cli/cmd/internal/flags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var Cmd = &command.Command{
+	Cobra: &cobra.Command{
+		Args:         cobra.NoArgs,
+		Use:          "add",
+		Short:        "add or replace a scheduled scaling action",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s := &schedule.Schedule{
+				Name:     tsgc.GetScheduleName(),
+				Cron:     tsgc.GetScheduleCron(),
+				Timezone: tsgc.GetScheduleTimezone(),
+				MinCount: tsgc.GetScheduleMinCount(),
+				MaxCount: tsgc.GetScheduleMaxCount(),
+			}
+			if cmd.Flags().Changed("count") {
+				count := tsgc.GetScheduleCount()
+				s.Count = &count
+			}
+
+			c, err := tsgc.New()
+			if err != nil {
+				return err
+			}
+
+			a, err := scale.NewComputeClient(c)
+			if err != nil {
+				return err
+			}
+
+			return a.AddSchedule(s)
+		},
+	},
+	Setup: func(parent *command.Command) error {
+		flags.SetupTsgNameFlag(parent)
+
+		{
+			const (
+				key          = config.KeyScheduleName
+				longName     = "name"
+				defaultValue = ""
+				description  = "Schedule name. A schedule with the same name is replaced"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.String(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			parent.Cobra.MarkFlagRequired(longName)
+		}
+
+		{
+			const (
+				key          = config.KeyScheduleCron
+				longName     = "cron"
+				defaultValue = ""
+				description  = `Cron expression of when the schedule takes effect, with the fields
+minute, hour, day of month, month and day of week, e.g.
+"0 8 * * mon-fri". The schedule stays in effect until another
+schedule of the TSG fires.`
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.String(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			parent.Cobra.MarkFlagRequired(longName)
+		}
+
+		{
+			const (
+				key          = config.KeyScheduleTimezone
+				longName     = "timezone"
+				defaultValue = "UTC"
+				description  = "Timezone the cron expression is evaluated in, e.g. America/New_York"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.String(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			viper.SetDefault(key, defaultValue)
+		}
+
+		{
+			const (
+				key          = config.KeyScheduleCount
+				longName     = "count"
+				shortName    = "c"
+				defaultValue = 0
+				description  = "Expected instance count while the schedule is in effect, replacing --count of 'tsg scale'"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.IntP(longName, shortName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+		}
+
+		{
+			const (
+				key          = config.KeyScheduleMinCount
+				longName     = "min-count"
+				defaultValue = 0
+				description  = "Minimum instance count while the schedule is in effect"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.Int(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+		}
+
+		{
+			const (
+				key          = config.KeyScheduleMaxCount
+				longName     = "max-count"
+				defaultValue = 0
+				description  = "Maximum instance count while the schedule is in effect. Unbounded when 0"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.Int(longName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,131 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package list
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joyent/tsg-cli/cmd/agent/scale"
+	"github.com/joyent/tsg-cli/cmd/agent/schedule"
+	tsgc "github.com/joyent/tsg-cli/cmd/config"
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/internal/config"
+	"github.com/joyent/tsg-cli/cmd/internal/flags"
+	"github.com/sean-/conswriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const timeFormat = "2006-01-02 15:04 MST"
+
+var Cmd = &command.Command{
+	Cobra: &cobra.Command{
+		Args:         cobra.NoArgs,
+		Use:          "list",
+		Short:        "list scheduled scaling actions and when they next fire",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := tsgc.New()
+			if err != nil {
+				return err
+			}
+
+			a, err := scale.NewComputeClient(c)
+			if err != nil {
+				return err
+			}
+
+			schedules, err := a.GetSchedules()
+			if err != nil {
+				return err
+			}
+
+			w := conswriter.GetTerminal()
+			if len(schedules) == 0 {
+				fmt.Fprintf(w, "TSG %q has no schedules.\n", tsgc.GetTsgName())
+				return nil
+			}
+
+			now := time.Now()
+			active, fired, err := schedule.Active(schedules, now)
+			if err != nil {
+				return err
+			}
+
+			tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tCRON\tTIMEZONE\tCOUNT\tMIN\tMAX\tACTIVE\tNEXT")
+			for _, s := range schedules {
+				next, err := s.Next(now, tsgc.GetScheduleNext())
+				if err != nil {
+					return err
+				}
+
+				var firings []string
+				for _, t := range next {
+					firings = append(firings, t.Format(timeFormat))
+				}
+
+				count := "-"
+				if s.Count != nil {
+					count = strconv.Itoa(*s.Count)
+				}
+
+				isActive := ""
+				if s == active {
+					isActive = "since " + fired.Format(timeFormat)
+				}
+
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					s.Name, s.Cron, timezone(s), count, bound(s.MinCount), bound(s.MaxCount),
+					isActive, strings.Join(firings, ", "))
+			}
+
+			return tw.Flush()
+		},
+	},
+	Setup: func(parent *command.Command) error {
+		flags.SetupTsgNameFlag(parent)
+
+		{
+			const (
+				key          = config.KeyScheduleNext
+				longName     = "next"
+				shortName    = "n"
+				defaultValue = 3
+				description  = "Number of upcoming firings to show for each schedule"
+			)
+
+			flags := parent.Cobra.Flags()
+			flags.IntP(longName, shortName, defaultValue, description)
+			command.BindFlag(flags, key, longName)
+
+			viper.SetDefault(key, defaultValue)
+		}
+
+		return nil
+	},
+}
+
+func timezone(s *schedule.Schedule) string {
+	if s.Timezone == "" {
+		return "UTC"
+	}
+	return s.Timezone
+}
+
+func bound(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,38 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package schedule
+
+import (
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/schedule/add"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/schedule/list"
+	"github.com/joyent/tsg-cli/cmd/tsg-cli/cmd/schedule/remove"
+	"github.com/spf13/cobra"
+)
+
+var subCommands = []*command.Command{
+	add.Cmd,
+	remove.Cmd,
+	list.Cmd,
+}
+
+var Cmd = &command.Command{
+	Cobra: &cobra.Command{
+		Use:   "schedule",
+		Short: "manage scheduled scaling of a triton service group",
+	},
+	Setup: func(parent *command.Command) error {
+		for _, cmd := range subCommands {
+			parent.Cobra.AddCommand(cmd.Cobra)
+			cmd.Setup(cmd)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,50 @@
+//
+//  Copyright (c) 2018, Joyent, Inc. All rights reserved.
+//
+//  This Source Code Form is subject to the terms of the Mozilla Public
+//  License, v. 2.0. If a copy of the MPL was not distributed with this
+//  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+
+package remove
+
+import (
+	"github.com/joyent/tsg-cli/cmd/agent/scale"
+	tsgc "github.com/joyent/tsg-cli/cmd/config"
+	"github.com/joyent/tsg-cli/cmd/internal/command"
+	"github.com/joyent/tsg-cli/cmd/internal/flags"
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &command.Command{
+	Cobra: &cobra.Command{
+		Args:         cobra.MinimumNArgs(1),
+		Use:          "remove <name>...",
+		Short:        "remove scheduled scaling actions",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := tsgc.New()
+			if err != nil {
+				return err
+			}
+
+			a, err := scale.NewComputeClient(c)
+			if err != nil {
+				return err
+			}
+
+			for _, name := range args {
+				if err := a.RemoveSchedule(name); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	Setup: func(parent *command.Command) error {
+		flags.SetupTsgNameFlag(parent)
+
+		return nil
+	},
+}